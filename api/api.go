@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -64,6 +65,25 @@ func (api *API) Get(url string, body io.Reader) (*http.Response, error) {
 	return api.makeDo("GET", url, body)
 }
 
+// decode decodes a json response into v, returning the api error if the
+// response is an error status.
+func decode(r io.Reader, v interface{}) error {
+	var raw json.RawMessage
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	if len(raw) != 0 && raw[0] == '{' {
+		var s Status
+		if err := json.Unmarshal(raw, &s); err == nil && s.Status == "error" {
+			return s.Error()
+		}
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
 type Status struct {
 	Status string      `json:"status"`
 	Reason interface{} `json:"reason"`
@@ -72,7 +92,7 @@ type Status struct {
 var ErrUnknown = errors.New("unknown api error")
 
 func (s Status) Error() error {
-	if s.Status == "" {
+	if s.Status == "" || s.Status == "ok" {
 		return nil
 	}
 	if s.Reason == nil {
@@ -1,13 +1,16 @@
 package api
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -79,3 +82,61 @@ func Sign(apiKey, apiSecret string, r *http.Request) error {
 
 	return nil
 }
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidAuth      = errors.New("missing or invalid authentication headers")
+)
+
+// Verify checks the authentication headers of a request signed with Sign.
+// The request body is restored so it can be read again.
+func Verify(apiKey, apiSecret string, r *http.Request) error {
+	if r.Header.Get("X-Auth") != fmt.Sprintf("BITSTAMP %s", apiKey) ||
+		r.Header.Get("X-Auth-Version") != "v2" {
+		return ErrInvalidAuth
+	}
+
+	nonce := r.Header.Get("X-Auth-Nonce")
+	timestamp := r.Header.Get("X-Auth-Timestamp")
+	if nonce == "" || timestamp == "" {
+		return ErrInvalidAuth
+	}
+
+	var bodyData []byte
+	if r.Body != nil {
+		var err error
+		bodyData, err = io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(bodyData))
+	}
+
+	host := r.URL.Hostname()
+	if host == "" {
+		host = r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+
+	sig := sign(
+		apiKey,
+		apiSecret,
+		r.Method,
+		host,
+		r.URL.Path,
+		r.URL.Query().Encode(),
+		r.Header.Get("Content-Type"),
+		nonce,
+		timestamp,
+		string(bodyData),
+	)
+
+	if !hmac.Equal([]byte(sig), []byte(r.Header.Get("X-Auth-Signature"))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
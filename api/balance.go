@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"strings"
 
 	"github.com/frizinak/bitstamp/generic"
@@ -21,6 +20,133 @@ func (b Balance) ForCurrency(c generic.Currency) Balance {
 	return n
 }
 
+// Balances parses the raw balance response into typed per-currency and
+// per-pair values. Keys that could not be interpreted end up in Other.
+func (b Balance) Balances() Balances {
+	n := Balances{
+		Currencies: make(map[generic.Currency]CurrencyBalance),
+		Fees:       make(map[generic.CurrencyPair]float64),
+		Other:      make(Balance),
+	}
+
+	fees := make(map[string]float64)
+	for k, v := range b {
+		var c generic.Currency
+		var f string
+		for _, suffix := range balanceFields {
+			if len(k) > len(suffix)+1 && strings.HasSuffix(k, "_"+suffix) {
+				c, f = generic.Currency(k[:len(k)-len(suffix)-1]), suffix
+				break
+			}
+		}
+
+		if f == "" {
+			if strings.HasSuffix(k, "_fee") {
+				fees[k[:len(k)-4]] = v.Value()
+				continue
+			}
+			n.Other[k] = v
+			continue
+		}
+
+		cb := n.Currencies[c]
+		switch f {
+		case "available":
+			cb.Available = v.Value()
+		case "reserved":
+			cb.Reserved = v.Value()
+		case "balance":
+			cb.Total = v.Value()
+		case "withdrawal_fee":
+			cb.WithdrawalFee = v.Value()
+		}
+		n.Currencies[c] = cb
+	}
+
+	for k, v := range fees {
+		pair, ok := n.splitPair(k)
+		if !ok {
+			n.Other[k+"_fee"] = generic.Float64String(v)
+			continue
+		}
+		n.Fees[pair] = v
+	}
+
+	return n
+}
+
+var balanceFields = []string{"withdrawal_fee", "available", "reserved", "balance"}
+
+type CurrencyBalance struct {
+	Available     float64
+	Reserved      float64
+	Total         float64
+	WithdrawalFee float64
+}
+
+type Balances struct {
+	Currencies map[generic.Currency]CurrencyBalance
+	// Fees contains the trading fee percentage per pair.
+	Fees  map[generic.CurrencyPair]float64
+	Other Balance
+}
+
+func (b Balances) Currency(c generic.Currency) CurrencyBalance { return b.Currencies[c] }
+
+func (b Balances) Fee(pair generic.CurrencyPair) (float64, bool) {
+	f, ok := b.Fees[pair]
+	return f, ok
+}
+
+// Add returns the sum of both balances, fees are taken from b unless
+// missing.
+func (b Balances) Add(o Balances) Balances {
+	n := Balances{
+		Currencies: make(map[generic.Currency]CurrencyBalance, len(b.Currencies)),
+		Fees:       make(map[generic.CurrencyPair]float64, len(b.Fees)),
+		Other:      make(Balance, len(b.Other)),
+	}
+
+	for _, l := range []Balances{o, b} {
+		for k, v := range l.Fees {
+			n.Fees[k] = v
+		}
+		for k, v := range l.Other {
+			n.Other[k] = v
+		}
+	}
+
+	for _, l := range []Balances{b, o} {
+		for c, v := range l.Currencies {
+			cb, ok := n.Currencies[c]
+			cb.Available += v.Available
+			cb.Reserved += v.Reserved
+			cb.Total += v.Total
+			if !ok {
+				cb.WithdrawalFee = v.WithdrawalFee
+			}
+			n.Currencies[c] = cb
+		}
+	}
+
+	return n
+}
+
+// splitPair splits a concatenated pair (e.g. btceur) using the currencies
+// present in the balance.
+func (b Balances) splitPair(s string) (generic.CurrencyPair, bool) {
+	for i := 1; i < len(s); i++ {
+		base, counter := generic.Currency(s[:i]), generic.Currency(s[i:])
+		_, okb := b.Currencies[base]
+		_, okc := b.Currencies[counter]
+		if okb && okc {
+			return generic.CurrencyPair{Base: base, Counter: counter}, true
+		}
+	}
+
+	return generic.CurrencyPair{}, false
+}
+
 func (api *API) BalancePair(pair generic.CurrencyPair) (Balance, error) {
 	return api.balance(api.URL("balance", pair.String()))
 }
@@ -29,6 +155,30 @@ func (api *API) Balance() (Balance, error) {
 	return api.balance(api.URL("balance"))
 }
 
+func (api *API) BalancesPair(pair generic.CurrencyPair) (Balances, error) {
+	b, err := api.BalancePair(pair)
+	if err != nil {
+		return Balances{}, err
+	}
+
+	n := b.Balances()
+	if fee, ok := n.Other["fee"]; ok {
+		n.Fees[pair] = fee.Value()
+		delete(n.Other, "fee")
+	}
+
+	return n, nil
+}
+
+func (api *API) Balances() (Balances, error) {
+	b, err := api.Balance()
+	if err != nil {
+		return Balances{}, err
+	}
+
+	return b.Balances(), nil
+}
+
 func (api *API) balance(url string) (Balance, error) {
 	b := make(Balance, 20)
 	res, err := api.Post(url, nil)
@@ -36,10 +186,9 @@ func (api *API) balance(url string) (Balance, error) {
 		return nil, err
 	}
 	defer res.Body.Close()
-	dec := json.NewDecoder(res.Body)
-	if err := dec.Decode(&b); err != nil {
+	if err := decode(res.Body, &b); err != nil {
 		return nil, err
 	}
 
-	return b, err
+	return b, nil
 }
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/frizinak/bitstamp/generic"
+)
+
+// OHLCSteps are the candle intervals supported by the ohlc endpoint.
+var OHLCSteps = []time.Duration{
+	time.Minute,
+	3 * time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	4 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	72 * time.Hour,
+}
+
+// OHLCLimit is the maximum amount of candles returned per request.
+const OHLCLimit = 1000
+
+type OHLC struct {
+	Time   generic.UnixString    `json:"timestamp"`
+	Open   generic.Float64String `json:"open"`
+	High   generic.Float64String `json:"high"`
+	Low    generic.Float64String `json:"low"`
+	Close  generic.Float64String `json:"close"`
+	Volume generic.Float64String `json:"volume"`
+}
+
+type OHLCResult struct {
+	Pair generic.CurrencyPair
+	Step time.Duration
+	List []OHLC
+}
+
+func validOHLCStep(step time.Duration) bool {
+	for _, s := range OHLCSteps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// OHLC returns at most limit candles of the given step starting at start
+// or ending at end. Either can be left zero.
+func (api *API) OHLC(pair generic.CurrencyPair, step time.Duration, limit int, start, end time.Time) (OHLCResult, error) {
+	r := OHLCResult{Pair: pair, Step: step}
+	if !validOHLCStep(step) {
+		return r, fmt.Errorf("invalid ohlc step %s", step)
+	}
+	if limit <= 0 || limit > OHLCLimit {
+		limit = OHLCLimit
+	}
+
+	u, err := url.Parse(api.URL("ohlc", pair.String()))
+	if err != nil {
+		return r, err
+	}
+	q := u.Query()
+	q.Set("step", strconv.Itoa(int(step/time.Second)))
+	q.Set("limit", strconv.Itoa(limit))
+	if !start.IsZero() {
+		q.Set("start", strconv.FormatInt(start.Unix(), 10))
+	}
+	if !end.IsZero() {
+		q.Set("end", strconv.FormatInt(end.Unix(), 10))
+	}
+	u.RawQuery = q.Encode()
+
+	res, err := api.Get(u.String(), nil)
+	if err != nil {
+		return r, err
+	}
+	defer res.Body.Close()
+
+	var data struct {
+		Data struct {
+			OHLC []OHLC `json:"ohlc"`
+		} `json:"data"`
+	}
+	if err := decode(res.Body, &data); err != nil {
+		return r, err
+	}
+	r.List = data.Data.OHLC
+
+	return r, nil
+}
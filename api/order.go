@@ -47,10 +47,10 @@ func (o LimitOrder) Params(p url.Values) {
 	if o.Daily {
 		p.Set("daily_order", "True")
 	}
-	if o.Daily {
+	if o.IOC {
 		p.Set("ioc_order", "True")
 	}
-	if o.Daily {
+	if o.FOK {
 		p.Set("fok_order", "True")
 	}
 }
@@ -88,7 +88,11 @@ func NewInstantSellOrder(pair generic.CurrencyPair, amount float64) SimpleOrder
 }
 
 type OrderResponse struct {
-	ID generic.Uint64String `json:"id"`
+	ID       generic.Uint64String  `json:"id"`
+	DateTime generic.UTCDateString `json:"datetime"`
+	Type     TradeType             `json:"type"`
+	Price    generic.Float64String `json:"price"`
+	Amount   generic.Float64String `json:"amount"`
 	Status
 }
 
@@ -110,3 +114,90 @@ func (api *API) Place(order Order) (OrderResponse, error) {
 
 	return o, o.Error()
 }
+
+type OpenOrder struct {
+	ID           generic.Uint64String  `json:"id"`
+	DateTime     generic.UTCDateString `json:"datetime"`
+	Type         TradeType             `json:"type"`
+	Price        generic.Float64String `json:"price"`
+	Amount       generic.Float64String `json:"amount"`
+	CurrencyPair string                `json:"currency_pair"`
+}
+
+// Pair parses CurrencyPair, e.g.: BTC/USD.
+func (o OpenOrder) Pair() generic.CurrencyPair { return parseSlashPair(o.CurrencyPair) }
+
+func parseSlashPair(str string) generic.CurrencyPair {
+	p := strings.SplitN(strings.ToLower(str), "/", 2)
+	if len(p) != 2 {
+		return generic.CurrencyPair{}
+	}
+	return generic.CurrencyPair{Base: generic.Currency(p[0]), Counter: generic.Currency(p[1])}
+}
+
+// OpenOrders lists the open orders of the given pair.
+func (api *API) OpenOrders(pair generic.CurrencyPair) ([]OpenOrder, error) {
+	return api.openOrders(api.URL("open_orders", pair.String()))
+}
+
+// OpenOrdersAll lists the open orders of all pairs.
+func (api *API) OpenOrdersAll() ([]OpenOrder, error) {
+	return api.openOrders(api.URL("open_orders", "all"))
+}
+
+func (api *API) openOrders(url string) ([]OpenOrder, error) {
+	list := make([]OpenOrder, 0)
+	res, err := api.Post(url, nil)
+	if err != nil {
+		return list, err
+	}
+	defer res.Body.Close()
+
+	return list, decode(res.Body, &list)
+}
+
+type CanceledOrder struct {
+	ID           generic.Uint64String  `json:"id"`
+	Type         TradeType             `json:"type"`
+	Price        generic.Float64String `json:"price"`
+	Amount       generic.Float64String `json:"amount"`
+	CurrencyPair string                `json:"currency_pair"`
+}
+
+// Pair parses CurrencyPair, e.g.: BTC/USD.
+func (o CanceledOrder) Pair() generic.CurrencyPair { return parseSlashPair(o.CurrencyPair) }
+
+// Cancel cancels the order with the given id.
+func (api *API) Cancel(id uint64) (CanceledOrder, error) {
+	var o CanceledOrder
+	params := url.Values{}
+	params.Set("id", strconv.FormatUint(id, 10))
+	res, err := api.Post(api.URL("cancel_order"), strings.NewReader(params.Encode()))
+	if err != nil {
+		return o, err
+	}
+	defer res.Body.Close()
+
+	return o, decode(res.Body, &o)
+}
+
+// CancelAll cancels all open orders.
+func (api *API) CancelAll() ([]CanceledOrder, error) {
+	var r struct {
+		Success  bool            `json:"success"`
+		Canceled []CanceledOrder `json:"canceled"`
+	}
+	res, err := api.Post(api.URL("cancel_all_orders"), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if err := decode(res.Body, &r); err != nil {
+		return nil, err
+	}
+	if !r.Success {
+		return r.Canceled, ErrUnknown
+	}
+	return r.Canceled, nil
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"github.com/frizinak/bitstamp/generic"
+)
+
+// OrderBookResult contains bids and asks as price and amount pairs.
+type OrderBookResult struct {
+	Time      generic.UnixString      `json:"timestamp"`
+	MicroTime generic.UnixMicroString `json:"microtimestamp"`
+
+	Bids [][2]generic.Float64String `json:"bids"`
+	Asks [][2]generic.Float64String `json:"asks"`
+}
+
+func (api *API) OrderBook(pair generic.CurrencyPair) (OrderBookResult, error) {
+	var o OrderBookResult
+	res, err := api.Get(api.URL("order_book", pair.String()), nil)
+	if err != nil {
+		return o, err
+	}
+	defer res.Body.Close()
+
+	return o, decode(res.Body, &o)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/frizinak/bitstamp/generic"
+)
+
+func (api *API) transfer(endpoint string, c generic.Currency, amount float64, subAccount uint64) error {
+	params := url.Values{}
+	params.Set("amount", strconv.FormatFloat(amount, 'f', -1, 64))
+	params.Set("currency", strings.ToUpper(c.String()))
+	if subAccount != 0 {
+		params.Set("subAccount", strconv.FormatUint(subAccount, 10))
+	}
+
+	res, err := api.Post(api.URL(endpoint), strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var s Status
+	return decode(res.Body, &s)
+}
+
+// TransferToMain transfers funds from a sub account to the main account.
+// subAccount can be 0 when called with the credentials of the sub account
+// itself.
+func (api *API) TransferToMain(c generic.Currency, amount float64, subAccount uint64) error {
+	return api.transfer("transfer-to-main", c, amount, subAccount)
+}
+
+// TransferFromMain transfers funds from the main account to the given sub
+// account.
+func (api *API) TransferFromMain(c generic.Currency, amount float64, subAccount uint64) error {
+	return api.transfer("transfer-from-main", c, amount, subAccount)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"errors"
 
 	"github.com/frizinak/bitstamp/generic"
@@ -17,7 +16,10 @@ type TickerResult struct {
 	VWAP   generic.Float64String `json:"vwap"`
 	Volume generic.Float64String `json:"volume"`
 
-	Open generic.Float64String `json:"open"`
+	// Open is the first price of the current UTC day, Open24 the price 24
+	// hours ago.
+	Open   generic.Float64String `json:"open"`
+	Open24 generic.Float64String `json:"open_24"`
 
 	Bid generic.Float64String `json:"bid"`
 	Ask generic.Float64String `json:"ask"`
@@ -52,12 +54,7 @@ func (api *API) Ticker(pair generic.CurrencyPair, interval TickerInterval) (Tick
 	}
 
 	defer res.Body.Close()
-	dec := json.NewDecoder(res.Body)
-	if err := dec.Decode(&t); err != nil {
-		return t, err
-	}
-
-	return t, err
+	return t, decode(res.Body, &t)
 }
 
 func (api *API) TickerHourly(pair generic.CurrencyPair) (TickerResult, error) {
@@ -1,8 +1,9 @@
 package api
 
 import (
-	"encoding/json"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/frizinak/bitstamp/generic"
 )
@@ -16,6 +17,32 @@ const (
 	TradesHistoryDay    TradeHistory = "day"
 )
 
+// Duration returns the window covered by the history.
+func (h TradeHistory) Duration() time.Duration {
+	switch h {
+	case TradesHistoryMinute:
+		return time.Minute
+	case TradesHistoryHour:
+		return time.Hour
+	case TradesHistoryDay:
+		return time.Hour * 24
+	}
+	return 0
+}
+
+// TradeHistoryFor returns the smallest history covering d, capped at a day.
+func TradeHistoryFor(d time.Duration) TradeHistory {
+	switch {
+	case d <= 0:
+		return TradesHistoryNone
+	case d <= time.Minute:
+		return TradesHistoryMinute
+	case d <= time.Hour:
+		return TradesHistoryHour
+	}
+	return TradesHistoryDay
+}
+
 type TradeType byte
 
 func (t *TradeType) UnmarshalJSON(d []byte) error {
@@ -60,7 +87,16 @@ func (api *API) Trades(history TradeHistory, pair generic.CurrencyPair) (Trades,
 	}
 	defer res.Body.Close()
 
-	dec := json.NewDecoder(res.Body)
 	t.List = make([]Trade, 0, 100)
-	return t, dec.Decode(&t.List)
+	return t, decode(res.Body, &t.List)
+}
+
+func (t TradeType) String() string {
+	switch t {
+	case Buy:
+		return "buy"
+	case Sell:
+		return "sell"
+	}
+	return "#" + strconv.Itoa(int(t))
 }
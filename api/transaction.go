@@ -2,50 +2,100 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/frizinak/bitstamp/generic"
 )
 
-type TransactionType byte
+type TransactionType uint32
 
 func (t *TransactionType) UnmarshalJSON(d []byte) error {
-	b := generic.ByteString(*t)
-	if err := b.UnmarshalJSON(d); err != nil {
+	var n generic.Uint64String
+	if err := n.UnmarshalJSON(d); err != nil {
 		return err
 	}
-	*t = TransactionType(b)
+	if n.Value() > math.MaxUint32 {
+		return fmt.Errorf("transaction type %d out of range", n.Value())
+	}
+	*t = TransactionType(n)
 	return nil
 }
 
+func (t TransactionType) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatUint(uint64(t), 10)), nil
+}
+
 func (t TransactionType) String() string {
-	switch t {
-	case Deposit:
-		return "deposit"
-	case Withdrawal:
-		return "withdrawal"
-	case MarketTrade:
-		return "trade"
-	case SubAccountTransfer:
-		return "sub account transfer"
-	case ReferralReward:
-		return "referral reward"
+	if n, ok := transactionTypeNames[t]; ok {
+		return n
+	}
+
+	return "#" + strconv.FormatUint(uint64(t), 10)
+}
+
+// ParseTransactionType parses the output of TransactionType.String or a
+// numeric code.
+func ParseTransactionType(s string) (TransactionType, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer("_", " ", "-", " ").Replace(s)
+	for t, n := range transactionTypeNames {
+		if n == s {
+			return t, nil
+		}
+	}
+
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid transaction type '%s'", s)
 	}
 
-	return "n/a"
+	return TransactionType(n), nil
+}
+
+func TransactionTypes() []TransactionType {
+	list := make([]TransactionType, 0, len(transactionTypeNames))
+	for t := range transactionTypeNames {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
 }
 
+// Bitstamp does not document the codes of conversions and fee rebates,
+// they are reported as their numeric code, e.g. "#42", until known.
 const (
-	Deposit            TransactionType = 0
-	Withdrawal         TransactionType = 1
-	MarketTrade        TransactionType = 2
-	SubAccountTransfer TransactionType = 14
-	ReferralReward     TransactionType = 32
+	Deposit              TransactionType = 0
+	Withdrawal           TransactionType = 1
+	MarketTrade          TransactionType = 2
+	SubAccountTransfer   TransactionType = 14
+	StakingCredit        TransactionType = 25
+	StakingSent          TransactionType = 26
+	StakingReward        TransactionType = 27
+	ReferralReward       TransactionType = 32
+	SettlementTransfer   TransactionType = 33
+	InterAccountTransfer TransactionType = 35
 )
 
+var transactionTypeNames = map[TransactionType]string{
+	Deposit:              "deposit",
+	Withdrawal:           "withdrawal",
+	MarketTrade:          "trade",
+	SubAccountTransfer:   "sub account transfer",
+	StakingCredit:        "staking credit",
+	StakingSent:          "staking sent",
+	StakingReward:        "staking reward",
+	ReferralReward:       "referral reward",
+	SettlementTransfer:   "settlement transfer",
+	InterAccountTransfer: "inter account transfer",
+}
+
 type Transaction struct {
 	DateTime generic.UTCDateString `json:"datetime"`
 	ID       generic.Uint64String  `json:"id"`
@@ -54,72 +104,304 @@ type Transaction struct {
 
 	Fee generic.Float64String `json:"fee"`
 
-	USD    generic.Float64String `json:"usd"`
-	EUR    generic.Float64String `json:"eur"`
-	BTC    generic.Float64String `json:"btc"`
-	XRP    generic.Float64String `json:"xrp"`
-	GBP    generic.Float64String `json:"gbp"`
-	LTC    generic.Float64String `json:"ltc"`
-	ETH    generic.Float64String `json:"eth"`
-	BCH    generic.Float64String `json:"bch"`
-	XLM    generic.Float64String `json:"xlm"`
-	PAX    generic.Float64String `json:"pax"`
-	LINK   generic.Float64String `json:"link"`
-	OMG    generic.Float64String `json:"omg"`
-	USDC   generic.Float64String `json:"usdc"`
-	BTCUSD generic.Float64String `json:"btc_usd"`
+	// Amounts contains every non-zero currency amount of the transaction.
+	Amounts map[generic.Currency]float64 `json:"-"`
+	// Rates contains every {base}_{counter} rate field.
+	Rates map[generic.CurrencyPair]float64 `json:"-"`
+
+	// Pair and Rate describe the traded pair and the rate it was traded at,
+	// only set for trades.
+	Pair generic.CurrencyPair `json:"-"`
+	Rate float64              `json:"-"`
+}
+
+func (t *Transaction) UnmarshalJSON(d []byte) error {
+	type transaction Transaction
+	var n transaction
+	if err := json.Unmarshal(d, &n); err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(d, &raw); err != nil {
+		return err
+	}
+
+	n.Amounts = make(map[generic.Currency]float64)
+	n.Rates = make(map[generic.CurrencyPair]float64)
+	for k, v := range raw {
+		switch k {
+		case "datetime", "id", "type", "order_id", "fee":
+			continue
+		}
+
+		var f generic.Float64String
+		if err := f.UnmarshalJSON(v); err != nil {
+			continue
+		}
+
+		if ix := strings.IndexByte(k, '_'); ix != -1 {
+			pair := generic.CurrencyPair{
+				Base:    generic.Currency(k[:ix]),
+				Counter: generic.Currency(k[ix+1:]),
+			}
+			n.Rates[pair] = f.Value()
+			continue
+		}
+
+		if f != 0 {
+			n.Amounts[generic.Currency(k)] = f.Value()
+		}
+	}
+
+	*t = Transaction(n)
+	t.derivePair()
+
+	return nil
+}
+
+func (t *Transaction) derivePair() {
+	for pair, rate := range t.Rates {
+		if rate == 0 {
+			continue
+		}
+		_, okb := t.Amounts[pair.Base]
+		_, okc := t.Amounts[pair.Counter]
+		if okb && okc {
+			t.Pair, t.Rate = pair, rate
+			return
+		}
+	}
+}
+
+// Amount returns the amount of the given currency, 0 if not present.
+func (t Transaction) Amount(c generic.Currency) float64 { return t.Amounts[c] }
+
+// EffectiveRate returns the price per unit of base currency paid or
+// received including fees.
+func (t Transaction) EffectiveRate() float64 {
+	base, counter := t.Amounts[t.Pair.Base], t.Amounts[t.Pair.Counter]
+	if base == 0 {
+		return 0
+	}
+	if counter < 0 {
+		return (-counter + t.Fee.Value()) / base
+	}
+	return (counter - t.Fee.Value()) / -base
+}
+
+// TransactionsQuery filters and bounds a user_transactions walk.
+type TransactionsQuery struct {
+	// Pair limits the results to a single pair, left empty all
+	// transactions are returned.
+	Pair generic.CurrencyPair
+
+	Offset  int
+	SinceID uint64
+	Since   time.Time
+	Until   time.Time
+	Desc    bool
+	// Types limits the results to the given transaction types, applied
+	// client side.
+	Types []TransactionType
+
+	// Limit is the maximum amount of transactions to return, 0 means all.
+	Limit int
+	// PageSize is the amount of transactions fetched per request,
+	// defaults to 1000, the maximum bitstamp allows.
+	PageSize int
+}
+
+func (q TransactionsQuery) hasType(typ TransactionType) bool {
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
 }
 
+// bitstamp only accepts since and until timestamps at most this old,
+// older boundaries are applied client side.
+const maxTimestampAge = time.Hour * 24 * 30
+
 type Transactions struct {
 	api *API
+	q   TransactionsQuery
+
+	n      int
+	offset int
+	lastID uint64
+	done   bool
 
 	List []Transaction
 }
 
 func (api *API) NewTransactions() *Transactions {
-	return &Transactions{api: api, List: make([]Transaction, 0, 100)}
+	return api.NewTransactionsQuery(TransactionsQuery{})
+}
+
+func (api *API) NewTransactionsQuery(q TransactionsQuery) *Transactions {
+	if q.PageSize <= 0 || q.PageSize > 1000 {
+		q.PageSize = 1000
+	}
+	return &Transactions{
+		api:    api,
+		q:      q,
+		offset: q.Offset,
+		lastID: q.SinceID,
+		List:   make([]Transaction, 0, 100),
+	}
 }
 
+// Next fetches the next page and appends it to List. Returns the amount of
+// transactions added, 0 when exhausted.
 func (t *Transactions) Next() (int, error) {
-	if len(t.List) == 0 {
-		res, err := t.api.Transactions(100, true)
+	page, err := t.page()
+	t.List = append(t.List, page...)
+	return len(page), err
+}
+
+// Walk calls fn for each transaction without retaining them in List
+// until fn returns false or all transactions have been walked.
+func (t *Transactions) Walk(fn func(Transaction) bool) error {
+	for {
+		page, err := t.page()
 		if err != nil {
-			return 0, err
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+		for _, tr := range page {
+			if !fn(tr) {
+				t.done = true
+				return nil
+			}
+		}
+	}
+}
+
+func (t *Transactions) page() ([]Transaction, error) {
+	for !t.done {
+		list, err := t.fetch()
+		if err != nil || len(list) != 0 {
+			return list, err
 		}
-		*t = *res
-		return len(t.List), nil
 	}
 
-	last := t.List[len(t.List)-1]
-	res, err := t.api.TransactionsSinceID(last.ID.Value())
+	return nil, nil
+}
+
+func (t *Transactions) fetch() ([]Transaction, error) {
+	params := url.Values{}
+	params.Set("sort", "asc")
+	if t.q.Desc {
+		params.Set("sort", "desc")
+	}
+
+	cutoff := time.Now().Add(-maxTimestampAge)
+	if !t.q.Since.IsZero() && t.q.Since.After(cutoff) {
+		params.Set("since_timestamp", strconv.FormatInt(t.q.Since.Unix(), 10))
+	}
+	if !t.q.Until.IsZero() && t.q.Until.After(cutoff) {
+		params.Set("until_timestamp", strconv.FormatInt(t.q.Until.Unix(), 10))
+	}
+
+	// Ascending pages are walked by id, descending ones by offset.
+	dupe := false
+	if t.lastID != 0 {
+		params.Set("since_id", strconv.FormatUint(t.lastID, 10))
+		dupe = !t.q.Desc && t.n != 0
+	}
+	if t.offset != 0 {
+		params.Set("offset", strconv.Itoa(t.offset))
+	}
+
+	limit := t.q.PageSize
+	if t.q.Limit > 0 && t.q.Limit-t.n < limit {
+		limit = t.q.Limit - t.n
+	}
+	if dupe && limit < 1000 {
+		limit++
+	}
+	params.Set("limit", strconv.Itoa(limit))
+
+	res, err := t.api.transactions(t.q.Pair, params)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	list := res.List
+	if len(list) < limit {
+		t.done = true
+	}
+	if dupe && len(list) != 0 && list[0].ID.Value() == t.lastID { // BRUH, why
+		list = list[1:]
+	}
+
+	if t.q.Desc {
+		t.offset += len(res.List)
+	} else if len(res.List) != 0 {
+		t.lastID = res.List[len(res.List)-1].ID.Value()
+		t.offset = 0
+	}
+
+	n := make([]Transaction, 0, len(list))
+	for _, tr := range list {
+		d := tr.DateTime.Value()
+		if !t.q.Since.IsZero() && d.Before(t.q.Since) {
+			if t.q.Desc {
+				t.done = true
+				break
+			}
+			continue
+		}
+		if !t.q.Until.IsZero() && d.After(t.q.Until) {
+			if !t.q.Desc {
+				t.done = true
+				break
+			}
+			continue
+		}
+		if !t.q.hasType(tr.Type) {
+			continue
+		}
+		n = append(n, tr)
 	}
-	if len(res.List) == 0 {
-		return 0, nil
+
+	if t.q.Limit > 0 && len(n) > t.q.Limit-t.n {
+		n = n[:t.q.Limit-t.n]
 	}
-	if res.List[0].ID == last.ID { // BRUH, why
-		res.List = res.List[1:]
+
+	t.n += len(n)
+	if t.q.Limit > 0 && t.n >= t.q.Limit {
+		t.done = true
 	}
 
-	t.List = append(t.List, res.List...)
-	return len(res.List), nil
+	return n, nil
 }
 
-func (api *API) transactions(params url.Values) (*Transactions, error) {
+func (api *API) transactions(pair generic.CurrencyPair, params url.Values) (*Transactions, error) {
 	var r io.Reader
 	if params != nil {
 		r = strings.NewReader(params.Encode())
 	}
-	res, err := api.Post(api.URL("user_transactions"), r)
+	u := api.URL("user_transactions")
+	if pair != (generic.CurrencyPair{}) {
+		u = api.URL("user_transactions", pair.String())
+	}
+	res, err := api.Post(u, r)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	dec := json.NewDecoder(res.Body)
 	t := &Transactions{api: api, List: make([]Transaction, 0, 100)}
-	return t, dec.Decode(&t.List)
+	return t, decode(res.Body, &t.List)
 }
 
 func (api *API) Transactions(limit int, asc bool) (*Transactions, error) {
@@ -128,6 +410,7 @@ func (api *API) Transactions(limit int, asc bool) (*Transactions, error) {
 		sort = "asc"
 	}
 	return api.transactions(
+		generic.CurrencyPair{},
 		url.Values{
 			"limit": {strconv.Itoa(limit)}, "sort": {sort},
 		},
@@ -136,6 +419,7 @@ func (api *API) Transactions(limit int, asc bool) (*Transactions, error) {
 
 func (api *API) TransactionsSinceID(id uint64) (*Transactions, error) {
 	return api.transactions(
+		generic.CurrencyPair{},
 		url.Values{
 			"since_id": {strconv.FormatUint(id, 10)},
 		},
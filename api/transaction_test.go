@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTransactionTypeRoundTrip(t *testing.T) {
+	list := append(TransactionTypes(), 3, 255, 256, 300, 70000)
+	for _, typ := range list {
+		p, err := ParseTransactionType(typ.String())
+		if err != nil {
+			t.Fatalf("%d: %s", typ, err)
+		}
+		if p != typ {
+			t.Errorf("%d: parsed %q as %d", typ, typ.String(), p)
+		}
+
+		d, err := json.Marshal(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var u TransactionType
+		if err := json.Unmarshal(d, &u); err != nil {
+			t.Fatalf("%d: %s", typ, err)
+		}
+		if u != typ {
+			t.Errorf("%d: json round trip gave %d", typ, u)
+		}
+	}
+
+	var u TransactionType
+	if err := json.Unmarshal([]byte(`"300"`), &u); err != nil || u != 300 {
+		t.Errorf("string code: %d %v", u, err)
+	}
+	if err := json.Unmarshal([]byte(`4294967296`), &u); err == nil {
+		t.Error("out of range code accepted")
+	}
+}
@@ -0,0 +1,262 @@
+// Package backtest evaluates a strategy on historical candles or trades,
+// simulating its orders with a bitstamp.Paper account.
+package backtest
+
+import (
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/strategy"
+	"github.com/frizinak/bitstamp/ws"
+)
+
+type Config struct {
+	Pair generic.CurrencyPair
+	// Interval of the candles built from trades.
+	Interval time.Duration
+	// Fee is the trading fee percentage.
+	Fee float64
+	// Slippage is the percentage market orders are filled worse than the
+	// last price.
+	Slippage float64
+	// Balances are the starting balances.
+	Balances map[generic.Currency]float64
+	Limits   strategy.Limits
+}
+
+// Point is the value of the account in the counter currency at Time.
+type Point struct {
+	Time   time.Time
+	Equity float64
+	// Drawdown is the decline from the highest equity so far in percent.
+	Drawdown float64
+}
+
+type Result struct {
+	Strategy string
+	Pair     generic.CurrencyPair
+	Start    time.Time
+	End      time.Time
+
+	Equity []Point
+	// Trades are the simulated fills.
+	Trades []bitstamp.Transaction
+	// Balances are the final balances.
+	Balances map[generic.Currency]float64
+
+	// Return and MaxDrawdown are in percent.
+	Return      float64
+	MaxDrawdown float64
+	// Sharpe is the annualized sharpe ratio of the equity curve with a
+	// risk free rate of zero.
+	Sharpe float64
+	Fees   float64
+
+	// Rejected is the amount of orders refused by the limits, Killed
+	// the reason the kill switch engaged if it did.
+	Rejected int
+	Killed   string
+}
+
+type runner struct {
+	cfg   Config
+	paper *bitstamp.Paper
+	rt    *strategy.Runtime
+	res   Result
+	peak  float64
+}
+
+func newRunner(cfg Config, s strategy.Strategy) *runner {
+	paper := bitstamp.NewPaper(cfg.Fee, cfg.Balances)
+	return &runner{
+		cfg:   cfg,
+		paper: paper,
+		rt:    strategy.New(cfg.Pair, s, paper, strategy.PaperFills(paper), cfg.Interval, cfg.Limits),
+		res:   Result{Strategy: s.String(), Pair: cfg.Pair},
+	}
+}
+
+// market replaces the book with unlimited liquidity around price,
+// widened by the slippage.
+func (r *runner) market(t time.Time, price float64) {
+	slip := r.cfg.Slippage / 100
+	r.paper.UpdateBook(r.cfg.Pair, bitstamp.OrderBook{
+		Date: t,
+		Bids: []bitstamp.OrderBookLevel{{Price: price * (1 - slip), Amount: math.MaxFloat64}},
+		Asks: []bitstamp.OrderBookLevel{{Price: price * (1 + slip), Amount: math.MaxFloat64}},
+	})
+}
+
+func (r *runner) mark(t time.Time, price float64) error {
+	b, err := r.paper.BalancesPair(r.cfg.Pair)
+	if err != nil {
+		return err
+	}
+	base, counter := b.Currency(r.cfg.Pair.Base).Total, b.Currency(r.cfg.Pair.Counter).Total
+	p := Point{Time: t, Equity: counter + base*price}
+	r.peak = math.Max(r.peak, p.Equity)
+	if r.peak > 0 {
+		p.Drawdown = (1 - p.Equity/r.peak) * 100
+	}
+	if r.res.Start.IsZero() {
+		r.res.Start = t
+	}
+	r.res.End = t
+	r.res.Equity = append(r.res.Equity, p)
+	return nil
+}
+
+// candle replays the range of a candle against resting orders, then
+// hands it to the strategy at its close.
+func (r *runner) candle(c candle.Candle) error {
+	end := c.End()
+	if !c.Empty() {
+		r.paper.AddTrade(r.cfg.Pair, bitstamp.Trade{Date: end, Price: c.Low, Amount: math.MaxFloat64})
+		r.paper.AddTrade(r.cfg.Pair, bitstamp.Trade{Date: end, Price: c.High, Amount: math.MaxFloat64})
+	}
+	r.market(end, c.Close)
+	if err := r.rt.Candle(c); err != nil {
+		return err
+	}
+	return r.mark(end, c.Close)
+}
+
+// result stops the runtime and computes the statistics, err is the error
+// that ended the run if any.
+func (r *runner) result(err error) (Result, error) {
+	if serr := r.rt.Stop(); err == nil {
+		err = serr
+	}
+	res := r.res
+	res.Rejected = r.rt.Rejected
+	_, res.Killed = r.rt.Killed()
+	res.Trades = r.paper.Transactions()
+	for _, t := range res.Trades {
+		res.Fees += t.Fee.Value()
+	}
+	b, _ := r.paper.Balances()
+	res.Balances = make(map[generic.Currency]float64, len(b.Currencies))
+	for c, v := range b.Currencies {
+		res.Balances[c] = v.Total
+	}
+
+	if len(res.Equity) == 0 {
+		return res, err
+	}
+	first, last := res.Equity[0].Equity, res.Equity[len(res.Equity)-1].Equity
+	if first != 0 {
+		res.Return = (last/first - 1) * 100
+	}
+
+	returns := make([]float64, 0, len(res.Equity))
+	for i, p := range res.Equity {
+		res.MaxDrawdown = math.Max(res.MaxDrawdown, p.Drawdown)
+		if i != 0 && res.Equity[i-1].Equity != 0 {
+			returns = append(returns, p.Equity/res.Equity[i-1].Equity-1)
+		}
+	}
+	res.Sharpe = sharpe(returns, r.cfg.Interval)
+	return res, err
+}
+
+func sharpe(returns []float64, interval time.Duration) float64 {
+	if len(returns) < 2 || interval <= 0 {
+		return 0
+	}
+	var mean float64
+	for _, v := range returns {
+		mean += v
+	}
+	mean /= float64(len(returns))
+	var variance float64
+	for _, v := range returns {
+		variance += (v - mean) * (v - mean)
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	if std == 0 {
+		return 0
+	}
+	periods := float64(365*24*time.Hour) / float64(interval)
+	return mean / std * math.Sqrt(periods)
+}
+
+// Candles runs the strategy on candles ordered by time, e.g. from
+// candle.Fetch. Orders placed by the strategy fill at the close of the
+// candle, resting limit orders fill if a later candle reaches their price.
+func Candles(cfg Config, s strategy.Strategy, candles []candle.Candle) (Result, error) {
+	if len(candles) != 0 && cfg.Interval == 0 {
+		cfg.Interval = candles[0].Interval
+	}
+	r := newRunner(cfg, s)
+	for _, c := range candles {
+		if err := r.candle(c); err != nil {
+			return r.result(err)
+		}
+	}
+	return r.result(nil)
+}
+
+// Trades runs the strategy on trades ordered by time, e.g. from a
+// bitstamp.TradeStore or a recording. Market orders fill at the price of
+// the last trade, resting limit orders fill when a trade reaches their
+// price for at most the traded amount. The strategy also receives candles
+// of cfg.Interval built from the trades.
+func Trades(cfg Config, s strategy.Strategy, trades []bitstamp.Trade) (Result, error) {
+	if cfg.Interval == 0 {
+		return Result{}, errors.New("backtest: no candle interval")
+	}
+	r := newRunner(cfg, s)
+	b := candle.NewBuilder(cfg.Interval, true)
+	for _, t := range trades {
+		for _, e := range b.Add(t) {
+			if !e.Final {
+				continue
+			}
+			if err := r.mark(e.End(), e.Close); err != nil {
+				return r.result(err)
+			}
+		}
+
+		r.paper.AddTrade(cfg.Pair, t)
+		r.market(t.Date, t.Price)
+		if err := r.rt.Trade(t); err != nil {
+			return r.result(err)
+		}
+	}
+	if c, ok := b.Current(); ok {
+		if err := r.mark(c.End(), c.Close); err != nil {
+			return r.result(err)
+		}
+	}
+	return r.result(nil)
+}
+
+// ReadRecording returns the trades of pair in a websocket recording made
+// with ws.Recorder.
+func ReadRecording(rd io.Reader, pair generic.CurrencyPair) ([]bitstamp.Trade, error) {
+	rp, err := ws.NewReplay(rd, 0)
+	if err != nil {
+		return nil, err
+	}
+	client := bitstamp.New(nil, rp)
+	ch := make(chan bitstamp.Trade, 64)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.TradesLiveSince(time.Time{}, pair, ch)
+		close(ch)
+	}()
+
+	list := make([]bitstamp.Trade, 0)
+	for t := range ch {
+		list = append(list, t)
+	}
+	if err := <-errs; err != nil && err != io.EOF {
+		return list, err
+	}
+	return list, nil
+}
@@ -0,0 +1,137 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/strategy"
+)
+
+// script places the order returned for the n-th candle, if any.
+type script struct {
+	n      int
+	orders func(n int) api.Order
+}
+
+func (s *script) Candle(ctx strategy.Context, c candle.Candle) error {
+	o := s.orders(s.n)
+	s.n++
+	if o == nil {
+		return nil
+	}
+	_, err := ctx.Exec.Place(o)
+	return err
+}
+
+func (s *script) Trade(ctx strategy.Context, t bitstamp.Trade) error { return nil }
+func (s *script) String() string                                     { return "script" }
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestCandles(t *testing.T) {
+	pair := bitstamp.BTCUSD()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	ohlc := [][4]float64{
+		{100, 100, 100, 100},
+		{100, 105, 80, 80},
+		{80, 120, 80, 120},
+		// Reaches the resting sell at 130.
+		{120, 135, 110, 110},
+		{110, 110, 90, 90},
+	}
+	candles := make([]candle.Candle, len(ohlc))
+	for i, v := range ohlc {
+		candles[i] = candle.Candle{
+			Start:    start.Add(time.Duration(i) * time.Hour),
+			Interval: time.Hour,
+			Open:     v[0], High: v[1], Low: v[2], Close: v[3],
+			Volume: 1, QuoteVolume: v[3], Trades: 1,
+		}
+	}
+
+	s := &script{orders: func(n int) api.Order {
+		switch n {
+		case 0:
+			return api.NewBuyOrder(pair, 1)
+		case 2:
+			return api.NewLimitSell(pair, 1, 130)
+		}
+		return nil
+	}}
+	cfg := Config{
+		Pair:     pair,
+		Fee:      0.5,
+		Slippage: 1,
+		Balances: map[generic.Currency]float64{bitstamp.USD: 1000},
+	}
+	res, err := Candles(cfg, s, candles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The market buy fills at the close plus 1% slippage, the limit sell
+	// at its price.
+	if len(res.Trades) != 2 {
+		t.Fatalf("%d trades, expected 2", len(res.Trades))
+	}
+	fills := []struct{ base, rate, fee float64 }{{1, 101, 0.505}, {-1, 130, 0.65}}
+	for i, f := range fills {
+		tr := res.Trades[i]
+		if !near(tr.Values[pair.Base], f.base) || !near(tr.Rate, f.rate) || !near(tr.Fee.Value(), f.fee) {
+			t.Errorf("trade %d: %g btc at %g fee %g, expected %g at %g fee %g", i, tr.Values[pair.Base], tr.Rate, tr.Fee.Value(), f.base, f.rate, f.fee)
+		}
+	}
+	if !near(res.Fees, 1.155) {
+		t.Errorf("fees %g, expected 1.155", res.Fees)
+	}
+	if !near(res.Balances[bitstamp.USD], 1027.845) || !near(res.Balances[bitstamp.BTC], 0) {
+		t.Errorf("balances %v", res.Balances)
+	}
+
+	equity := []float64{998.495, 978.495, 1018.495, 1027.845, 1027.845}
+	if len(res.Equity) != len(equity) {
+		t.Fatalf("%d equity points, expected %d", len(res.Equity), len(equity))
+	}
+	for i, p := range res.Equity {
+		if !near(p.Equity, equity[i]) || !p.Time.Equal(candles[i].End()) {
+			t.Errorf("equity %d: %g at %s, expected %g at %s", i, p.Equity, p.Time, equity[i], candles[i].End())
+		}
+	}
+	if !near(res.Equity[1].Drawdown, 20/998.495*100) || res.Equity[2].Drawdown != 0 {
+		t.Errorf("drawdowns %g %g", res.Equity[1].Drawdown, res.Equity[2].Drawdown)
+	}
+	if !res.Start.Equal(candles[0].End()) || !res.End.Equal(candles[4].End()) {
+		t.Errorf("range %s - %s", res.Start, res.End)
+	}
+
+	if !near(res.MaxDrawdown, 2.0030145368780015) {
+		t.Errorf("max drawdown %g", res.MaxDrawdown)
+	}
+	if !near(res.Return, 2.9394238328684708) {
+		t.Errorf("return %g", res.Return)
+	}
+	if !near(res.Sharpe, 27.694175277265895) {
+		t.Errorf("sharpe %g", res.Sharpe)
+	}
+	if res.Rejected != 0 || res.Killed != "" {
+		t.Errorf("rejected %d killed %q", res.Rejected, res.Killed)
+	}
+}
+
+func TestSharpe(t *testing.T) {
+	if v := sharpe([]float64{0.01}, time.Hour); v != 0 {
+		t.Errorf("sharpe of a single return %g", v)
+	}
+	if v := sharpe([]float64{0.01, 0.01, 0.01}, time.Hour); v != 0 {
+		t.Errorf("sharpe without variance %g", v)
+	}
+	// mean 0.01, sample std 0.01, 365 daily periods.
+	if v := sharpe([]float64{0, 0.01, 0.02}, 24*time.Hour); !near(v, math.Sqrt(365)) {
+		t.Errorf("sharpe %g, expected %g", v, math.Sqrt(365))
+	}
+}
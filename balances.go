@@ -0,0 +1,155 @@
+package bitstamp
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+// BalanceChange returns the effect of t on the account balance. Trade fees
+// are paid in the counter currency, fees of other transactions are charged
+// to their only currency if they have exactly one.
+func BalanceChange(t Transaction) map[generic.Currency]float64 {
+	n := make(map[generic.Currency]float64, len(t.Values))
+	for c, v := range t.Values {
+		n[c] = v
+	}
+
+	fee := t.Fee.Value()
+	switch {
+	case fee == 0:
+	case t.Type == api.MarketTrade && t.Pair.Counter != "":
+		n[t.Pair.Counter] -= fee
+	case len(t.Values) == 1:
+		for c := range n {
+			n[c] -= fee
+		}
+	}
+	return n
+}
+
+// BalancePoint is the balance right after a transaction.
+type BalancePoint struct {
+	Date          time.Time
+	TransactionID uint64
+	Balances      map[generic.Currency]float64
+}
+
+type BalanceHistory struct {
+	// Start is the balance before the first transaction.
+	Start  map[generic.Currency]float64
+	Points []BalancePoint
+}
+
+// ReplayBalances replays the transactions forwards starting from the given
+// balance, usually empty for the complete history of an account.
+func ReplayBalances(start map[generic.Currency]float64, list []Transaction) BalanceHistory {
+	list = sortTransactions(list)
+	h := BalanceHistory{Start: copyAmounts(start), Points: make([]BalancePoint, 0, len(list))}
+	cur := copyAmounts(start)
+	for _, t := range list {
+		for c, v := range BalanceChange(t) {
+			cur[c] += v
+		}
+		h.Points = append(h.Points, BalancePoint{
+			Date:          t.DateTime.Value(),
+			TransactionID: t.ID.Value(),
+			Balances:      copyAmounts(cur),
+		})
+	}
+	return h
+}
+
+// RewindBalances replays the transactions backwards from the given
+// (current) balance.
+func RewindBalances(end map[generic.Currency]float64, list []Transaction) BalanceHistory {
+	start := copyAmounts(end)
+	for _, t := range list {
+		for c, v := range BalanceChange(t) {
+			start[c] -= v
+		}
+	}
+	return ReplayBalances(start, list)
+}
+
+// End returns the balance after the last transaction.
+func (h BalanceHistory) End() map[generic.Currency]float64 {
+	if len(h.Points) == 0 {
+		return copyAmounts(h.Start)
+	}
+	return copyAmounts(h.Points[len(h.Points)-1].Balances)
+}
+
+// At returns the balance at time t.
+func (h BalanceHistory) At(t time.Time) map[generic.Currency]float64 {
+	n := sort.Search(len(h.Points), func(i int) bool { return h.Points[i].Date.After(t) })
+	if n == 0 {
+		return copyAmounts(h.Start)
+	}
+	return copyAmounts(h.Points[n-1].Balances)
+}
+
+// Currencies returns every currency that had a non-zero balance at some
+// point, sorted.
+func (h BalanceHistory) Currencies() []generic.Currency {
+	seen := make(map[generic.Currency]struct{})
+	add := func(m map[generic.Currency]float64) {
+		for c, v := range m {
+			if v != 0 {
+				seen[c] = struct{}{}
+			}
+		}
+	}
+	add(h.Start)
+	for _, p := range h.Points {
+		add(p.Balances)
+	}
+	list := make([]generic.Currency, 0, len(seen))
+	for c := range seen {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// BalanceDiscrepancies returns actual minus expected for every currency
+// where they differ by more than tolerance.
+func BalanceDiscrepancies(expected, actual map[generic.Currency]float64, tolerance float64) map[generic.Currency]float64 {
+	n := make(map[generic.Currency]float64)
+	check := func(c generic.Currency) {
+		if d := actual[c] - expected[c]; math.Abs(d) > tolerance {
+			n[c] = d
+		}
+	}
+	for c := range expected {
+		check(c)
+	}
+	for c := range actual {
+		check(c)
+	}
+	return n
+}
+
+func sortTransactions(list []Transaction) []Transaction {
+	n := make([]Transaction, len(list))
+	copy(n, list)
+	sort.SliceStable(n, func(i, j int) bool {
+		a, b := n[i].DateTime.Value(), n[j].DateTime.Value()
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return n[i].ID.Value() < n[j].ID.Value()
+	})
+	return n
+}
+
+func copyAmounts(m map[generic.Currency]float64) map[generic.Currency]float64 {
+	n := make(map[generic.Currency]float64, len(m))
+	for c, v := range m {
+		n[c] = v
+	}
+	return n
+}
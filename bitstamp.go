@@ -1,6 +1,7 @@
 package bitstamp
 
 import (
+	"io"
 	"sync"
 	"time"
 
@@ -12,7 +13,15 @@ import (
 
 type Bitstamp struct {
 	API *api.API
-	WS  *ws.Client
+	WS  ws.Conn
+
+	// Store, when set, persists and deduplicates all trades received by
+	// TradesLive.
+	Store TradeStore
+	// Gap, when set, is called when trades between from and until are
+	// missing from the Store, or from TradesLiveSince without a Store,
+	// because they are older than the api allows to backfill.
+	Gap func(pair generic.CurrencyPair, from, until time.Time)
 
 	l       sync.Mutex
 	looping bool
@@ -21,13 +30,23 @@ type Bitstamp struct {
 	eventChans []chan event
 }
 
-func New(api *api.API, ws *ws.Client) *Bitstamp {
+func New(api *api.API, ws ws.Conn) *Bitstamp {
 	return &Bitstamp{API: api, WS: ws, eventChans: make([]chan event, 0)}
 }
 
 func NewDefaults(apiKey, apiSecret string) (*Bitstamp, error) {
-	api := api.New(apiKey, apiSecret, "https://www.bitstamp.net/api/v2", nil)
-	wsc, err := websocket.NewConfig("wss://ws.bitstamp.net", "https://ws.bitstamp.net")
+	return NewEndpoints(
+		apiKey,
+		apiSecret,
+		"https://www.bitstamp.net/api/v2",
+		"wss://ws.bitstamp.net",
+		"https://ws.bitstamp.net",
+	)
+}
+
+func NewEndpoints(apiKey, apiSecret, apiEndpointV2, wsEndpoint, wsOrigin string) (*Bitstamp, error) {
+	api := api.New(apiKey, apiSecret, apiEndpointV2, nil)
+	wsc, err := websocket.NewConfig(wsEndpoint, wsOrigin)
 	if err != nil {
 		return nil, err
 	}
@@ -56,6 +75,13 @@ func (b *Bitstamp) eventLoop() {
 				c <- event{Message: msg, err: err}
 			}
 			b.lEvent.RUnlock()
+
+			if err == io.EOF { // closed or end of recording, restarted by the next subscriber
+				b.l.Lock()
+				b.looping = false
+				b.l.Unlock()
+				return
+			}
 		}
 	}()
 	return
@@ -69,6 +95,17 @@ func (b *Bitstamp) subscribe() chan event {
 	return ch
 }
 
+// release unsubscribes ch, events sent to it in the meantime are
+// discarded so the event loop can not block on it.
+func (b *Bitstamp) release(ch chan event) {
+	go func() {
+		for range ch {
+		}
+	}()
+	b.unsubscribe(ch)
+	close(ch)
+}
+
 func (b *Bitstamp) unsubscribe(ch chan event) {
 	b.lEvent.Lock()
 	ix := -1
@@ -89,39 +126,24 @@ type Transaction struct {
 	Values map[generic.Currency]float64
 }
 
-func (b *Bitstamp) Transactions() ([]Transaction, error) {
-	t := b.API.NewTransactions()
-	for {
-		n, err := t.Next()
-		if err != nil {
-			return nil, err
-		}
-		if n == 0 {
-			break
-		}
-	}
+func newTransaction(t api.Transaction) Transaction {
+	return Transaction{Transaction: t, Values: t.Amounts}
+}
 
-	list := make([]Transaction, len(t.List))
-	for i, t := range t.List {
-		list[i] = Transaction{Transaction: t}
-		list[i].Values = map[generic.Currency]float64{
-			USD:  t.USD.Value(),
-			EUR:  t.EUR.Value(),
-			BTC:  t.BTC.Value(),
-			XRP:  t.XRP.Value(),
-			GBP:  t.GBP.Value(),
-			LTC:  t.LTC.Value(),
-			ETH:  t.ETH.Value(),
-			BCH:  t.BCH.Value(),
-			XLM:  t.XLM.Value(),
-			PAX:  t.PAX.Value(),
-			LINK: t.LINK.Value(),
-			OMG:  t.OMG.Value(),
-			USDC: t.USDC.Value(),
-		}
-	}
+func (b *Bitstamp) Transactions(q api.TransactionsQuery) ([]Transaction, error) {
+	list := make([]Transaction, 0, 100)
+	err := b.WalkTransactions(q, func(t Transaction) bool {
+		list = append(list, t)
+		return true
+	})
 
-	return list, nil
+	return list, err
+}
+
+func (b *Bitstamp) WalkTransactions(q api.TransactionsQuery, fn func(Transaction) bool) error {
+	return b.API.NewTransactionsQuery(q).Walk(func(t api.Transaction) bool {
+		return fn(newTransaction(t))
+	})
 }
 
 type Trade struct {
@@ -133,32 +155,43 @@ type Trade struct {
 	Live   bool
 }
 
+// TradeStore persists trades, see the store package.
+type TradeStore interface {
+	// Add stores the given trades and returns those that were not stored
+	// yet.
+	Add(pair generic.CurrencyPair, trades ...Trade) ([]Trade, error)
+	Range(pair generic.CurrencyPair, from, until time.Time) ([]Trade, error)
+	Last(pair generic.CurrencyPair) (Trade, bool, error)
+}
+
 func (b *Bitstamp) TradesLive(
 	history api.TradeHistory,
 	pair generic.CurrencyPair,
 	trades chan<- Trade,
 ) error {
+	var since time.Time
 	if history != api.TradesHistoryNone {
-		r, err := b.API.Trades(history, pair)
-		if err != nil {
-			return err
-		}
+		since = time.Now().Add(-history.Duration())
+	}
+	return b.TradesLiveSince(since, pair, trades)
+}
 
-		for i := len(r.List) - 1; i >= 0; i-- {
-			d := r.List[i]
-			trades <- Trade{
-				Date:   d.Date.Value(),
-				ID:     d.ID.Value(),
-				Price:  d.Price.Value(),
-				Amount: d.Amount.Value(),
-				Type:   d.Type,
-				Live:   false,
-			}
-		}
+// TradesLiveSince sends all trades since the given time followed by live
+// trades. Without a Store trades older than a day are not available.
+// With a Store all live trades are persisted and gaps are backfilled as
+// far as the api allows. Trades that could not be sent or backfilled are
+// reported to Gap.
+func (b *Bitstamp) TradesLiveSince(
+	since time.Time,
+	pair generic.CurrencyPair,
+	trades chan<- Trade,
+) error {
+	if err := b.seedTrades(since, pair, trades); err != nil {
+		return err
 	}
 
 	ch := b.subscribe()
-	defer b.unsubscribe(ch)
+	defer b.release(ch)
 	b.eventLoop()
 
 	channel := ws.LiveTrades.ForCurrencyPair(pair)
@@ -172,21 +205,196 @@ func (b *Bitstamp) TradesLive(
 		}
 
 		if e.Channel == channel && e.Event == ws.TradeEvent {
-			d, err := e.DataLiveTrade()
+			t, err := liveTrade(e)
 			if err != nil {
 				return err
 			}
 
-			trades <- Trade{
-				Date:   d.MicroTimestamp.Value(),
-				ID:     d.ID.Value(),
-				Price:  d.Price.Value(),
-				Amount: d.Amount.Value(),
-				Type:   api.TradeType(d.Type.Value()),
-				Live:   true,
+			if b.Store != nil {
+				n, err := b.Store.Add(pair, t)
+				if err != nil {
+					return err
+				}
+				if len(n) == 0 {
+					continue
+				}
 			}
+
+			trades <- t
 		}
 	}
 
 	return nil
 }
+
+func liveTrade(e event) (Trade, error) {
+	d, err := e.DataLiveTrade()
+	if err != nil {
+		return Trade{}, err
+	}
+	return Trade{
+		Date:   d.MicroTimestamp.Value(),
+		ID:     d.ID.Value(),
+		Price:  d.Price.Value(),
+		Amount: d.Amount.Value(),
+		Type:   api.TradeType(d.Type.Value()),
+		Live:   true,
+	}, nil
+}
+
+// MarketLive sends live trades and order book updates of pair until stop
+// is closed or an error occurs, a nil channel disables that feed. Unlike
+// TradesLive and OrderBookLive it returns nil as soon as stop is closed,
+// even while a send is pending, so the caller can stop reading.
+// Trades are not persisted in the Store.
+func (b *Bitstamp) MarketLive(
+	pair generic.CurrencyPair,
+	trades chan<- Trade,
+	books chan<- OrderBook,
+	stop <-chan struct{},
+) error {
+	ch := b.subscribe()
+	defer b.release(ch)
+	b.eventLoop()
+
+	tradeChannel := ws.LiveTrades.ForCurrencyPair(pair)
+	bookChannel := ws.OrderBook.ForCurrencyPair(pair)
+	if trades != nil {
+		if err := b.WS.Subscribe(tradeChannel); err != nil {
+			return err
+		}
+	}
+	if books != nil {
+		if err := b.WS.Subscribe(bookChannel); err != nil {
+			return err
+		}
+	}
+
+	for {
+		var e event
+		select {
+		case <-stop:
+			return nil
+		case e = <-ch:
+		}
+		if e.err != nil {
+			return e.err
+		}
+
+		switch {
+		case trades != nil && e.Channel == tradeChannel && e.Event == ws.TradeEvent:
+			t, err := liveTrade(e)
+			if err != nil {
+				return err
+			}
+			select {
+			case trades <- t:
+			case <-stop:
+				return nil
+			}
+		case books != nil && e.Channel == bookChannel && e.Event == ws.DataEvent:
+			book, err := liveBook(e)
+			if err != nil {
+				return err
+			}
+			select {
+			case books <- book:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+func (b *Bitstamp) restTrades(history api.TradeHistory, pair generic.CurrencyPair) ([]Trade, error) {
+	r, err := b.API.Trades(history, pair)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Trade, 0, len(r.List))
+	for i := len(r.List) - 1; i >= 0; i-- {
+		d := r.List[i]
+		list = append(list, Trade{
+			Date:   d.Date.Value(),
+			ID:     d.ID.Value(),
+			Price:  d.Price.Value(),
+			Amount: d.Amount.Value(),
+			Type:   d.Type,
+			Live:   false,
+		})
+	}
+
+	return list, nil
+}
+
+// gap reports the trades between from and the oldest trade the api returned
+// as missing if from lies before the api's backfill window. A minute of
+// slack keeps a since of exactly one day ago, taken just before the
+// request, from being reported.
+func (b *Bitstamp) gap(pair generic.CurrencyPair, from, now time.Time, list []Trade) {
+	window := now.Add(-api.TradesHistoryDay.Duration())
+	if b.Gap == nil || from.IsZero() || !from.Before(window.Add(-time.Minute)) {
+		return
+	}
+	if len(list) != 0 && list[0].Date.After(window) {
+		window = list[0].Date
+	}
+	b.Gap(pair, from, window)
+}
+
+func (b *Bitstamp) seedTrades(since time.Time, pair generic.CurrencyPair, trades chan<- Trade) error {
+	now := time.Now()
+	if b.Store == nil {
+		if since.IsZero() {
+			return nil
+		}
+		list, err := b.restTrades(api.TradeHistoryFor(now.Sub(since)), pair)
+		if err != nil {
+			return err
+		}
+		b.gap(pair, since, now, list)
+		for _, t := range list {
+			if !t.Date.Before(since) {
+				trades <- t
+			}
+		}
+		return nil
+	}
+
+	history := api.TradesHistoryDay
+	last, ok, err := b.Store.Last(pair)
+	if err != nil {
+		return err
+	}
+	if ok {
+		history = api.TradeHistoryFor(now.Sub(last.Date))
+	}
+
+	list, err := b.restTrades(history, pair)
+	if err != nil {
+		return err
+	}
+	from := since
+	if ok {
+		from = last.Date
+	}
+	b.gap(pair, from, now, list)
+	if _, err := b.Store.Add(pair, list...); err != nil {
+		return err
+	}
+
+	if since.IsZero() {
+		return nil
+	}
+
+	list, err = b.Store.Range(pair, since, now)
+	if err != nil {
+		return err
+	}
+	for _, t := range list {
+		trades <- t
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package bitstamp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/bitstamptest"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/store"
+	"github.com/frizinak/bitstamp/ws"
+)
+
+func TestGap(t *testing.T) {
+	pair := bitstamp.BTCUSD()
+	now := time.Now()
+	old := now.Add(-72 * time.Hour)
+	tests := []struct {
+		name  string
+		store bool
+		last  time.Time
+		since time.Time
+		gap   time.Time
+	}{
+		{"no store, within a day", false, time.Time{}, now.Add(-24 * time.Hour), time.Time{}},
+		{"no store, older than a day", false, time.Time{}, old, old},
+		{"empty store, no history", true, time.Time{}, time.Time{}, time.Time{}},
+		{"empty store, within a day", true, time.Time{}, now.Add(-24 * time.Hour), time.Time{}},
+		{"empty store, older than a day", true, time.Time{}, old, old},
+		{"recent store", true, now.Add(-time.Hour), old, time.Time{}},
+		{"stale store", true, now.Add(-48 * time.Hour), time.Time{}, now.Add(-48 * time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := bitstamptest.New()
+			defer s.Close()
+			s.Trade(pair, 100, 1, api.Buy)
+			client, err := s.Client("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.store {
+				st, err := store.Open(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer st.Close()
+				if !test.last.IsZero() {
+					if _, err := st.Add(pair, bitstamp.Trade{ID: 1, Date: test.last, Price: 1}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				client.Store = st
+			}
+
+			type gap struct{ from, until time.Time }
+			gaps := make(chan gap, 1)
+			client.Gap = func(p generic.CurrencyPair, from, until time.Time) {
+				if p != pair {
+					t.Errorf("gap for %s", p)
+				}
+				gaps <- gap{from, until}
+			}
+
+			trades := make(chan bitstamp.Trade, 10)
+			go client.TradesLiveSince(test.since, pair, trades)
+			channel := ws.LiveTrades.ForCurrencyPair(pair)
+			for i := 0; s.Subscribers(channel) == 0; i++ {
+				if i == 200 {
+					t.Fatal("no subscription")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			select {
+			case g := <-gaps:
+				if test.gap.IsZero() {
+					t.Fatalf("unexpected gap %s - %s", g.from, g.until)
+				}
+				if !g.from.Equal(test.gap) {
+					t.Errorf("gap from %s, expected %s", g.from, test.gap)
+				}
+				if g.until.Before(now.Add(-24*time.Hour)) || g.until.After(time.Now()) {
+					t.Errorf("gap until %s, expected within the last day", g.until)
+				}
+			default:
+				if !test.gap.IsZero() {
+					t.Fatal("gap not reported")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package bitstamptest
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+type Account struct {
+	s *Server
+
+	ID     uint64
+	Key    string
+	Secret string
+
+	main *Account
+	subs []*Account
+
+	fee       float64
+	available map[generic.Currency]float64
+	reserved  map[generic.Currency]float64
+	txs       []transaction
+}
+
+type transaction struct {
+	id      uint64
+	date    time.Time
+	typ     api.TransactionType
+	orderID uint64
+	fee     float64
+	amounts map[generic.Currency]float64
+	pair    generic.CurrencyPair
+	rate    float64
+}
+
+func (t transaction) encode() map[string]interface{} {
+	m := map[string]interface{}{
+		"id":       t.id,
+		"datetime": formatDate(t.date),
+		"type":     strconv.FormatUint(uint64(t.typ), 10),
+		"fee":      formatFloat(t.fee),
+	}
+	if t.orderID != 0 {
+		m["order_id"] = t.orderID
+	}
+	for c, v := range t.amounts {
+		m[c.String()] = formatFloat(v)
+	}
+	if t.rate != 0 {
+		m[t.pair.Base.String()+"_"+t.pair.Counter.String()] = formatFloat(t.rate)
+	}
+	return m
+}
+
+// AddAccount registers a main account with the given credentials.
+func (s *Server) AddAccount(key, secret string) *Account {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.addAccount(key, secret)
+}
+
+func (s *Server) addAccount(key, secret string) *Account {
+	a := &Account{
+		s:         s,
+		ID:        s.nextID(),
+		Key:       key,
+		Secret:    secret,
+		fee:       DefaultFee,
+		available: make(map[generic.Currency]float64),
+		reserved:  make(map[generic.Currency]float64),
+	}
+	s.accounts[key] = a
+	return a
+}
+
+// AddSubAccount registers a sub account of a.
+func (a *Account) AddSubAccount(key, secret string) *Account {
+	a.s.l.Lock()
+	defer a.s.l.Unlock()
+	sub := a.s.addAccount(key, secret)
+	sub.main = a
+	a.subs = append(a.subs, sub)
+	return sub
+}
+
+// SetFee sets the trading fee percentage.
+func (a *Account) SetFee(fee float64) {
+	a.s.l.Lock()
+	a.fee = fee
+	a.s.l.Unlock()
+}
+
+// Deposit credits the account and records a deposit transaction.
+func (a *Account) Deposit(c generic.Currency, amount float64) {
+	a.s.l.Lock()
+	a.available[c] += amount
+	a.record(transaction{
+		typ:     api.Deposit,
+		amounts: map[generic.Currency]float64{c: amount},
+	})
+	a.s.l.Unlock()
+}
+
+// SetBalance overwrites the available balance without recording a
+// transaction.
+func (a *Account) SetBalance(c generic.Currency, amount float64) {
+	a.s.l.Lock()
+	a.available[c] = amount
+	a.s.l.Unlock()
+}
+
+// Balance returns the available and reserved amount of the given currency.
+func (a *Account) Balance(c generic.Currency) (available, reserved float64) {
+	a.s.l.Lock()
+	defer a.s.l.Unlock()
+	return a.available[c], a.reserved[c]
+}
+
+func (a *Account) record(t transaction) {
+	t.id = a.s.nextID()
+	if t.date.IsZero() {
+		t.date = time.Now()
+	}
+	a.txs = append(a.txs, t)
+}
+
+func (a *Account) sub(id uint64) (*Account, bool) {
+	for _, s := range a.subs {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func (a *Account) reserve(c generic.Currency, amount float64) error {
+	if err := a.funds(c, amount); err != nil {
+		return err
+	}
+	a.available[c] -= amount
+	a.reserved[c] += amount
+	return nil
+}
+
+func (a *Account) funds(c generic.Currency, amount float64) error {
+	if a.available[c] < amount-epsilon {
+		return fmt.Errorf(
+			"You need %s %s to open that order. You have only %s %s available. Check your account balance for details.",
+			formatFloat(amount),
+			c,
+			formatFloat(a.available[c]),
+			c,
+		)
+	}
+	return nil
+}
+
+func (a *Account) release(c generic.Currency, amount float64) {
+	a.reserved[c] -= amount
+	a.available[c] += amount
+}
+
+func (a *Account) transfer(to *Account, c generic.Currency, amount float64) error {
+	if a.available[c] < amount {
+		return fmt.Errorf("insufficient %s balance", c)
+	}
+	a.available[c] -= amount
+	to.available[c] += amount
+	a.record(transaction{
+		typ:     api.SubAccountTransfer,
+		amounts: map[generic.Currency]float64{c: -amount},
+	})
+	to.record(transaction{
+		typ:     api.SubAccountTransfer,
+		amounts: map[generic.Currency]float64{c: amount},
+	})
+	return nil
+}
+
+func (a *Account) balance() map[string]interface{} {
+	m := make(map[string]interface{})
+	currencies := make(map[generic.Currency]struct{})
+	for _, p := range a.s.pairs {
+		currencies[p.Base] = struct{}{}
+		currencies[p.Counter] = struct{}{}
+		m[p.String()+"_fee"] = formatFloat(a.fee)
+	}
+	for c := range a.available {
+		currencies[c] = struct{}{}
+	}
+	for c := range currencies {
+		m[c.String()+"_available"] = formatFloat(a.available[c])
+		m[c.String()+"_reserved"] = formatFloat(a.reserved[c])
+		m[c.String()+"_balance"] = formatFloat(a.available[c] + a.reserved[c])
+		m[c.String()+"_withdrawal_fee"] = "0"
+	}
+	return m
+}
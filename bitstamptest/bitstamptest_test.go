@@ -0,0 +1,207 @@
+package bitstamptest_test
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/bitstamptest"
+	"github.com/frizinak/bitstamp/ws"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := bitstamptest.New()
+	defer s.Close()
+	s.AddAccount("key", "secret").Deposit(bitstamp.USD, 100)
+
+	b, err := s.API("key", "secret").Balances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := b.Currency(bitstamp.USD).Available; v != 100 {
+		t.Errorf("available usd %g, expected 100", v)
+	}
+
+	if _, err := s.API("key", "wrong").Balances(); err == nil {
+		t.Error("request with wrong secret succeeded")
+	}
+	if _, err := s.API("unknown", "secret").Balances(); err == nil {
+		t.Error("request with unknown key succeeded")
+	}
+
+	r, err := http.NewRequest("POST", "https://www.bitstamp.net/api/v2/balance/", strings.NewReader("a=b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := api.Sign("key", "secret", r); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Verify("key", "secret", r); err != nil {
+		t.Errorf("verify signed request: %s", err)
+	}
+	if err := api.Verify("key", "other", r); !errors.Is(err, api.ErrInvalidSignature) {
+		t.Errorf("verify with other secret: %v, expected %s", err, api.ErrInvalidSignature)
+	}
+	r.Body = http.NoBody
+	if err := api.Verify("key", "secret", r); !errors.Is(err, api.ErrInvalidSignature) {
+		t.Errorf("verify with tampered body: %v, expected %s", err, api.ErrInvalidSignature)
+	}
+	r.Header.Del("X-Auth-Nonce")
+	if err := api.Verify("key", "secret", r); !errors.Is(err, api.ErrInvalidAuth) {
+		t.Errorf("verify without nonce: %v, expected %s", err, api.ErrInvalidAuth)
+	}
+}
+
+func TestPlaceCancel(t *testing.T) {
+	s := bitstamptest.New()
+	defer s.Close()
+	pair := bitstamp.BTCUSD()
+	s.AddAccount("key", "secret").Deposit(bitstamp.USD, 1000)
+	a := s.API("key", "secret")
+
+	res, err := a.Place(api.NewLimitBuy(pair, 1, 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := a.BalancesPair(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := b.Currency(bitstamp.USD).Reserved; math.Abs(v-502.5) > 1e-9 {
+		t.Errorf("reserved usd %g, expected 502.5", v)
+	}
+
+	open, err := a.OpenOrders(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 1 || open[0].ID.Value() != res.ID.Value() {
+		t.Fatalf("open orders %+v, expected order %d", open, res.ID.Value())
+	}
+
+	if _, err := a.Place(api.NewLimitBuy(pair, 1, 1000)); err == nil {
+		t.Error("order exceeding the balance succeeded")
+	}
+
+	c, err := a.Cancel(res.ID.Value())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID.Value() != res.ID.Value() {
+		t.Errorf("canceled order %d, expected %d", c.ID.Value(), res.ID.Value())
+	}
+	if _, err := a.Cancel(res.ID.Value()); err == nil {
+		t.Error("canceling a canceled order succeeded")
+	}
+	if open, _ = a.OpenOrders(pair); len(open) != 0 {
+		t.Errorf("open orders after cancel %+v", open)
+	}
+	b, _ = a.BalancesPair(pair)
+	if v := b.Currency(bitstamp.USD).Available; v != 1000 {
+		t.Errorf("available usd after cancel %g, expected 1000", v)
+	}
+
+	s.FailNext("buy", http.StatusInternalServerError, "down")
+	if _, err := a.Place(api.NewLimitBuy(pair, 1, 500)); err == nil {
+		t.Error("FailNext did not fail the order")
+	}
+}
+
+func TestTransactionsPaging(t *testing.T) {
+	s := bitstamptest.New()
+	defer s.Close()
+	acc := s.AddAccount("key", "secret")
+	for i := 1; i <= 25; i++ {
+		acc.Deposit(bitstamp.USD, float64(i))
+	}
+	a := s.API("key", "secret")
+
+	for _, desc := range []bool{false, true} {
+		tr := a.NewTransactionsQuery(api.TransactionsQuery{PageSize: 7, Desc: desc})
+		var pages int
+		for {
+			n, err := tr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				break
+			}
+			pages++
+		}
+		if len(tr.List) != 25 || pages != 4 {
+			t.Fatalf("desc %t: %d transactions in %d pages, expected 25 in 4", desc, len(tr.List), pages)
+		}
+		seen := make(map[uint64]struct{})
+		for i, v := range tr.List {
+			if _, ok := seen[v.ID.Value()]; ok {
+				t.Errorf("desc %t: duplicate transaction %d", desc, v.ID.Value())
+			}
+			seen[v.ID.Value()] = struct{}{}
+			exp := float64(i + 1)
+			if desc {
+				exp = float64(25 - i)
+			}
+			if amount := v.Amount(bitstamp.USD); amount != exp {
+				t.Errorf("desc %t: transaction %d amount %g, expected %g", desc, i, amount, exp)
+			}
+		}
+	}
+
+	var n int
+	err := a.NewTransactionsQuery(api.TransactionsQuery{PageSize: 4, Limit: 10}).Walk(func(api.Transaction) bool {
+		n++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("walked %d transactions, expected the limit of 10", n)
+	}
+}
+
+func TestTradeStream(t *testing.T) {
+	s := bitstamptest.New()
+	defer s.Close()
+	pair := bitstamp.BTCUSD()
+	client, err := s.Client("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trades := make(chan bitstamp.Trade, 8)
+	errs := make(chan error, 1)
+	go func() { errs <- client.TradesLive(api.TradesHistoryNone, pair, trades) }()
+
+	channel := ws.LiveTrades.ForCurrencyPair(pair)
+	for i := 0; s.Subscribers(channel) == 0; i++ {
+		if i == 200 {
+			t.Fatal("no subscription")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Play(pair, []bitstamptest.ScriptedTrade{
+		{Price: 100, Amount: 1, Type: api.Buy},
+		{Price: 101, Amount: 2, Type: api.Sell},
+	})
+	for _, exp := range []bitstamp.Trade{{Price: 100, Amount: 1}, {Price: 101, Amount: 2}} {
+		select {
+		case tr := <-trades:
+			if tr.Price != exp.Price || tr.Amount != exp.Amount || !tr.Live {
+				t.Errorf("trade %+v, expected live trade of %g @ %g", tr, exp.Amount, exp.Price)
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for trade")
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package bitstamptest
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/ws"
+)
+
+const epsilon = 1e-12
+
+var ErrNoLiquidity = errors.New("Order could not be placed (perhaps due to internal error or trade halt). Please retry placing order.")
+
+type Level struct {
+	Price  float64
+	Amount float64
+}
+
+type order struct {
+	id      uint64
+	account *Account
+	pair    generic.CurrencyPair
+	side    api.TradeType
+	price   float64
+	amount  float64
+	date    time.Time
+}
+
+type trade struct {
+	id     uint64
+	date   time.Time
+	price  float64
+	amount float64
+	typ    api.TradeType
+	buyID  uint64
+	sellID uint64
+}
+
+type fill struct {
+	maker  *order
+	price  float64
+	amount float64
+}
+
+type book struct {
+	pair generic.CurrencyPair
+	bids []*order
+	asks []*order
+}
+
+func (b *book) side(side api.TradeType) *[]*order {
+	if side == api.Buy {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+func (b *book) opposite(side api.TradeType) *[]*order {
+	if side == api.Buy {
+		return &b.asks
+	}
+	return &b.bids
+}
+
+func (b *book) insert(o *order) {
+	list := b.side(o.side)
+	*list = append(*list, o)
+	sort.SliceStable(*list, func(i, j int) bool {
+		if o.side == api.Buy {
+			return (*list)[i].price > (*list)[j].price
+		}
+		return (*list)[i].price < (*list)[j].price
+	})
+}
+
+func (b *book) remove(o *order) bool {
+	list := b.side(o.side)
+	for i := range *list {
+		if (*list)[i] == o {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (b *book) find(id uint64) (*order, bool) {
+	for _, list := range [][]*order{b.bids, b.asks} {
+		for _, o := range list {
+			if o.id == id {
+				return o, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (b *book) levels(list []*order) []Level {
+	n := make([]Level, 0, len(list))
+	for _, o := range list {
+		if len(n) != 0 && n[len(n)-1].Price == o.price {
+			n[len(n)-1].Amount += o.amount
+			continue
+		}
+		n = append(n, Level{o.price, o.amount})
+	}
+	return n
+}
+
+// match determines the fills for a taker order without modifying the book.
+// A limit of 0 means no limit, amount is in counter if inCounter is set.
+func (b *book) match(side api.TradeType, limit, amount float64, inCounter, accountsOnly bool) ([]fill, float64) {
+	fills := make([]fill, 0)
+	var filled float64
+	remaining := amount
+	for _, o := range *b.opposite(side) {
+		if remaining <= epsilon {
+			break
+		}
+		if limit != 0 && ((side == api.Buy && o.price > limit) || (side == api.Sell && o.price < limit)) {
+			break
+		}
+		if accountsOnly && o.account == nil {
+			continue
+		}
+
+		q := math.Min(o.amount, remaining)
+		if inCounter {
+			q = math.Min(o.amount, remaining/o.price)
+			remaining -= q * o.price
+		} else {
+			remaining -= q
+		}
+		filled += q
+		fills = append(fills, fill{maker: o, price: o.price, amount: q})
+	}
+
+	return fills, filled
+}
+
+// SetBook replaces the liquidity that is not owned by any account.
+func (s *Server) SetBook(pair generic.CurrencyPair, bids, asks []Level) {
+	s.l.Lock()
+	b := s.book(pair)
+	for _, list := range []*[]*order{&b.bids, &b.asks} {
+		n := (*list)[:0]
+		for _, o := range *list {
+			if o.account != nil {
+				n = append(n, o)
+			}
+		}
+		*list = n
+	}
+
+	add := func(side api.TradeType, levels []Level) {
+		for _, l := range levels {
+			b.insert(&order{
+				id:     s.nextID(),
+				pair:   pair,
+				side:   side,
+				price:  l.Price,
+				amount: l.Amount,
+				date:   time.Now(),
+			})
+		}
+	}
+	add(api.Buy, bids)
+	add(api.Sell, asks)
+	events := []event{s.bookEvent(b)}
+	s.l.Unlock()
+
+	s.publish(events)
+}
+
+// Book returns the aggregated price levels of the given pair.
+func (s *Server) Book(pair generic.CurrencyPair) (bids, asks []Level) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	b := s.book(pair)
+	return b.levels(b.bids), b.levels(b.asks)
+}
+
+// Trade simulates a trade by another market participant. Resting account
+// orders that cross the price are filled first, any remainder is
+// published as is.
+func (s *Server) Trade(pair generic.CurrencyPair, price, amount float64, typ api.TradeType) {
+	s.l.Lock()
+	b := s.book(pair)
+	fills, filled := b.match(typ, price, amount, false, true)
+	events := s.execute(b, nil, 0, typ, 0, fills)
+	if amount-filled > epsilon {
+		t := trade{
+			id:     s.nextID(),
+			date:   time.Now(),
+			price:  price,
+			amount: amount - filled,
+			typ:    typ,
+		}
+		s.trades[pair] = append(s.trades[pair], t)
+		events = append(events, tradeEvent(pair, t))
+	}
+	s.l.Unlock()
+
+	s.publish(events)
+}
+
+// ScriptedTrade is a trade played by Play after Delay.
+type ScriptedTrade struct {
+	Delay  time.Duration
+	Price  float64
+	Amount float64
+	Type   api.TradeType
+}
+
+// Play publishes the given trades in order, blocks until done.
+func (s *Server) Play(pair generic.CurrencyPair, script []ScriptedTrade) {
+	for _, t := range script {
+		if t.Delay > 0 {
+			time.Sleep(t.Delay)
+		}
+		s.Trade(pair, t.Price, t.Amount, t.Type)
+	}
+}
+
+func (s *Server) book(pair generic.CurrencyPair) *book {
+	b, ok := s.books[pair]
+	if !ok {
+		b = &book{pair: pair}
+		s.books[pair] = b
+		s.pairs[pair.String()] = pair
+	}
+	return b
+}
+
+func (s *Server) findOrder(id uint64) (*book, *order, bool) {
+	for _, b := range s.books {
+		if o, ok := b.find(id); ok {
+			return b, o, true
+		}
+	}
+	return nil, nil, false
+}
+
+// settle books a single fill for an account. reservePrice is the price the
+// funds were reserved at, 0 if they are taken from the available balance.
+func (a *Account) settle(pair generic.CurrencyPair, side api.TradeType, amount, price, reservePrice float64, orderID uint64) {
+	cost := amount * price
+	fee := cost * a.fee / 100
+	switch side {
+	case api.Buy:
+		if reservePrice != 0 {
+			reserved := amount * reservePrice * (1 + a.fee/100)
+			a.reserved[pair.Counter] -= reserved
+			a.available[pair.Counter] += reserved
+		}
+		a.available[pair.Counter] -= cost + fee
+		a.available[pair.Base] += amount
+	default:
+		if reservePrice != 0 {
+			a.reserved[pair.Base] -= amount
+		} else {
+			a.available[pair.Base] -= amount
+		}
+		a.available[pair.Counter] += cost - fee
+	}
+
+	sign := 1.0
+	if side != api.Buy {
+		sign = -1
+	}
+	a.record(transaction{
+		typ:     api.MarketTrade,
+		orderID: orderID,
+		fee:     fee,
+		amounts: map[generic.Currency]float64{
+			pair.Base:    sign * amount,
+			pair.Counter: -sign * cost,
+		},
+		pair: pair,
+		rate: price,
+	})
+}
+
+// execute applies fills of a taker order. taker is nil for external
+// trades.
+func (s *Server) execute(b *book, taker *Account, takerID uint64, side api.TradeType, reservePrice float64, fills []fill) []event {
+	events := make([]event, 0, len(fills)+1)
+	for _, f := range fills {
+		f.maker.amount -= f.amount
+		if f.maker.amount <= epsilon {
+			b.remove(f.maker)
+		}
+
+		if taker != nil {
+			taker.settle(b.pair, side, f.amount, f.price, reservePrice, takerID)
+		}
+		if f.maker.account != nil {
+			f.maker.account.settle(b.pair, f.maker.side, f.amount, f.price, f.maker.price, f.maker.id)
+		}
+
+		t := trade{
+			id:     s.nextID(),
+			date:   time.Now(),
+			price:  f.price,
+			amount: f.amount,
+			typ:    side,
+			buyID:  takerID,
+			sellID: f.maker.id,
+		}
+		if side != api.Buy {
+			t.buyID, t.sellID = t.sellID, t.buyID
+		}
+		s.trades[b.pair] = append(s.trades[b.pair], t)
+		events = append(events, tradeEvent(b.pair, t))
+	}
+
+	if len(fills) != 0 {
+		events = append(events, s.bookEvent(b))
+	}
+
+	return events
+}
+
+func (s *Server) bookEvent(b *book) event {
+	encode := func(levels []Level) [][2]string {
+		if len(levels) > 100 {
+			levels = levels[:100]
+		}
+		n := make([][2]string, len(levels))
+		for i, l := range levels {
+			n[i] = [2]string{formatFloat(l.Price), formatFloat(l.Amount)}
+		}
+		return n
+	}
+
+	now := time.Now()
+	return event{
+		channel: ws.OrderBook.ForCurrencyPair(b.pair),
+		event:   ws.DataEvent,
+		data: map[string]interface{}{
+			"timestamp":      formatUnix(now),
+			"microtimestamp": formatUnixMicro(now),
+			"bids":           encode(b.levels(b.bids)),
+			"asks":           encode(b.levels(b.asks)),
+		},
+	}
+}
+
+func tradeEvent(pair generic.CurrencyPair, t trade) event {
+	return event{
+		channel: ws.LiveTrades.ForCurrencyPair(pair),
+		event:   ws.TradeEvent,
+		data: map[string]interface{}{
+			"id":             t.id,
+			"buy_order_id":   t.buyID,
+			"sell_order_id":  t.sellID,
+			"type":           int(t.typ),
+			"amount":         t.amount,
+			"amount_str":     formatFloat(t.amount),
+			"price":          t.price,
+			"price_str":      formatFloat(t.price),
+			"timestamp":      formatUnix(t.date),
+			"microtimestamp": formatUnixMicro(t.date),
+		},
+	}
+}
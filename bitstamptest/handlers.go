@@ -0,0 +1,598 @@
+package bitstamptest
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPath), "/")
+	if f, ok := s.failure(endpoint); ok {
+		writeError(w, f.status, f.reason)
+		return
+	}
+
+	parts := strings.Split(endpoint, "/")
+	last := parts[len(parts)-1]
+	pair, hasPair := s.pair(last)
+
+	public := map[string]func(http.ResponseWriter, *http.Request, generic.CurrencyPair){
+		"ticker":       s.handleTicker(time.Hour * 24),
+		"ticker_hour":  s.handleTicker(time.Hour),
+		"transactions": s.handleTrades,
+		"order_book":   s.handleOrderBook,
+		"ohlc":         s.handleOHLC,
+	}
+	if h, ok := public[parts[0]]; ok {
+		if len(parts) != 2 || !hasPair {
+			writeError(w, http.StatusNotFound, "Invalid currency pair")
+			return
+		}
+		h(w, r, pair)
+		return
+	}
+	if endpoint == "trading-pairs-info" {
+		s.handlePairs(w, r)
+		return
+	}
+
+	acc, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(parts) > 1 && !hasPair && last != "all" {
+		writeError(w, http.StatusNotFound, "Invalid currency pair")
+		return
+	}
+
+	switch {
+	case parts[0] == "balance":
+		s.handleBalance(w, r, acc, pair, hasPair)
+	case parts[0] == "user_transactions":
+		s.handleUserTransactions(w, r, acc, pair, hasPair)
+	case parts[0] == "open_orders":
+		s.handleOpenOrders(w, r, acc, pair, hasPair)
+	case endpoint == "cancel_order":
+		s.handleCancel(w, r, acc)
+	case endpoint == "cancel_all_orders":
+		s.handleCancelAll(w, r, acc)
+	case endpoint == "transfer-to-main", endpoint == "transfer-from-main":
+		s.handleTransfer(w, r, acc, endpoint == "transfer-to-main")
+	case (parts[0] == "buy" || parts[0] == "sell") && hasPair:
+		side := api.Buy
+		if parts[0] == "sell" {
+			side = api.Sell
+		}
+		typ := "limit"
+		if len(parts) == 3 {
+			typ = parts[1]
+		}
+		s.handleOrder(w, r, acc, pair, side, typ)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) handleTicker(window time.Duration) func(http.ResponseWriter, *http.Request, generic.CurrencyPair) {
+	return func(w http.ResponseWriter, r *http.Request, pair generic.CurrencyPair) {
+		now := time.Now()
+		s.l.Lock()
+		trades := s.trades[pair]
+		b := s.book(pair)
+		var bid, ask float64
+		if len(b.bids) != 0 {
+			bid = b.bids[0].price
+		}
+		if len(b.asks) != 0 {
+			ask = b.asks[0].price
+		}
+
+		var last, open, high, low, volume, quote float64
+		low = math.MaxFloat64
+		for _, t := range trades {
+			last = t.price
+			if now.Sub(t.date) > window {
+				continue
+			}
+			if open == 0 {
+				open = t.price
+			}
+			high = math.Max(high, t.price)
+			low = math.Min(low, t.price)
+			volume += t.amount
+			quote += t.amount * t.price
+		}
+		s.l.Unlock()
+
+		if low == math.MaxFloat64 {
+			low = 0
+		}
+		var vwap float64
+		if volume != 0 {
+			vwap = quote / volume
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{
+			"last":      formatFloat(last),
+			"high":      formatFloat(high),
+			"low":       formatFloat(low),
+			"vwap":      formatFloat(vwap),
+			"volume":    formatFloat(volume),
+			"bid":       formatFloat(bid),
+			"ask":       formatFloat(ask),
+			"open":      formatFloat(open),
+			"open_24":   formatFloat(open),
+			"timestamp": formatUnix(now),
+		})
+	}
+}
+
+func (s *Server) handleTrades(w http.ResponseWriter, r *http.Request, pair generic.CurrencyPair) {
+	window := time.Hour
+	switch r.URL.Query().Get("time") {
+	case "minute":
+		window = time.Minute
+	case "day":
+		window = time.Hour * 24
+	}
+
+	now := time.Now()
+	s.l.Lock()
+	trades := s.trades[pair]
+	list := make([]map[string]string, 0)
+	for i := len(trades) - 1; i >= 0; i-- {
+		t := trades[i]
+		if now.Sub(t.date) > window {
+			break
+		}
+		list = append(list, map[string]string{
+			"date":   formatUnix(t.date),
+			"tid":    strconv.FormatUint(t.id, 10),
+			"price":  formatFloat(t.price),
+			"amount": formatFloat(t.amount),
+			"type":   strconv.Itoa(int(t.typ)),
+		})
+	}
+	s.l.Unlock()
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleOrderBook(w http.ResponseWriter, r *http.Request, pair generic.CurrencyPair) {
+	s.l.Lock()
+	e := s.bookEvent(s.book(pair))
+	s.l.Unlock()
+	writeJSON(w, http.StatusOK, e.data)
+}
+
+func (s *Server) handleOHLC(w http.ResponseWriter, r *http.Request, pair generic.CurrencyPair) {
+	q := r.URL.Query()
+	stepSec, err := strconv.Atoi(q.Get("step"))
+	if err != nil || stepSec <= 0 {
+		writeError(w, http.StatusBadRequest, "Invalid step")
+		return
+	}
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		writeError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+	step := time.Duration(stepSec) * time.Second
+	var start, end time.Time
+	if v, err := strconv.ParseInt(q.Get("start"), 10, 64); err == nil {
+		start = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(q.Get("end"), 10, 64); err == nil {
+		end = time.Unix(v, 0)
+	}
+
+	type candle struct {
+		start                          time.Time
+		open, high, low, close, volume float64
+	}
+	list := make([]*candle, 0)
+	s.l.Lock()
+	for _, t := range s.trades[pair] {
+		if (!start.IsZero() && t.date.Before(start)) || (!end.IsZero() && t.date.After(end)) {
+			continue
+		}
+		st := t.date.Truncate(step)
+		if len(list) == 0 || !list[len(list)-1].start.Equal(st) {
+			list = append(list, &candle{st, t.price, t.price, t.price, t.price, 0})
+		}
+		c := list[len(list)-1]
+		c.high = math.Max(c.high, t.price)
+		c.low = math.Min(c.low, t.price)
+		c.close = t.price
+		c.volume += t.amount
+	}
+	s.l.Unlock()
+
+	if start.IsZero() && len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	res := make([]map[string]string, len(list))
+	for i, c := range list {
+		res[i] = map[string]string{
+			"timestamp": formatUnix(c.start),
+			"open":      formatFloat(c.open),
+			"high":      formatFloat(c.high),
+			"low":       formatFloat(c.low),
+			"close":     formatFloat(c.close),
+			"volume":    formatFloat(c.volume),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"pair": strings.ToUpper(pair.Base.String() + "/" + pair.Counter.String()),
+			"ohlc": res,
+		},
+	})
+}
+
+func (s *Server) handlePairs(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	list := make([]map[string]interface{}, 0, len(s.pairs))
+	for _, p := range s.pairs {
+		name := strings.ToUpper(p.Base.String() + "/" + p.Counter.String())
+		list = append(list, map[string]interface{}{
+			"name":             name,
+			"url_symbol":       p.String(),
+			"base_decimals":    8,
+			"counter_decimals": 8,
+			"minimum_order":    "0 " + strings.ToUpper(p.Counter.String()),
+			"trading":          "Enabled",
+			"description":      name,
+		})
+	}
+	s.l.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["url_symbol"].(string) < list[j]["url_symbol"].(string)
+	})
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request, acc *Account, pair generic.CurrencyPair, hasPair bool) {
+	s.l.Lock()
+	b := acc.balance()
+	s.l.Unlock()
+
+	if !hasPair {
+		writeJSON(w, http.StatusOK, b)
+		return
+	}
+
+	n := map[string]interface{}{"fee": b[pair.String()+"_fee"]}
+	for _, c := range []generic.Currency{pair.Base, pair.Counter} {
+		for _, f := range []string{"available", "reserved", "balance", "withdrawal_fee"} {
+			k := c.String() + "_" + f
+			n[k] = b[k]
+		}
+	}
+	writeJSON(w, http.StatusOK, n)
+}
+
+func (s *Server) handleUserTransactions(w http.ResponseWriter, r *http.Request, acc *Account, pair generic.CurrencyPair, hasPair bool) {
+	intParam := func(name string, def int) int {
+		v, err := strconv.Atoi(r.Form.Get(name))
+		if err != nil {
+			return def
+		}
+		return v
+	}
+
+	limit := intParam("limit", 100)
+	if limit > 1000 {
+		writeError(w, http.StatusBadRequest, "limit can not exceed 1000")
+		return
+	}
+	offset := intParam("offset", 0)
+	sinceID := uint64(intParam("since_id", 0))
+	since := int64(intParam("since_timestamp", 0))
+	until := int64(intParam("until_timestamp", 0))
+	desc := r.Form.Get("sort") != "asc"
+
+	s.l.Lock()
+	list := make([]map[string]interface{}, 0)
+	for _, t := range acc.txs {
+		if hasPair && t.pair != pair {
+			continue
+		}
+		if t.id < sinceID ||
+			(since != 0 && t.date.Unix() < since) ||
+			(until != 0 && t.date.Unix() > until) {
+			continue
+		}
+		list = append(list, t.encode())
+	}
+	s.l.Unlock()
+
+	if desc {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+	if offset > len(list) {
+		offset = len(list)
+	}
+	list = list[offset:]
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func encodeOrder(o *order) map[string]string {
+	return map[string]string{
+		"id":            strconv.FormatUint(o.id, 10),
+		"datetime":      formatDate(o.date),
+		"type":          strconv.Itoa(int(o.side)),
+		"price":         formatFloat(o.price),
+		"amount":        formatFloat(o.amount),
+		"currency_pair": strings.ToUpper(o.pair.Base.String() + "/" + o.pair.Counter.String()),
+	}
+}
+
+func (s *Server) handleOpenOrders(w http.ResponseWriter, r *http.Request, acc *Account, pair generic.CurrencyPair, hasPair bool) {
+	s.l.Lock()
+	list := make([]*order, 0)
+	for p, b := range s.books {
+		if hasPair && p != pair {
+			continue
+		}
+		for _, side := range [][]*order{b.bids, b.asks} {
+			for _, o := range side {
+				if o.account == acc {
+					list = append(list, o)
+				}
+			}
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	res := make([]map[string]string, len(list))
+	for i, o := range list {
+		res[i] = encodeOrder(o)
+	}
+	s.l.Unlock()
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) cancel(b *book, o *order) {
+	b.remove(o)
+	a := o.account
+	switch o.side {
+	case api.Buy:
+		a.release(o.pair.Counter, o.amount*o.price*(1+a.fee/100))
+	default:
+		a.release(o.pair.Base, o.amount)
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request, acc *Account) {
+	id, err := strconv.ParseUint(r.Form.Get("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusOK, "Invalid order id")
+		return
+	}
+
+	s.l.Lock()
+	b, o, ok := s.findOrder(id)
+	if !ok || o.account != acc {
+		s.l.Unlock()
+		writeError(w, http.StatusOK, "Order not found")
+		return
+	}
+	s.cancel(b, o)
+	res := map[string]interface{}{
+		"id":     o.id,
+		"amount": o.amount,
+		"price":  o.price,
+		"type":   int(o.side),
+	}
+	events := []event{s.bookEvent(b)}
+	s.l.Unlock()
+
+	s.publish(events)
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleCancelAll(w http.ResponseWriter, r *http.Request, acc *Account) {
+	s.l.Lock()
+	canceled := make([]map[string]interface{}, 0)
+	events := make([]event, 0)
+	for _, b := range s.books {
+		var n int
+		for _, side := range [][]*order{b.bids, b.asks} {
+			for _, o := range append([]*order{}, side...) {
+				if o.account != acc {
+					continue
+				}
+				s.cancel(b, o)
+				n++
+				canceled = append(canceled, map[string]interface{}{
+					"id":            o.id,
+					"amount":        o.amount,
+					"price":         o.price,
+					"type":          int(o.side),
+					"currency_pair": strings.ToUpper(o.pair.Base.String() + "/" + o.pair.Counter.String()),
+				})
+			}
+		}
+		if n != 0 {
+			events = append(events, s.bookEvent(b))
+		}
+	}
+	s.l.Unlock()
+
+	s.publish(events)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "canceled": canceled})
+}
+
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request, acc *Account, toMain bool) {
+	amount, err := strconv.ParseFloat(r.Form.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		writeError(w, http.StatusOK, "Invalid amount")
+		return
+	}
+	c := generic.Currency(strings.ToLower(r.Form.Get("currency")))
+	subID, _ := strconv.ParseUint(r.Form.Get("subAccount"), 10, 64)
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	var from, to *Account
+	switch {
+	case toMain && acc.main != nil:
+		from, to = acc, acc.main
+	case toMain:
+		sub, ok := acc.sub(subID)
+		if !ok {
+			writeError(w, http.StatusOK, "Invalid sub account")
+			return
+		}
+		from, to = sub, acc
+	default:
+		sub, ok := acc.sub(subID)
+		if !ok {
+			writeError(w, http.StatusOK, "Invalid sub account")
+			return
+		}
+		from, to = acc, sub
+	}
+
+	if err := from.transfer(to, c, amount); err != nil {
+		writeError(w, http.StatusOK, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request, acc *Account, pair generic.CurrencyPair, side api.TradeType, typ string) {
+	amount, err := strconv.ParseFloat(r.Form.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		writeOrderError(w, fmt.Errorf("Invalid amount"))
+		return
+	}
+
+	var price float64
+	if typ == "limit" {
+		price, err = strconv.ParseFloat(r.Form.Get("price"), 64)
+		if err != nil || price <= 0 {
+			writeOrderError(w, fmt.Errorf("Invalid price"))
+			return
+		}
+	}
+
+	isTrue := func(k string) bool { return strings.EqualFold(r.Form.Get(k), "true") }
+	inCounter := isTrue("amount_in_counter")
+	if typ == "instant" && side == api.Buy {
+		inCounter = true
+	}
+
+	s.l.Lock()
+	o, events, err := s.place(acc, pair, side, typ, amount, price, inCounter, isTrue("ioc_order"), isTrue("fok_order"))
+	var res map[string]string
+	if err == nil {
+		res = encodeOrder(o)
+	}
+	s.l.Unlock()
+
+	s.publish(events)
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) place(
+	acc *Account,
+	pair generic.CurrencyPair,
+	side api.TradeType,
+	typ string,
+	amount, price float64,
+	inCounter, ioc, fok bool,
+) (*order, []event, error) {
+	b := s.book(pair)
+	o := &order{
+		id:      s.nextID(),
+		account: acc,
+		pair:    pair,
+		side:    side,
+		price:   price,
+		amount:  amount,
+		date:    time.Now(),
+	}
+
+	fills, filled := b.match(side, price, amount, inCounter, false)
+	if typ != "limit" && len(fills) == 0 {
+		return nil, nil, ErrNoLiquidity
+	}
+	if fok && filled < amount-epsilon {
+		return nil, nil, fmt.Errorf("Order could not be fully filled")
+	}
+
+	var cost float64
+	for _, f := range fills {
+		cost += f.amount * f.price
+	}
+
+	if typ == "limit" {
+		reserve := amount
+		c := pair.Base
+		if side == api.Buy {
+			reserve, c = amount*price*(1+acc.fee/100), pair.Counter
+		}
+		if err := acc.reserve(c, reserve); err != nil {
+			return nil, nil, err
+		}
+
+		events := s.execute(b, acc, o.id, side, price, fills)
+		o.amount -= filled
+		if o.amount > epsilon && !ioc {
+			b.insert(o)
+			events = append(events, s.bookEvent(b))
+		} else {
+			s.cancel(b, o)
+		}
+		return o, events, nil
+	}
+
+	var err error
+	switch side {
+	case api.Buy:
+		err = acc.funds(pair.Counter, cost*(1+acc.fee/100))
+	default:
+		err = acc.funds(pair.Base, filled)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o.amount, o.price = filled, cost/filled
+	return o, s.execute(b, acc, o.id, side, 0, fills), nil
+}
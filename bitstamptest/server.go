@@ -0,0 +1,196 @@
+// Package bitstamptest provides an in-memory fake bitstamp exchange for
+// offline testing, serving both the REST api and the websocket feed.
+package bitstamptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	apiPath = "/api/v2/"
+	wsPath  = "/ws"
+)
+
+// DefaultFee is the trading fee percentage of new accounts.
+const DefaultFee = 0.5
+
+type Server struct {
+	srv *httptest.Server
+
+	l        sync.Mutex
+	id       uint64
+	accounts map[string]*Account
+	pairs    map[string]generic.CurrencyPair
+	books    map[generic.CurrencyPair]*book
+	trades   map[generic.CurrencyPair][]trade
+	failures map[string][]failure
+	nonces   map[string]struct{}
+
+	lWS   sync.Mutex
+	conns map[*wsConn]struct{}
+}
+
+type failure struct {
+	status int
+	reason string
+}
+
+// New starts a new fake exchange that knows about all bitstamp.AllPairs.
+func New() *Server {
+	s := &Server{
+		accounts: make(map[string]*Account),
+		pairs:    make(map[string]generic.CurrencyPair),
+		books:    make(map[generic.CurrencyPair]*book),
+		trades:   make(map[generic.CurrencyPair][]trade),
+		failures: make(map[string][]failure),
+		nonces:   make(map[string]struct{}),
+		conns:    make(map[*wsConn]struct{}),
+	}
+	for _, p := range bitstamp.AllPairs() {
+		s.AddPair(p)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPath, s.serveAPI)
+	mux.Handle(wsPath, websocket.Handler(s.serveWS))
+	s.srv = httptest.NewServer(mux)
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.DropWS()
+	s.srv.Close()
+}
+
+// URL returns the v2 api endpoint.
+func (s *Server) URL() string { return s.srv.URL + strings.TrimRight(apiPath, "/") }
+
+// WSURL returns the websocket endpoint.
+func (s *Server) WSURL() string { return "ws" + strings.TrimPrefix(s.srv.URL, "http") + wsPath }
+
+func (s *Server) API(key, secret string) *api.API {
+	return api.New(key, secret, s.URL(), s.srv.Client())
+}
+
+func (s *Server) WSConfig() (*websocket.Config, error) {
+	return websocket.NewConfig(s.WSURL(), s.srv.URL)
+}
+
+// Client returns a bitstamp client that talks to this server.
+func (s *Server) Client(key, secret string) (*bitstamp.Bitstamp, error) {
+	return bitstamp.NewEndpoints(key, secret, s.URL(), s.WSURL(), s.srv.URL)
+}
+
+func (s *Server) AddPair(pair generic.CurrencyPair) {
+	s.l.Lock()
+	s.pairs[pair.String()] = pair
+	if _, ok := s.books[pair]; !ok {
+		s.books[pair] = &book{pair: pair}
+	}
+	s.l.Unlock()
+}
+
+// FailNext makes the next request to the given endpoint (e.g. "balance" or
+// "buy/market") fail with the given http status and reason.
+func (s *Server) FailNext(endpoint string, status int, reason string) {
+	endpoint = strings.Trim(endpoint, "/")
+	s.l.Lock()
+	s.failures[endpoint] = append(s.failures[endpoint], failure{status, reason})
+	s.l.Unlock()
+}
+
+func (s *Server) nextID() uint64 {
+	s.id++
+	return s.id
+}
+
+func (s *Server) failure(endpoint string) (failure, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for e, list := range s.failures {
+		if len(list) == 0 || (e != endpoint && !strings.HasPrefix(endpoint, e+"/")) {
+			continue
+		}
+		f := list[0]
+		s.failures[e] = list[1:]
+		return f, true
+	}
+	return failure{}, false
+}
+
+func (s *Server) authenticate(r *http.Request) (*Account, error) {
+	key := strings.TrimPrefix(r.Header.Get("X-Auth"), "BITSTAMP ")
+	s.l.Lock()
+	acc, ok := s.accounts[key]
+	s.l.Unlock()
+	if !ok {
+		return nil, api.ErrInvalidAuth
+	}
+
+	if err := api.Verify(acc.Key, acc.Secret, r); err != nil {
+		return nil, err
+	}
+
+	ms, err := strconv.ParseInt(r.Header.Get("X-Auth-Timestamp"), 10, 64)
+	if err != nil {
+		return nil, api.ErrInvalidAuth
+	}
+	if d := time.Since(time.Unix(0, ms*1e6)); d > time.Second*150 || d < -time.Second*150 {
+		return nil, fmt.Errorf("timestamp too far off: %s", d)
+	}
+
+	nonce := r.Header.Get("X-Auth-Nonce")
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.nonces[nonce]; ok {
+		return nil, fmt.Errorf("nonce %s reused", nonce)
+	}
+	s.nonces[nonce] = struct{}{}
+
+	return acc, nil
+}
+
+func (s *Server) pair(str string) (generic.CurrencyPair, bool) {
+	s.l.Lock()
+	p, ok := s.pairs[str]
+	s.l.Unlock()
+	return p, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, reason interface{}) {
+	writeJSON(w, status, map[string]interface{}{"status": "error", "reason": reason})
+}
+
+// writeOrderError mimics the reason format bitstamp uses for failed orders.
+func writeOrderError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusOK, map[string]interface{}{"__all__": []string{err.Error()}})
+}
+
+func formatFloat(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000000")
+}
+
+func formatUnix(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }
+
+func formatUnixMicro(t time.Time) string { return strconv.FormatInt(t.UnixNano()/1e3, 10) }
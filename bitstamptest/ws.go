@@ -0,0 +1,127 @@
+package bitstamptest
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/frizinak/bitstamp/ws"
+	"golang.org/x/net/websocket"
+)
+
+type event struct {
+	channel ws.Channel
+	event   ws.Event
+	data    interface{}
+}
+
+func (e event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"channel": e.channel,
+		"event":   e.event,
+		"data":    e.data,
+	})
+}
+
+type wsConn struct {
+	conn *websocket.Conn
+
+	l    sync.Mutex
+	subs map[ws.Channel]struct{}
+}
+
+func (c *wsConn) send(e event) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return websocket.JSON.Send(c.conn, e)
+}
+
+func (c *wsConn) subscribed(ch ws.Channel) bool {
+	c.l.Lock()
+	_, ok := c.subs[ch]
+	c.l.Unlock()
+	return ok
+}
+
+func (s *Server) serveWS(conn *websocket.Conn) {
+	c := &wsConn{conn: conn, subs: make(map[ws.Channel]struct{})}
+	s.lWS.Lock()
+	s.conns[c] = struct{}{}
+	s.lWS.Unlock()
+
+	defer func() {
+		s.lWS.Lock()
+		delete(s.conns, c)
+		s.lWS.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		var msg ws.Subscribe
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+
+		ch := msg.Data.Channel
+		var reply ws.Event
+		c.l.Lock()
+		switch msg.Event {
+		case "bts:subscribe":
+			c.subs[ch] = struct{}{}
+			reply = "bts:subscription_succeeded"
+		case "bts:unsubscribe":
+			delete(c.subs, ch)
+			reply = "bts:unsubscription_succeeded"
+		default:
+			reply = "bts:error"
+		}
+		c.l.Unlock()
+
+		if err := c.send(event{channel: ch, event: reply, data: struct{}{}}); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) publish(events []event) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.lWS.Lock()
+	conns := make([]*wsConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.lWS.Unlock()
+
+	for _, e := range events {
+		for _, c := range conns {
+			if c.subscribed(e.channel) {
+				c.send(e)
+			}
+		}
+	}
+}
+
+// DropWS closes all websocket connections.
+func (s *Server) DropWS() {
+	s.lWS.Lock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.lWS.Unlock()
+}
+
+// Subscribers returns the amount of connections subscribed to the given
+// channel.
+func (s *Server) Subscribers(ch ws.Channel) int {
+	s.lWS.Lock()
+	defer s.lWS.Unlock()
+	var n int
+	for c := range s.conns {
+		if c.subscribed(ch) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,281 @@
+// Package candle aggregates trades into OHLCV candles.
+package candle
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+type Candle struct {
+	Start    time.Time
+	Interval time.Duration
+
+	Open  float64
+	High  float64
+	Low   float64
+	Close float64
+
+	// Volume is expressed in base, QuoteVolume in counter currency.
+	Volume      float64
+	QuoteVolume float64
+	BuyVolume   float64
+	SellVolume  float64
+	Trades      int
+}
+
+func (c Candle) End() time.Time { return c.Start.Add(c.Interval) }
+
+// VWAP returns the volume weighted average price, falls back to the close
+// price for candles without volume.
+func (c Candle) VWAP() float64 {
+	if c.Volume == 0 {
+		return c.Close
+	}
+	return c.QuoteVolume / c.Volume
+}
+
+func (c Candle) Empty() bool { return c.Trades == 0 && c.Volume == 0 }
+
+func (c *Candle) add(t bitstamp.Trade) {
+	if c.Empty() {
+		c.Open, c.High, c.Low = t.Price, t.Price, t.Price
+	}
+	c.High = math.Max(c.High, t.Price)
+	c.Low = math.Min(c.Low, t.Price)
+	c.Close = t.Price
+	c.Volume += t.Amount
+	c.QuoteVolume += t.Amount * t.Price
+	c.Trades++
+	switch t.Type {
+	case api.Buy:
+		c.BuyVolume += t.Amount
+	case api.Sell:
+		c.SellVolume += t.Amount
+	}
+}
+
+// FromOHLC converts an ohlc api result. The buy/sell split and trade count
+// are unknown and QuoteVolume is estimated.
+func FromOHLC(o api.OHLC, step time.Duration) Candle {
+	c := Candle{
+		Start:    o.Time.Value(),
+		Interval: step,
+		Open:     o.Open.Value(),
+		High:     o.High.Value(),
+		Low:      o.Low.Value(),
+		Close:    o.Close.Value(),
+		Volume:   o.Volume.Value(),
+	}
+	c.QuoteVolume = c.Volume * (c.Open + c.High + c.Low + c.Close) / 4
+	return c
+}
+
+// Fetch retrieves candles in [from, until) from the ohlc endpoint, interval
+// has to be one of api.OHLCSteps.
+func Fetch(a *api.API, pair generic.CurrencyPair, interval time.Duration, from, until time.Time) ([]Candle, error) {
+	if until.IsZero() {
+		until = time.Now()
+	}
+	list := make([]Candle, 0)
+	start := from.Truncate(interval)
+	for start.Before(until) {
+		r, err := a.OHLC(pair, interval, api.OHLCLimit, start, until)
+		if err != nil {
+			return list, err
+		}
+		next := start
+		for _, o := range r.List {
+			c := FromOHLC(o, interval)
+			if c.Start.Before(start) || !c.Start.Before(until) {
+				continue
+			}
+			list = append(list, c)
+			next = c.End()
+		}
+		if len(r.List) < api.OHLCLimit || !next.After(start) {
+			break
+		}
+		start = next
+	}
+
+	return list, nil
+}
+
+type Event struct {
+	Candle
+	// Final is set once the candle will no longer change.
+	Final bool
+}
+
+// Builder aggregates trades into candles of a fixed interval.
+type Builder struct {
+	interval time.Duration
+	fillGaps bool
+
+	current Candle
+	has     bool
+
+	prev    Candle
+	hasPrev bool
+	seeded  time.Time
+}
+
+// NewBuilder creates a builder, if fillGaps is set intervals without any
+// trades produce empty candles at the previous close.
+func NewBuilder(interval time.Duration, fillGaps bool) *Builder {
+	return &Builder{interval: interval, fillGaps: fillGaps}
+}
+
+func (b *Builder) Interval() time.Duration { return b.interval }
+
+// Current returns the forming candle.
+func (b *Builder) Current() (Candle, bool) { return b.current, b.has }
+
+// Seed initializes the builder with historical candles ordered by time,
+// e.g. from Fetch. The returned events are final except for the last
+// candle if it has not ended at asOf. Trades up until asOf are assumed to
+// be included in the seed and are ignored by Add.
+func (b *Builder) Seed(candles []Candle, asOf time.Time) []Event {
+	sort.SliceStable(candles, func(i, j int) bool { return candles[i].Start.Before(candles[j].Start) })
+	events := make([]Event, 0, len(candles))
+	for i, c := range candles {
+		if i == len(candles)-1 && c.End().After(asOf) {
+			b.current, b.has = c, true
+			events = append(events, Event{Candle: c})
+			break
+		}
+		b.prev, b.hasPrev = c, true
+		events = append(events, Event{Candle: c, Final: true})
+	}
+	b.seeded = asOf
+	return events
+}
+
+// Add adds a trade, returning finalized candles followed by the forming
+// one. Trades older than the forming candle are ignored.
+func (b *Builder) Add(t bitstamp.Trade) []Event {
+	start := t.Date.Truncate(b.interval)
+	if !t.Date.After(b.seeded) || (b.hasPrev && start.Before(b.prev.End())) {
+		return nil
+	}
+
+	var events []Event
+	if b.has {
+		if start.Before(b.current.Start) {
+			return nil
+		}
+		if start.After(b.current.Start) {
+			events = b.finalize()
+		}
+	}
+
+	if !b.has {
+		events = append(events, b.fill(start)...)
+		b.current, b.has = Candle{Start: start, Interval: b.interval}, true
+	}
+	b.current.add(t)
+
+	return append(events, Event{Candle: b.current})
+}
+
+// Tick finalizes the forming candle if it ended before now, it should be
+// called periodically when trades are sparse.
+func (b *Builder) Tick(now time.Time) []Event {
+	if b.has && !now.Before(b.current.End()) {
+		return append(b.finalize(), b.fill(now.Truncate(b.interval))...)
+	}
+	if !b.has {
+		return b.fill(now.Truncate(b.interval))
+	}
+	return nil
+}
+
+func (b *Builder) finalize() []Event {
+	b.prev, b.hasPrev = b.current, true
+	b.has = false
+	return []Event{{Candle: b.current, Final: true}}
+}
+
+// fill creates empty candles after the last final one until next, if
+// enabled.
+func (b *Builder) fill(next time.Time) []Event {
+	if !b.fillGaps || !b.hasPrev {
+		return nil
+	}
+
+	var events []Event
+	for s := b.prev.End(); s.Before(next); s = s.Add(b.interval) {
+		c := Candle{
+			Start:    s,
+			Interval: b.interval,
+			Open:     b.prev.Close,
+			High:     b.prev.Close,
+			Low:      b.prev.Close,
+			Close:    b.prev.Close,
+		}
+		b.prev = c
+		events = append(events, Event{Candle: c, Final: true})
+	}
+
+	return events
+}
+
+// Series is a list of candles ordered by time.
+type Series []Candle
+
+// Add inserts or updates the candle with the same start.
+func (s Series) Add(c Candle) Series {
+	if len(s) != 0 && s[len(s)-1].Start.Equal(c.Start) {
+		s[len(s)-1] = c
+		return s
+	}
+	if len(s) == 0 || s[len(s)-1].Start.Before(c.Start) {
+		return append(s, c)
+	}
+
+	ix := sort.Search(len(s), func(i int) bool { return !s[i].Start.Before(c.Start) })
+	if ix < len(s) && s[ix].Start.Equal(c.Start) {
+		s[ix] = c
+		return s
+	}
+	s = append(s, Candle{})
+	copy(s[ix+1:], s[ix:])
+	s[ix] = c
+	return s
+}
+
+// Range returns the candles starting in [from, until).
+func (s Series) Range(from, until time.Time) Series {
+	min := sort.Search(len(s), func(i int) bool { return !s[i].Start.Before(from) })
+	max := sort.Search(len(s), func(i int) bool { return !s[i].Start.Before(until) })
+	if max < min {
+		max = min
+	}
+	return s[min:max]
+}
+
+// VWAP returns the volume weighted average price of all candles.
+func (s Series) VWAP() float64 {
+	var q, v float64
+	for _, c := range s {
+		q += c.QuoteVolume
+		v += c.Volume
+	}
+	if v == 0 {
+		return 0
+	}
+	return q / v
+}
+
+func (s Series) Volume() float64 {
+	var v float64
+	for _, c := range s {
+		v += c.Volume
+	}
+	return v
+}
@@ -0,0 +1,164 @@
+package candle
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+)
+
+var start = time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(min, sec int) time.Time {
+	return start.Add(time.Duration(min)*time.Minute + time.Duration(sec)*time.Second)
+}
+
+func trade(date time.Time, price, amount float64, typ api.TradeType) bitstamp.Trade {
+	return bitstamp.Trade{Date: date, Price: price, Amount: amount, Type: typ}
+}
+
+type exp struct {
+	min   int
+	final bool
+
+	o, h, l, c float64
+	volume     float64
+	trades     int
+}
+
+func check(t *testing.T, name string, events []Event, list []exp) {
+	t.Helper()
+	if len(events) != len(list) {
+		t.Fatalf("%s: %d events %+v, expected %d", name, len(events), events, len(list))
+	}
+	for i, e := range events {
+		x := list[i]
+		if !e.Start.Equal(at(x.min, 0)) || e.Interval != time.Minute || e.Final != x.final {
+			t.Errorf("%s: event %d starts %s final %t, expected %s %t", name, i, e.Start, e.Final, at(x.min, 0), x.final)
+		}
+		if e.Open != x.o || e.High != x.h || e.Low != x.l || e.Close != x.c || e.Volume != x.volume || e.Trades != x.trades {
+			t.Errorf("%s: event %d %+v, expected %+v", name, i, e.Candle, x)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	b := NewBuilder(time.Minute, true)
+	check(t, "first", b.Add(trade(at(0, 10), 100, 1, api.Buy)), []exp{
+		{0, false, 100, 100, 100, 100, 1, 1},
+	})
+	check(t, "second", b.Add(trade(at(0, 30), 110, 2, api.Sell)), []exp{
+		{0, false, 100, 110, 100, 110, 3, 2},
+	})
+	check(t, "third", b.Add(trade(at(0, 59), 95, 1, api.Sell)), []exp{
+		{0, false, 100, 110, 95, 95, 4, 3},
+	})
+
+	c, ok := b.Current()
+	if !ok {
+		t.Fatal("no forming candle")
+	}
+	if c.BuyVolume != 1 || c.SellVolume != 3 {
+		t.Errorf("buy volume %g sell volume %g, expected 1 3", c.BuyVolume, c.SellVolume)
+	}
+	if c.QuoteVolume != 100+220+95 || math.Abs(c.VWAP()-415.0/4) > 1e-9 {
+		t.Errorf("quote volume %g vwap %g", c.QuoteVolume, c.VWAP())
+	}
+
+	// Two minutes without trades are filled at the previous close.
+	check(t, "gap", b.Add(trade(at(3, 5), 90, 0.5, api.Buy)), []exp{
+		{0, true, 100, 110, 95, 95, 4, 3},
+		{1, true, 95, 95, 95, 95, 0, 0},
+		{2, true, 95, 95, 95, 95, 0, 0},
+		{3, false, 90, 90, 90, 90, 0.5, 1},
+	})
+
+	// Trades older than the last final candle are ignored, as are trades
+	// older than the forming one.
+	check(t, "old", b.Add(trade(at(2, 59), 1, 1, api.Buy)), nil)
+	check(t, "older", b.Add(trade(at(0, 50), 1, 1, api.Buy)), nil)
+	check(t, "same candle", b.Add(trade(at(3, 0), 91, 1, api.Buy)), []exp{
+		{3, false, 90, 91, 90, 91, 1.5, 2},
+	})
+
+	nofill := NewBuilder(time.Minute, false)
+	nofill.Add(trade(at(0, 10), 100, 1, api.Buy))
+	check(t, "no fill", nofill.Add(trade(at(3, 5), 90, 1, api.Buy)), []exp{
+		{0, true, 100, 100, 100, 100, 1, 1},
+		{3, false, 90, 90, 90, 90, 1, 1},
+	})
+}
+
+func TestSeed(t *testing.T) {
+	seed := []Candle{
+		{Start: at(1, 0), Interval: time.Minute, Open: 101, High: 102, Low: 100, Close: 102, Volume: 2, QuoteVolume: 203, Trades: 2},
+		{Start: at(0, 0), Interval: time.Minute, Open: 100, High: 100, Low: 99, Close: 99, Volume: 1, QuoteVolume: 99, Trades: 1},
+	}
+
+	b := NewBuilder(time.Minute, true)
+	check(t, "seed", b.Seed(seed, at(1, 30)), []exp{
+		{0, true, 100, 100, 99, 99, 1, 1},
+		{1, false, 101, 102, 100, 102, 2, 2},
+	})
+
+	// Trades at or before the seed time are already included.
+	check(t, "before seed", b.Add(trade(at(1, 20), 200, 1, api.Buy)), nil)
+	check(t, "at seed", b.Add(trade(at(1, 30), 200, 1, api.Buy)), nil)
+	check(t, "after seed", b.Add(trade(at(1, 40), 103, 1, api.Buy)), []exp{
+		{1, false, 101, 103, 100, 103, 3, 3},
+	})
+
+	b = NewBuilder(time.Minute, true)
+	check(t, "seed ended", b.Seed(seed, at(2, 0)), []exp{
+		{0, true, 100, 100, 99, 99, 1, 1},
+		{1, true, 101, 102, 100, 102, 2, 2},
+	})
+	if _, ok := b.Current(); ok {
+		t.Error("forming candle after seeding only ended candles")
+	}
+	check(t, "gap after seed", b.Add(trade(at(3, 0), 104, 1, api.Sell)), []exp{
+		{2, true, 102, 102, 102, 102, 0, 0},
+		{3, false, 104, 104, 104, 104, 1, 1},
+	})
+}
+
+func TestTick(t *testing.T) {
+	b := NewBuilder(time.Minute, true)
+	check(t, "empty", b.Tick(at(5, 0)), nil)
+
+	b.Add(trade(at(0, 10), 100, 1, api.Buy))
+	check(t, "before end", b.Tick(at(0, 59)), nil)
+	check(t, "at end", b.Tick(at(1, 0)), []exp{
+		{0, true, 100, 100, 100, 100, 1, 1},
+	})
+	check(t, "gap", b.Tick(at(3, 30)), []exp{
+		{1, true, 100, 100, 100, 100, 0, 0},
+		{2, true, 100, 100, 100, 100, 0, 0},
+	})
+	check(t, "again", b.Tick(at(3, 40)), nil)
+	check(t, "trade", b.Add(trade(at(3, 50), 101, 1, api.Buy)), []exp{
+		{3, false, 101, 101, 101, 101, 1, 1},
+	})
+	check(t, "late trade", b.Add(trade(at(2, 50), 1, 1, api.Buy)), nil)
+}
+
+func TestSeriesVWAP(t *testing.T) {
+	var s Series
+	s = s.Add(Candle{Start: at(1, 0), Volume: 2, QuoteVolume: 210})
+	s = s.Add(Candle{Start: at(0, 0), Volume: 1, QuoteVolume: 100})
+	s = s.Add(Candle{Start: at(1, 0), Volume: 3, QuoteVolume: 330})
+	if len(s) != 2 || !s[0].Start.Equal(at(0, 0)) {
+		t.Fatalf("series %+v", s)
+	}
+	if s.Volume() != 4 || s.VWAP() != 107.5 {
+		t.Errorf("volume %g vwap %g, expected 4 107.5", s.Volume(), s.VWAP())
+	}
+	if r := s.Range(at(1, 0), at(2, 0)); len(r) != 1 || r.VWAP() != 110 {
+		t.Errorf("range %+v", r)
+	}
+	if (Candle{Close: 5}).VWAP() != 5 {
+		t.Error("vwap of a candle without volume is not its close")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type credentials struct {
+	key, secret string
+}
+
+type subAccount struct {
+	id   uint64
+	name string
+	credentials
+}
+
+func (s subAccount) String() string {
+	if s.name != "" {
+		return s.name
+	}
+	return strconv.FormatUint(s.id, 10)
+}
+
+type subAccounts []subAccount
+
+func (s subAccounts) find(id uint64) (subAccount, bool) {
+	for _, a := range s {
+		if a.id == id {
+			return a, true
+		}
+	}
+	return subAccount{}, false
+}
+
+func readAuth(configDir string) (credentials, error) {
+	var c credentials
+	authFile := filepath.Join(configDir, "auth")
+	f, err := os.Open(authFile)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	authBin, err := io.ReadAll(f)
+	if err != nil {
+		return c, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(authBin)), "\n")
+	if len(lines) < 2 {
+		return c, errors.New("auth file invalid")
+	}
+	c.key, c.secret = strings.TrimSpace(lines[0]), strings.TrimSpace(lines[1])
+	return c, nil
+}
+
+// readSubAccounts reads <config>/subaccounts which contains a line per sub
+// account: <id> <key> <secret> [name].
+func readSubAccounts(configDir string) (subAccounts, error) {
+	list := make(subAccounts, 0)
+	f, err := os.Open(filepath.Join(configDir, "subaccounts"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return list, nil
+		}
+		return list, err
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	var n int
+	for scan.Scan() {
+		n++
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return list, fmt.Errorf("subaccounts file invalid on line %d", n)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return list, fmt.Errorf("subaccounts file invalid on line %d: %w", n, err)
+		}
+		a := subAccount{id: id, credentials: credentials{fields[1], fields[2]}}
+		if len(fields) > 3 {
+			a.name = strings.Join(fields[3:], " ")
+		}
+		list = append(list, a)
+	}
+
+	return list, scan.Err()
+}
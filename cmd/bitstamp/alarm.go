@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/indicator"
+)
+
+type ruleState byte
+
+const (
+	ruleWaiting ruleState = iota
+	ruleArmed
+	ruleTriggered
+	ruleDone
+)
+
+func (s ruleState) String() string {
+	switch s {
+	case ruleArmed:
+		return "armed"
+	case ruleTriggered:
+		return "fired"
+	case ruleDone:
+		return "done"
+	}
+	return "waiting"
+}
+
+type Rule struct {
+	Name     string
+	Cooldown time.Duration
+	Once     bool
+	Throttle time.Duration
+	Notify   []string
+
+	src   string
+	cond  node
+	rearm node
+
+	state ruleState
+	fired time.Time
+	count int
+}
+
+func (r *Rule) String() string { return r.src }
+
+// Check evaluates the rule and reports whether it fired.
+func (r *Rule) Check(m *market) bool {
+	if r.state == ruleDone {
+		return false
+	}
+
+	v, ok := r.cond.eval(m)
+	if r.state == ruleWaiting {
+		if !ok {
+			return false
+		}
+		r.state = ruleArmed
+		if v != 0 {
+			// Don't fire for conditions that are already true on startup.
+			r.state = ruleTriggered
+		}
+	}
+
+	switch r.state {
+	case ruleTriggered:
+		if r.rearm == nil {
+			if ok && v == 0 {
+				r.state = ruleArmed
+			}
+			break
+		}
+		if rv, ok := r.rearm.eval(m); ok && rv != 0 {
+			r.state = ruleArmed
+		}
+	}
+
+	if r.state != ruleArmed || !ok || v == 0 || r.cooling(m.now) {
+		return false
+	}
+
+	r.fired = m.now
+	r.count++
+	r.state = ruleTriggered
+	if r.Once {
+		r.state = ruleDone
+	}
+	return true
+}
+
+func (r *Rule) cooling(now time.Time) bool {
+	return r.count != 0 && now.Sub(r.fired) < r.Cooldown
+}
+
+func (r *Rule) status(now time.Time) string {
+	if r.state != ruleDone && r.cooling(now) {
+		return fmt.Sprintf("cooldown %s", (r.Cooldown - now.Sub(r.fired)).Round(time.Second))
+	}
+	if r.count == 0 {
+		return r.state.String()
+	}
+	return fmt.Sprintf("%s %dx %s", r.state, r.count, r.fired.Format("15:04:05"))
+}
+
+type Rules []*Rule
+
+type flagAlarms []string
+
+func (a *flagAlarms) String() string { return "alarms" }
+
+func (a *flagAlarms) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+func (a flagAlarms) Parse() (Rules, error) {
+	n := make(Rules, 0, len(a))
+	for _, a := range a {
+		r, err := ParseRule(a)
+		if err != nil {
+			return n, fmt.Errorf("invalid alarm '%s': %w", a, err)
+		}
+		n = append(n, r)
+	}
+
+	return n, nil
+}
+
+// readRules reads one rule per line, empty lines and lines starting with #
+// are ignored.
+func readRules(file string) (Rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := make(flagAlarms, 0)
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		list = append(list, line)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	rules, err := list.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return rules, nil
+}
+
+// market is the data alarm rules are evaluated against.
+type market struct {
+	now      time.Time
+	price    float64
+	trade    bool
+	book     bitstamp.OrderBook
+	needBook bool
+
+	// live is set by the first live trade, history trades fed before it
+	// do not change the state of rules.
+	live bool
+
+	minutes *candle.Builder
+	history candle.Series
+	keep    time.Duration
+
+	candles    *candle.Builder
+	indicators map[string]indicator.Indicator
+}
+
+func newMarket(rules Rules) *market {
+	return newMarketKeep(rules, 0)
+}
+
+// newMarketKeep creates a market that keeps at least keep worth of
+// history.
+func newMarketKeep(rules Rules, keep time.Duration) *market {
+	m := &market{
+		minutes:    candle.NewBuilder(time.Minute, true),
+		history:    make(candle.Series, 0),
+		candles:    candle.NewBuilder(time.Minute*5, true),
+		indicators: make(map[string]indicator.Indicator),
+		keep:       keep,
+	}
+
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case binary:
+			walk(n.l)
+			walk(n.r)
+		case not:
+			walk(n.n)
+		case neg:
+			walk(n.n)
+		case variable:
+			var d time.Duration
+			for _, v := range n.durs {
+				d += v
+			}
+			if d > m.keep {
+				m.keep = d
+			}
+			if n.ind != nil {
+				m.indicators[n.ind.String()] = n.ind
+			}
+			switch n.name {
+			case "bid", "ask", "mid", "spread", "spread_pct":
+				m.needBook = true
+			}
+		}
+	}
+	for _, r := range rules {
+		walk(r.cond)
+		if r.rearm != nil {
+			walk(r.rearm)
+		}
+	}
+	m.keep += time.Minute
+
+	return m
+}
+
+func (m *market) addTrade(t bitstamp.Trade) {
+	m.now, m.price, m.trade = t.Date, t.Price, true
+	m.live = m.live || t.Live
+	for _, e := range m.minutes.Add(t) {
+		m.history = m.history.Add(e.Candle)
+	}
+	if len(m.history) != 0 && m.history[0].Start.Before(m.now.Add(-m.keep)) {
+		m.history = append(m.history[:0], m.history.Range(m.now.Add(-m.keep), m.now.Add(time.Minute))...)
+	}
+
+	for _, e := range m.candles.Add(t) {
+		for _, i := range m.indicators {
+			i.Update(e.Candle, e.Final)
+		}
+	}
+}
+
+// check returns the rules that fired, none until a live trade was added.
+func (m *market) check(rules Rules) Rules {
+	if !m.live {
+		return nil
+	}
+	return rules.check(m)
+}
+
+func (m *market) setBook(b bitstamp.OrderBook, now time.Time) {
+	m.book = b
+	if now.After(m.now) {
+		m.now = now
+	}
+}
+
+// window returns the candles in [now-d, now) and whether history covers
+// the entire window.
+func (m *market) window(d time.Duration) (candle.Series, bool) {
+	from := m.now.Add(-d)
+	list := m.history.Range(from.Truncate(time.Minute), m.now.Add(time.Minute))
+	return list, len(list) != 0 && !list[0].Start.After(from)
+}
+
+// change returns the price change in percent over d.
+func (m *market) change(d time.Duration) (float64, bool) {
+	list, ok := m.window(d)
+	if len(list) == 0 || list[0].Open == 0 {
+		return 0, false
+	}
+	return (m.price - list[0].Open) / list[0].Open * 100, ok
+}
+
+func (m *market) vwap(d time.Duration) (float64, bool) {
+	list, ok := m.window(d)
+	return list.VWAP(), ok && list.Volume() != 0
+}
+
+func (m *market) value(v variable) (float64, bool) {
+	if v.ind != nil {
+		ind := m.indicators[v.ind.String()]
+		switch i := ind.(type) {
+		case *indicator.Bollinger:
+			l, mid, u, ok := i.Bands()
+			switch v.name {
+			case "bb_lower":
+				return l, ok
+			case "bb_upper":
+				return u, ok
+			}
+			return mid, ok
+		case *indicator.MACD:
+			switch v.name {
+			case "macd_signal":
+				return i.Signal()
+			case "macd_hist":
+				return i.Histogram()
+			}
+		}
+		return ind.Value()
+	}
+
+	switch v.name {
+	case "price":
+		return m.price, m.trade
+	case "bid":
+		b, ok := m.book.Bid()
+		return b.Price, ok
+	case "ask":
+		a, ok := m.book.Ask()
+		return a.Price, ok
+	case "mid":
+		return m.book.Mid()
+	case "spread":
+		return m.book.Spread()
+	case "spread_pct":
+		s, ok1 := m.book.Spread()
+		mid, ok2 := m.book.Mid()
+		if !ok1 || !ok2 || mid == 0 {
+			return 0, false
+		}
+		return s / mid * 100, true
+	case "change":
+		return m.change(v.durs[0])
+	case "vwap":
+		return m.vwap(v.durs[0])
+	case "volume":
+		list, ok := m.window(v.durs[0])
+		return list.Volume(), ok
+	case "spike":
+		d, w := v.durs[0], v.durs[1]
+		list, ok := m.window(d + w)
+		if !ok {
+			return 0, false
+		}
+		recent := list.Range(m.now.Add(-d).Truncate(time.Minute), m.now.Add(time.Minute)).Volume()
+		avg := (list.Volume() - recent) / float64(w) * float64(d)
+		if avg == 0 {
+			return 0, false
+		}
+		return recent / avg, true
+	}
+
+	return 0, false
+}
+
+// check evaluates all rules and returns those that fired.
+func (r Rules) check(m *market) Rules {
+	n := make(Rules, 0)
+	for _, rule := range r {
+		if rule.Check(m) {
+			n = append(n, rule)
+		}
+	}
+	return n
+}
+
+// status returns a status line per rule.
+func (r Rules) status(now time.Time, width int) []string {
+	lines := make([]string, len(r))
+	for i, rule := range r {
+		l := fmt.Sprintf(" %-22s %s", rule.status(now), rule.Name)
+		if width > 0 && len(l) > width {
+			l = l[:width]
+		}
+		lines[i] = l
+	}
+	return lines
+}
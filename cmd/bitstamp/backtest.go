@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/backtest"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/store"
+	"github.com/frizinak/bitstamp/strategy"
+	"github.com/vdobler/chart"
+	"github.com/vdobler/chart/txtg"
+)
+
+var backtestHelp = fmt.Sprintf(`Backtest:
+  backtest <strategy> [trades|equity]
+  Runs a built-in strategy (%s) on the -bc/-cc pair
+  and prints a summary, the simulated trades or the equity curve (-chart to
+  draw it). Market data is taken from -source:
+    ohlc      candles of -interval from the ohlc api (default)
+    store     trades persisted in <config>/trades by live and watch
+    <file>    trades in a websocket recording made with live -record
+  -since limits the data to that duration into the past (default 30 days).`,
+	strings.Join(strategy.Names(), ", "),
+)
+
+type backtestOptions struct {
+	pair      generic.CurrencyPair
+	spec      string
+	source    string
+	configDir string
+	since     time.Duration
+	interval  time.Duration
+	fee       float64
+	slippage  float64
+	balance   float64
+	limits    strategy.Limits
+}
+
+func runBacktest(o backtestOptions) (backtest.Result, error) {
+	s, err := strategy.Parse(o.spec)
+	if err != nil {
+		return backtest.Result{}, err
+	}
+	cfg := backtest.Config{
+		Pair:     o.pair,
+		Interval: o.interval,
+		Fee:      o.fee,
+		Slippage: o.slippage,
+		Balances: map[generic.Currency]float64{o.pair.Counter: o.balance},
+		Limits:   o.limits,
+	}
+	since := o.since
+	if since == 0 {
+		since = 30 * 24 * time.Hour
+	}
+	until := time.Now()
+	from := until.Add(-since)
+
+	var trades []bitstamp.Trade
+	switch o.source {
+	case "", "ohlc":
+		client, err := bitstamp.NewDefaults("", "")
+		if err != nil {
+			return backtest.Result{}, err
+		}
+		candles, err := candle.Fetch(client.API, o.pair, o.interval, from, until)
+		if err != nil {
+			return backtest.Result{}, err
+		}
+		return backtest.Candles(cfg, s, candles)
+	case "store":
+		if o.configDir == "" {
+			return backtest.Result{}, fmt.Errorf("please set a config directory")
+		}
+		st, err := store.Open(filepath.Join(o.configDir, "trades"))
+		if err != nil {
+			return backtest.Result{}, err
+		}
+		defer st.Close()
+		if trades, err = st.Range(o.pair, from, until); err != nil {
+			return backtest.Result{}, err
+		}
+	default:
+		f, err := os.Open(o.source)
+		if err != nil {
+			return backtest.Result{}, err
+		}
+		defer f.Close()
+		if trades, err = backtest.ReadRecording(f, o.pair); err != nil {
+			return backtest.Result{}, err
+		}
+	}
+
+	return backtest.Trades(cfg, s, trades)
+}
+
+func printBacktest(out output, r backtest.Result, show string, drawChart bool) error {
+	pq := bitstamp.Precision(r.Pair.Counter)
+	switch show {
+	case "trades":
+		if out != nil {
+			return writeTransactions(out, r.Trades)
+		}
+		for _, t := range r.Trades {
+			side := "buy"
+			if t.Values[r.Pair.Base] < 0 {
+				side = "sell"
+			}
+			fmt.Printf(
+				"%s %-4s %18.*f @ %14.*f fee %10.*f\n",
+				t.DateTime.Value().Local().Format(dateFormat),
+				side,
+				bitstamp.Precision(r.Pair.Base), t.Values[r.Pair.Base],
+				pq, t.Rate,
+				pq, t.Fee.Value(),
+			)
+		}
+		return nil
+	case "equity":
+		if drawChart {
+			termX, termY := termSize()
+			if termX == 0 {
+				termX, termY = 80, 24
+			}
+			fmt.Print(equityChart(r, termX, termY))
+			return nil
+		}
+		for _, p := range r.Equity {
+			if out != nil {
+				err := out.Write(row{
+					{"datetime", p.Time},
+					{"equity", p.Equity},
+					{"drawdown", p.Drawdown},
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf(
+				"%s %18.*f %7.2f%%\n",
+				p.Time.Local().Format(dateFormat),
+				pq, p.Equity,
+				-p.Drawdown,
+			)
+		}
+		return nil
+	case "":
+	default:
+		return fmt.Errorf("unknown backtest output '%s', use trades or equity", show)
+	}
+
+	var start, end float64
+	if len(r.Equity) != 0 {
+		start, end = r.Equity[0].Equity, r.Equity[len(r.Equity)-1].Equity
+	}
+	if out != nil {
+		return out.Write(row{
+			{"strategy", r.Strategy},
+			{"pair", r.Pair},
+			{"start", r.Start},
+			{"end", r.End},
+			{"start_equity", start},
+			{"end_equity", end},
+			{"return", r.Return},
+			{"max_drawdown", r.MaxDrawdown},
+			{"sharpe", r.Sharpe},
+			{"trades", len(r.Trades)},
+			{"fees", r.Fees},
+			{"rejected", r.Rejected},
+			{"killed", r.Killed},
+		})
+	}
+
+	fmt.Printf("strategy      %s on %s\n", r.Strategy, r.Pair)
+	fmt.Printf("period        %s - %s\n", r.Start.Local().Format(dateFormat), r.End.Local().Format(dateFormat))
+	fmt.Printf("equity        %.*f > %.*f %s\n", pq, start, pq, end, strings.ToUpper(r.Pair.Counter.String()))
+	fmt.Printf("return        %+.2f%%\n", r.Return)
+	fmt.Printf("max drawdown  %.2f%%\n", r.MaxDrawdown)
+	fmt.Printf("sharpe        %.2f\n", r.Sharpe)
+	fmt.Printf("trades        %d\n", len(r.Trades))
+	fmt.Printf("fees          %.*f\n", pq, r.Fees)
+	if r.Rejected != 0 {
+		fmt.Printf("rejected      %d orders\n", r.Rejected)
+	}
+	if r.Killed != "" {
+		fmt.Printf("killed        %s\n", r.Killed)
+	}
+	return nil
+}
+
+func equityChart(r backtest.Result, width, height int) string {
+	tgr := txtg.New(width, height-1)
+	p := chart.ScatterChart{
+		Key:    chart.Key{Hide: true},
+		XRange: chart.Range{Time: true},
+		YRange: chart.Range{Label: r.Pair.Counter.String()},
+	}
+
+	data := make([]chart.EPoint, 0, len(r.Equity))
+	for _, e := range r.Equity {
+		data = append(data, chart.EPoint{X: float64(e.Time.Unix()), Y: e.Equity})
+	}
+	if len(data) != 0 {
+		p.AddData("Equity", data, chart.PlotStyleLines, chart.Style{Symbol: '▓'})
+	}
+	p.Plot(tgr)
+
+	return tgr.String()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+const configHelp = `Config (<config>/config.json, flags take precedence):
+  {
+      "pair": "btc/eur",                 -bc and -cc
+      "window": "24h",                   -h
+      "nograph": false,                  -g
+      "output": "text",                  -o
+      "profile": "main",                 -profile
+      "nostore": false,                  -nostore
+      "rules": "/path/to/rules",         -rules
+      "alarms": ["price > 50000"],       -a
+      "notifiers": ["desktop"],          -notify
+      "indicators": ["ema(50)"],         -i
+      "watchlists": {"default": ["btc/eur", "eth/eur"]}
+  }
+  watch <watchlist> watches the pairs of that list, the 'default' list is
+  used if no pairs are given.
+  config show prints the effective configuration.`
+
+type config struct {
+	Pair       string              `json:"pair,omitempty"`
+	Window     string              `json:"window,omitempty"`
+	NoGraph    bool                `json:"nograph,omitempty"`
+	Output     string              `json:"output,omitempty"`
+	Profile    string              `json:"profile,omitempty"`
+	NoStore    bool                `json:"nostore,omitempty"`
+	Rules      string              `json:"rules,omitempty"`
+	Alarms     []string            `json:"alarms,omitempty"`
+	Notifiers  []string            `json:"notifiers,omitempty"`
+	Indicators []string            `json:"indicators,omitempty"`
+	Watchlists map[string][]string `json:"watchlists,omitempty"`
+}
+
+func readConfig(configDir string) (config, error) {
+	var c config
+	if configDir == "" {
+		return c, nil
+	}
+	file := filepath.Join(configDir, "config.json")
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return c, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("%s: %w", file, err)
+	}
+	return c, nil
+}
+
+// apply sets all flags that were not passed on the command line to their
+// configured value.
+func (c config) apply(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	apply := func(name string, values ...string) {
+		if err != nil || set[name] {
+			return
+		}
+		for _, v := range values {
+			if err = fs.Set(name, v); err != nil {
+				err = fmt.Errorf("config: invalid value '%s' for -%s: %w", v, name, err)
+				return
+			}
+		}
+	}
+
+	if c.Pair != "" {
+		p, err := bitstamp.ParsePair(c.Pair)
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		apply("bc", p.Base.String())
+		apply("cc", p.Counter.String())
+	}
+	if c.Window != "" {
+		apply("h", c.Window)
+	}
+	if c.NoGraph {
+		apply("g", "true")
+	}
+	if c.Output != "" {
+		apply("o", c.Output)
+	}
+	if c.Profile != "" {
+		apply("profile", c.Profile)
+	}
+	if c.NoStore {
+		apply("nostore", "true")
+	}
+	if c.Rules != "" {
+		apply("rules", c.Rules)
+	}
+	apply("a", c.Alarms...)
+	if !set["e"] {
+		apply("notify", c.Notifiers...)
+	}
+	apply("i", c.Indicators...)
+
+	return err
+}
+
+// watchlist returns the pairs of the named watchlist.
+func (c config) watchlist(name string) ([]generic.CurrencyPair, bool, error) {
+	list, ok := c.Watchlists[name]
+	if !ok {
+		return nil, false, nil
+	}
+	pairs := make([]generic.CurrencyPair, 0, len(list))
+	for _, str := range list {
+		p, err := bitstamp.ParsePair(str)
+		if err != nil {
+			return pairs, true, fmt.Errorf("config: watchlist %s: %w", name, err)
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, true, nil
+}
+
+// effective returns the configuration as determined by both the config file
+// and the command line flags.
+func (c config) effective(fs *flag.FlagSet, alarms, notifiers, indicators []string) config {
+	get := func(name string) string { return fs.Lookup(name).Value.String() }
+	b := func(name string) bool {
+		v, _ := strconv.ParseBool(get(name))
+		return v
+	}
+
+	return config{
+		Pair:       strings.ToLower(get("bc") + "/" + get("cc")),
+		Window:     get("h"),
+		NoGraph:    b("g"),
+		Output:     get("o"),
+		Profile:    get("profile"),
+		NoStore:    b("nostore"),
+		Rules:      get("rules"),
+		Alarms:     alarms,
+		Notifiers:  notifiers,
+		Indicators: indicators,
+		Watchlists: c.Watchlists,
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/vdobler/chart"
+	"github.com/vdobler/chart/txtg"
+)
+
+type historyOptions struct {
+	currencies []generic.Currency
+	since      time.Duration
+	rewind     bool
+	chart      bool
+}
+
+// balanceHistory reconstructs the balance history of the account and
+// checks it against the live balance.
+func balanceHistory(client *bitstamp.Bitstamp, rewind bool) (bitstamp.BalanceHistory, map[generic.Currency]float64, error) {
+	var h bitstamp.BalanceHistory
+	list, err := client.Transactions(api.TransactionsQuery{})
+	if err != nil {
+		return h, nil, err
+	}
+	b, err := client.API.Balances()
+	if err != nil {
+		return h, nil, err
+	}
+	live := make(map[generic.Currency]float64, len(b.Currencies))
+	for c, v := range b.Currencies {
+		live[c] = v.Total
+	}
+
+	if rewind {
+		h = bitstamp.RewindBalances(live, list)
+		// Rewinding always ends at the live balance, the account however
+		// started out empty.
+		return h, bitstamp.BalanceDiscrepancies(nil, h.Start, 1e-8), nil
+	}
+	h = bitstamp.ReplayBalances(nil, list)
+	return h, bitstamp.BalanceDiscrepancies(h.End(), live, 1e-8), nil
+}
+
+func printDiscrepancies(diff map[generic.Currency]float64, rewind bool) {
+	list := make([]generic.Currency, 0, len(diff))
+	for c := range diff {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	what := "live balance differs from the replayed balance by"
+	if rewind {
+		what = "balance before the first transaction is"
+	}
+	for _, c := range list {
+		fmt.Fprintf(os.Stderr, "warning: %s %s %+.*f\n", c, what, bitstamp.Precision(c), diff[c])
+	}
+}
+
+func printBalanceHistory(client *bitstamp.Bitstamp, out output, o historyOptions) error {
+	h, diff, err := balanceHistory(client, o.rewind)
+	if err != nil {
+		return err
+	}
+	defer printDiscrepancies(diff, o.rewind)
+
+	currencies := o.currencies
+	if len(currencies) == 0 {
+		currencies = h.Currencies()
+	}
+	points := h.Points
+	if o.since != 0 {
+		since := time.Now().Add(-o.since)
+		n := sort.Search(len(points), func(i int) bool { return points[i].Date.After(since) })
+		points = points[n:]
+	}
+
+	if o.chart {
+		if len(currencies) == 0 {
+			return nil
+		}
+		termX, termY := termSize()
+		if termX == 0 {
+			termX, termY = 80, 24
+		}
+		fmt.Print(balanceChart(points, currencies[0], termX, termY))
+		return nil
+	}
+
+	if out == nil {
+		fmt.Printf("%-19s %12s", "", "transaction")
+		for _, c := range currencies {
+			fmt.Printf(" %18s", c)
+		}
+		fmt.Println()
+	}
+	for _, p := range points {
+		if out != nil {
+			r := row{
+				{"datetime", p.Date},
+				{"transaction_id", p.TransactionID},
+			}
+			for _, c := range currencies {
+				r = append(r, field{c.String(), p.Balances[c]})
+			}
+			if err := out.Write(r); err != nil {
+				return err
+			}
+			continue
+		}
+
+		line := make([]string, 0, len(currencies))
+		for _, c := range currencies {
+			line = append(line, fmt.Sprintf("%18.*f", bitstamp.Precision(c), p.Balances[c]))
+		}
+		fmt.Printf(
+			"%s %12d %s\n",
+			p.Date.Local().Format(dateFormat),
+			p.TransactionID,
+			strings.Join(line, " "),
+		)
+	}
+	return nil
+}
+
+func balanceChart(points []bitstamp.BalancePoint, c generic.Currency, width, height int) string {
+	tgr := txtg.New(width, height-1)
+	p := chart.ScatterChart{
+		Key:    chart.Key{Hide: true},
+		XRange: chart.Range{Time: true},
+		YRange: chart.Range{Label: c.String()},
+	}
+
+	data := make([]chart.EPoint, 0, len(points)*2)
+	prev := 0.0
+	for i, b := range points {
+		x := float64(b.Date.Unix())
+		if i != 0 {
+			data = append(data, chart.EPoint{X: x, Y: prev})
+		}
+		prev = b.Balances[c]
+		data = append(data, chart.EPoint{X: x, Y: prev})
+	}
+	if len(data) != 0 {
+		p.AddData(c.String(), data, chart.PlotStyleLines, chart.Style{Symbol: '▓'})
+	}
+	p.Plot(tgr)
+
+	return tgr.String()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/indicator"
+	"github.com/vdobler/chart"
+)
+
+type flagIndicators []string
+
+func (i *flagIndicators) String() string { return "indicators" }
+
+func (i *flagIndicators) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+func (i flagIndicators) Parse() (indicator.Set, error) {
+	set := make(indicator.Set, 0, len(i))
+	for _, spec := range i {
+		ind, err := indicator.Parse(spec)
+		if err != nil {
+			return set, err
+		}
+		set = append(set, ind)
+	}
+	return set, nil
+}
+
+// chartIndicator tracks the chart lines of an overlay indicator.
+type chartIndicator struct {
+	ind   indicator.Indicator
+	final [][]chart.EPoint
+	lines [][]chart.EPoint
+}
+
+func newChartIndicators(set indicator.Set) []*chartIndicator {
+	list := make([]*chartIndicator, 0, len(set))
+	for _, i := range set {
+		if _, ok := i.(indicator.Overlay); ok {
+			list = append(list, &chartIndicator{ind: i})
+		}
+	}
+	return list
+}
+
+func (c *chartIndicator) values() ([]float64, bool) {
+	if b, ok := c.ind.(*indicator.Bollinger); ok {
+		l, m, u, ok := b.Bands()
+		return []float64{l, m, u}, ok
+	}
+	v, ok := c.ind.Value()
+	return []float64{v}, ok
+}
+
+// update adds the current value of the indicator, now caps the x value of
+// candles that have not ended yet.
+func (c *chartIndicator) update(e candle.Event, now time.Time) {
+	values, ok := c.values()
+	if !ok {
+		return
+	}
+	if c.final == nil {
+		c.final = make([][]chart.EPoint, len(values))
+		c.lines = make([][]chart.EPoint, len(values))
+	}
+
+	t := e.End()
+	if t.After(now) {
+		t = now
+	}
+	for i, v := range values {
+		p := chart.EPoint{X: float64(t.Unix()), Y: v}
+		if e.Final {
+			c.final[i] = append(c.final[i], p)
+			c.lines[i] = c.final[i]
+			continue
+		}
+		c.lines[i] = append(c.final[i][:len(c.final[i]):len(c.final[i])], p)
+	}
+}
+
+func (c *chartIndicator) plot(p *chart.ScatterChart, symbol int) {
+	for _, l := range c.lines {
+		if len(l) != 0 {
+			p.AddData(c.ind.String(), l, chart.PlotStyleLines, chart.Style{Symbol: symbol})
+		}
+	}
+}
+
+// indicatorStatus formats the values of all non overlay indicators.
+func indicatorStatus(set indicator.Set) string {
+	list := make([]string, 0, len(set))
+	for _, i := range set {
+		if _, ok := i.(indicator.Overlay); ok {
+			continue
+		}
+		v, ok := i.Value()
+		if !ok {
+			list = append(list, fmt.Sprintf("%s -", i))
+			continue
+		}
+		list = append(list, fmt.Sprintf("%s %.2f", i, v))
+	}
+	return strings.Join(list, "  ")
+}
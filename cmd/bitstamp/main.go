@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,7 +11,6 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,8 +19,14 @@ import (
 
 	"github.com/frizinak/bitstamp"
 	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/candle"
 	"github.com/frizinak/bitstamp/generic"
-	"github.com/google/shlex"
+	"github.com/frizinak/bitstamp/indicator"
+	"github.com/frizinak/bitstamp/pnl"
+	"github.com/frizinak/bitstamp/store"
+	"github.com/frizinak/bitstamp/strategy"
+	"github.com/frizinak/bitstamp/tax"
+	"github.com/frizinak/bitstamp/ws"
 	"github.com/vdobler/chart"
 	"github.com/vdobler/chart/txtg"
 )
@@ -35,127 +41,36 @@ const (
 	actionBalance
 	actionTransactions
 	actionCurrencies
+	actionTypes
+	actionTransferToMain
+	actionTransferFromMain
+	actionWatch
+	actionOrderBook
+	actionBuy
+	actionSell
+	actionOrders
+	actionCancel
+	actionConfig
+	actionPortfolio
+	actionPnL
+	actionTax
+	actionHistory
+	actionBacktest
+	actionRun
 )
 
-type VWAP struct {
-	Time   time.Time
-	Value  float64
-	Volume float64
-	Count  float64
-}
-
-type VWAPS []VWAP
-
-func (v VWAPS) Add(t time.Time, price, volume float64) VWAPS {
-	prev := len(v) - 1
-	if prev < 0 || t.Sub(v[prev].Time) > time.Minute*5 {
-		vw := VWAP{t, price, volume, 1}
-		return append(v, vw)
-	}
-
-	l := v[prev]
-	l.Count++
-	l.Value += price
-	l.Volume += volume
-	v[prev] = l
-
-	return v
-}
-
-func (v VWAPS) Range(from, until time.Time) VWAPS {
-	min, max := 0, len(v)
-	for i, v := range v {
-		if v.Time.Before(from) && i > min {
-			min = i
-		}
-
-		if v.Time.After(until) && i < max {
-			max = i
-			break
-		}
-	}
-
-	return v[min:max]
-}
-
-func (v VWAPS) Value() float64 {
-	var n, vol float64
-	for _, v := range v {
-		value, volume := v.Value/v.Count, v.Volume/v.Count
-		n += value * volume
-		vol += volume
-	}
-	if vol == 0 {
-		return 0
-	}
-
-	return n / vol
-}
-
-type Alarm struct {
-	GT    bool
-	Value float64
-}
-
-func (a Alarm) Check(prev, current float64) bool {
-	return ((a.GT && current >= a.Value) || (!a.GT && current < a.Value)) &&
-		((a.GT && prev < a.Value) || (!a.GT && prev >= a.Value))
-}
-
-func (a Alarm) String() string {
-	sym := "<"
-	if a.GT {
-		sym = ">"
-	}
-
-	return fmt.Sprintf("%s %.8f", sym, a.Value)
-}
-
-type Alarms []Alarm
-
-func (a Alarms) Check(prev, current float64) Alarms {
-	n := make(Alarms, 0)
-	for _, a := range a {
-		if a.Check(prev, current) {
-			n = append(n, a)
-		}
-	}
-	return n
-}
-
-type flagAlarms []string
-
-func (a *flagAlarms) String() string { return "alarms" }
-
-func (a *flagAlarms) Set(value string) error {
-	*a = append(*a, value)
-	return nil
-}
-
-var spaceRE = regexp.MustCompile(`\s+`)
-
-func (a flagAlarms) Parse() (Alarms, error) {
-	n := make([]Alarm, 0, len(a))
-	for _, a := range a {
-		a = spaceRE.ReplaceAllString(a, "")
-		if len(a) < 2 {
-			return n, errors.New("invalid alarm")
-		}
-		if a[0] != '<' && a[0] != '>' {
-			return n, errors.New("invalid alarm, missing comparison symbol (> or <)") // >_<
-		}
-
-		alarm := Alarm{GT: a[0] == '>'}
-		var err error
-		alarm.Value, err = strconv.ParseFloat(a[1:], 32)
-		if err != nil {
-			return n, err
-		}
-		n = append(n, alarm)
-	}
-
-	return n, nil
-}
+const (
+	cursorHide = "\033[?25l"
+	cursorShow = "\033[?25h"
+	clr        = "\033[2J"
+	clrLine    = "\033[K"
+	cursorHome = "\033[H"
+	cursorBOL  = "\033[0G"
+	bg         = "\033[40;1m"
+	rst        = "\033[0m"
+	clrGreen   = "\033[30;41m"
+	clrRed     = "\033[30;42m"
+)
 
 func termSize() (int, int) {
 	cmd := exec.Command("stty", "size")
@@ -179,31 +94,50 @@ func exit(err error) {
 	os.Exit(1)
 }
 
-func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph bool, truncate time.Duration) error {
-	notify := func(price float64, alarm Alarm) {}
-	if alarmCmd == "" && len(alarms) != 0 {
-		return errors.New("no alarm command set")
-	}
+// warnGap reports trades that could not be fetched or backfilled.
+func warnGap(pair generic.CurrencyPair, from, until time.Time) {
+	fmt.Fprintf(
+		os.Stderr,
+		"warning: %s trades between %s and %s are missing, they are older than the api allows to backfill\n",
+		pair,
+		from.Local().Format(dateFormat),
+		until.Local().Format(dateFormat),
+	)
+}
 
-	if alarmCmd != "" {
-		cmd, err := shlex.Split(alarmCmd)
-		if err != nil {
-			return err
-		}
-		notify = func(price float64, alarm Alarm) {
-			rcmd := make([]string, len(cmd))
-			copy(rcmd, cmd)
-			for i := range rcmd {
-				rcmd[i] = strings.ReplaceAll(rcmd[i], "%p", strconv.FormatFloat(price, 'f', -1, 64))
-				rcmd[i] = strings.ReplaceAll(rcmd[i], "%a", alarm.String())
-			}
-			cmd := exec.Command(rcmd[0], rcmd[1:]...)
-			cmd.Stdin = os.Stdin
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Run()
-		}
+type liveOptions struct {
+	pair      generic.CurrencyPair
+	alarms    Rules
+	notifiers []namedNotifier
+	nograph   bool
+	truncate  time.Duration
+
+	// indicators are drawn on the graph or shown in the status line.
+	indicators indicator.Set
+
+	// record the websocket session to this file or replay one from it.
+	record string
+	replay string
+	speed  float64
+
+	// storeDir persists trades, empty to disable.
+	storeDir string
+
+	// out receives a row per trade instead of drawing the graph.
+	out output
+}
+
+func live(o liveOptions) error {
+	pair, alarms, nograph, truncate := o.pair, o.alarms, o.nograph, o.truncate
+	if len(o.notifiers) == 0 && len(alarms) != 0 {
+		return errors.New("no notifier set (-e or -notify)")
 	}
+	dispatch, err := newDispatcher(o.notifiers, alarms)
+	if err != nil {
+		return err
+	}
+	defer dispatch.Close()
+
 	type Value struct {
 		t time.Time
 		v float64
@@ -214,40 +148,82 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 	if err != nil {
 		return err
 	}
+
+	var value, lastValue Value
+	since := time.Now().Add(-truncate)
+	clock := time.Now
+	switch {
+	case o.replay != "":
+		f, err := os.Open(o.replay)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rp, err := ws.NewReplay(f, o.speed)
+		if err != nil {
+			return err
+		}
+		client.WS = rp
+		since = time.Time{}
+		clock = func() time.Time { return value.t }
+	case o.record != "":
+		f, err := os.Create(o.record)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rec, err := ws.NewRecorder(client.WS, f)
+		if err != nil {
+			return err
+		}
+		client.WS = rec
+	}
+
+	if o.storeDir != "" && o.replay == "" {
+		st, err := store.Open(o.storeDir)
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		client.Store = st
+	}
+	client.Gap = warnGap
+
 	errs := make(chan error, 1)
 	go func() {
-		errs <- client.TradesLive(
-			api.TradesHistoryDay,
+		err := client.TradesLiveSince(
+			since,
 			pair,
 			trades,
 		)
+		if err == io.EOF && o.replay != "" {
+			return
+		}
+		errs <- err
 	}()
 
+	mkt := newMarket(alarms)
+	books := make(chan bitstamp.OrderBook, 1)
+	if mkt.needBook {
+		go func() {
+			err := client.OrderBookLive(pair, books)
+			if err == io.EOF && o.replay != "" {
+				return
+			}
+			errs <- err
+		}()
+	}
+
 	tradePoints := make([]chart.EPoint, 0)
 
-	vwap := make(VWAPS, 0)
+	candles := candle.NewBuilder(time.Minute*5, true)
+	chartIndicators := newChartIndicators(o.indicators)
+	vwap := make(candle.Series, 0)
 	vwapPointsFull := make([]chart.EPoint, 0)
 	vwapPoints := make([]chart.EPoint, 0)
 	var lastVWAP time.Time
 	vwapInterval := time.Hour * 6
 
-	var value, lastValue Value
-
-	type notification struct {
-		price float64
-		alarm Alarm
-	}
-	notifications := make(chan notification, 100)
-	go func() {
-		var last time.Time
-		for n := range notifications {
-			if time.Since(last) > time.Second*5 {
-				notify(n.price, n.alarm)
-				last = time.Now()
-			}
-		}
-	}()
-
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
@@ -255,21 +231,15 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 		errs <- nil
 	}()
 
+	if o.out != nil {
+		return liveRows(o, mkt, dispatch, trades, books, errs)
+	}
+
+	var notifyErr string
 	var pingTime time.Time
 	var pingValue float64
 	buf := bytes.NewBuffer(make([]byte, 1024*180))
 
-	const cursorHide = "\033[?25l"
-	const cursorShow = "\033[?25h"
-	const clr = "\033[2J"
-	const clrLine = "\033[K"
-	const cursorHome = "\033[H"
-	const cursorBOL = "\033[0G"
-	const bg = "\033[40;1m"
-	const rst = "\033[0m"
-	const clrGreen = "\033[30;41m"
-	const clrRed = "\033[30;42m"
-
 	os.Stdout.WriteString(cursorHide)
 	ignoreBefore := time.Now().Add(-truncate)
 	lastUpdate := time.Now()
@@ -282,35 +252,47 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 				os.Stdout.WriteString(clr)
 				os.Stdout.WriteString(cursorShow)
 				return err
+			case book := <-books:
+				mkt.setBook(book, clock())
+				for _, a := range mkt.check(alarms) {
+					dispatch.Dispatch(a, newNotification(pair, mkt.now, value.v, a))
+				}
 			case trade := <-trades:
-				if trade.Live {
-					results := alarms.Check(value.v, trade.Price)
-					for _, a := range results {
-						notifications <- notification{trade.Price, a}
-					}
-				} else if trade.Date.Before(ignoreBefore) {
+				mkt.addTrade(trade)
+				for _, a := range mkt.check(alarms) {
+					dispatch.Dispatch(a, newNotification(pair, trade.Date, trade.Price, a))
+				}
+				if !trade.Live && trade.Date.Before(ignoreBefore) {
 					continue
 				}
 
 				value = Value{trade.Date, trade.Price}
-				vwap = vwap.Add(trade.Date, trade.Price, trade.Amount)
+				for _, e := range candles.Add(trade) {
+					vwap = vwap.Add(e.Candle)
+					o.indicators.Update(e)
+					for _, c := range chartIndicators {
+						c.update(e, trade.Date)
+					}
+				}
 				tradePoints = append(tradePoints, chart.EPoint{X: float64(trade.Date.Unix()), Y: trade.Price})
 				rounded := value.t.Truncate(vwapInterval)
 				if lastVWAP != rounded {
 					lastVWAP = rounded
-					v := vwap.Range(rounded.Add(-vwapInterval), rounded).Value()
+					v := vwap.Range(rounded.Add(-vwapInterval), rounded).VWAP()
 					if v != 0 {
 						vwapPoints = append(vwapPoints, chart.EPoint{X: float64(rounded.Unix()), Y: v})
 					}
 				}
 
-				now := time.Now()
-				vwapPointsFull = append(vwapPoints, chart.EPoint{X: float64(now.Unix()), Y: vwap.Range(rounded, now).Value()})
+				now := clock()
+				vwapPointsFull = append(vwapPoints, chart.EPoint{X: float64(now.Unix()), Y: vwap.Range(rounded, now).VWAP()})
 				since := time.Since(lastUpdate)
 				refreshRate = time.Second
 				if trade.Live && since > time.Millisecond*25 {
 					break sel
 				}
+			case err := <-dispatch.Errors():
+				notifyErr = err.Error()
 			case <-time.After(refreshRate):
 				break sel
 			}
@@ -318,6 +300,7 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 
 		now := time.Now()
 		lastUpdate = now
+		mnow := clock()
 
 		termX, termY := termSize()
 
@@ -337,10 +320,14 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 		str1 := fmt.Sprintf(" %.2f ", value.v)
 		str2 := fmt.Sprintf(
 			" %.2f  %.2f ",
-			vwap.Range(now.Add(-time.Hour), now).Value(),
-			vwap.Range(now.Add(-24*time.Hour), now).Value(),
+			vwap.Range(mnow.Add(-time.Hour), mnow).VWAP(),
+			vwap.Range(mnow.Add(-24*time.Hour), mnow).VWAP(),
 		)
 
+		if status := indicatorStatus(o.indicators); status != "" {
+			str2 += status + " "
+		}
+
 		if len(str0)+len(str1)+len(str2)+2 >= termX {
 			str0, str2 = "", ""
 		}
@@ -352,7 +339,14 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 
 		out := clr
 		if termX > 30 && termY > 8 && !nograph {
-			tgr := txtg.New(termX, termY-2)
+			status := alarms.status(mnow, termX)
+			if notifyErr != "" {
+				status = append([]string{" " + notifyErr}, status...)
+			}
+			if len(status) > termY/3 {
+				status = status[:termY/3]
+			}
+			tgr := txtg.New(termX, termY-2-len(status))
 			p := chart.ScatterChart{
 				Key:    chart.Key{Hide: true, Cols: 3, Pos: "otc", Border: -1},
 				YRange: chart.Range{},
@@ -360,7 +354,7 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 					Time: true,
 					MinMode: chart.RangeMode{
 						Fixed:  true,
-						TValue: now.Add(-truncate),
+						TValue: mnow.Add(-truncate),
 					},
 				},
 			}
@@ -371,12 +365,18 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 			const symbol1 = '░'
 			if len(tradePoints) > 0 {
 				p.AddData("VWAP", vwapPointsFull, chart.PlotStyleLines, chart.Style{Symbol: symbol1})
+				for _, c := range chartIndicators {
+					c.plot(&p, symbol3)
+				}
 				p.AddData("Trades", tradePoints, chart.PlotStylePoints, chart.Style{Symbol: symbol2})
 				p.AddData("Now", tradePoints[len(tradePoints)-1:], chart.PlotStylePoints, chart.Style{Symbol: symbol4})
 			}
 
 			p.Plot(tgr)
 			out = fmt.Sprintf("%s%s\n", clr, tgr)
+			for _, l := range status {
+				out += l + clrLine + "\n"
+			}
 		}
 
 		buf.WriteString(out)
@@ -394,6 +394,124 @@ func live(pair generic.CurrencyPair, alarmCmd string, alarms Alarms, nograph boo
 	}
 }
 
+// liveRows writes trades to o.out as they come in, alarms are still
+// checked and dispatched.
+func liveRows(
+	o liveOptions,
+	mkt *market,
+	dispatch *dispatcher,
+	trades <-chan bitstamp.Trade,
+	books <-chan bitstamp.OrderBook,
+	errs <-chan error,
+) error {
+	ignoreBefore := time.Now().Add(-o.truncate)
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case book := <-books:
+			mkt.setBook(book, book.Date)
+			for _, a := range mkt.check(o.alarms) {
+				dispatch.Dispatch(a, newNotification(o.pair, mkt.now, mkt.price, a))
+			}
+		case trade := <-trades:
+			mkt.addTrade(trade)
+			for _, a := range mkt.check(o.alarms) {
+				dispatch.Dispatch(a, newNotification(o.pair, trade.Date, trade.Price, a))
+			}
+			if !trade.Live && trade.Date.Before(ignoreBefore) {
+				continue
+			}
+
+			err := o.out.Write(row{
+				{"time", trade.Date},
+				{"pair", o.pair},
+				{"id", trade.ID},
+				{"type", trade.Type},
+				{"price", trade.Price},
+				{"amount", trade.Amount},
+				{"live", trade.Live},
+			})
+			if err != nil {
+				return err
+			}
+		case err := <-dispatch.Errors():
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+func printBalance(out output, account string, r api.Balances) error {
+	currencies := make([]generic.Currency, 0, len(r.Currencies))
+	for c := range r.Currencies {
+		currencies = append(currencies, c)
+	}
+	sort.Slice(currencies, func(i, j int) bool {
+		return currencies[i] < currencies[j]
+	})
+
+	for _, v := range currencies {
+		l := r.Currency(v)
+		if l.Total == 0 {
+			continue
+		}
+		if out != nil {
+			err := out.Write(row{
+				{"account", account},
+				{"currency", v},
+				{"available", l.Available},
+				{"reserved", l.Reserved},
+				{"total", l.Total},
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		p := bitstamp.Precision(v)
+		f := fmt.Sprintf("%%s: %%10.%df / %%10.%df\n", p, p)
+		fmt.Printf(f, v, l.Available, l.Total)
+	}
+	return nil
+}
+
+// writeTransactions writes a column per currency that occurs in list so
+// all rows have the same fields.
+func writeTransactions(out output, list []bitstamp.Transaction) error {
+	seen := make(map[generic.Currency]struct{})
+	currencies := make([]generic.Currency, 0)
+	for _, n := range list {
+		for c := range n.Values {
+			if _, ok := seen[c]; !ok {
+				seen[c] = struct{}{}
+				currencies = append(currencies, c)
+			}
+		}
+	}
+	sort.Slice(currencies, func(i, j int) bool {
+		return currencies[i] < currencies[j]
+	})
+
+	for _, n := range list {
+		r := row{
+			{"id", n.ID},
+			{"datetime", n.DateTime.Value()},
+			{"type", n.Type},
+			{"order_id", n.OrderID},
+			{"pair", n.Pair},
+			{"rate", n.Rate},
+			{"fee", n.Fee.Value()},
+		}
+		for _, c := range currencies {
+			r = append(r, field{c.String(), n.Values[c]})
+		}
+		if err := out.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func main() {
 	configDir, _ := os.UserConfigDir()
 	if configDir != "" {
@@ -402,14 +520,76 @@ func main() {
 
 	truncate := time.Hour * 24
 	alarmsf := make(flagAlarms, 0)
+	var rulesFile string
+	indicatorsf := make(flagIndicators, 0)
 	var alarmCmd string
+	notifiersf := make(flagNotifiers, 0)
 	var baseCurrency, counterCurrency string
 	var nograph bool
+	var depthChart bool
+	var rewind bool
+	var liveRun bool
+	var limits strategy.Limits
+	source := "ohlc"
+	interval := time.Hour
+	fee, slippage, startBalance := 0.5, 0.05, 1000.0
+	var since time.Duration
+	var limit int
+	var pairOnly bool
+	var types string
+	var sub uint64
+	method := pnl.FIFO.String()
+	taxFormat := string(tax.Generic)
+	var profileName string
+	var readOnly bool
+	var allAccounts bool
+	var record, replay string
+	speed := 1.0
+	var nostore bool
+	var inCounter, dryRun, yes bool
+	format := string(formatText)
+	var daily, ioc, fok bool
 	flag.StringVar(&configDir, "c", configDir, "config directory")
 	flag.DurationVar(&truncate, "h", truncate, "[live] truncate graph after this duration into the past")
 	flag.BoolVar(&nograph, "g", false, "[live] hide graph")
-	flag.Var(&alarmsf, "a", "[live] set alarms (e.g. '>10000', '<8000')")
-	flag.StringVar(&alarmCmd, "e", "", "[live] command to execute when an alarm is triggered, %p will be replaced with the current market price and %a with the alarm condition")
+	flag.BoolVar(&depthChart, "chart", false, "[orderbook, history, backtest] show a depth chart instead of the price levels, chart the balance of the first currency or the equity curve")
+	flag.BoolVar(&liveRun, "live", false, "[run] place real orders instead of simulating them on a paper account")
+	flag.Float64Var(&limits.MaxOrderSize, "max-order", 0, "[backtest, run] maximum order size in the -bc currency, 0 for no limit")
+	flag.Float64Var(&limits.MaxPosition, "max-position", 0, "[backtest, run] maximum -bc balance buy orders may lead to, 0 for no limit")
+	flag.IntVar(&limits.MaxOrdersPerMinute, "max-rate", 0, "[backtest, run] maximum orders per minute, 0 for no limit")
+	flag.Float64Var(&limits.MaxDailyLoss, "max-loss", 0, "[backtest, run] cancel the orders of the strategy and stop once the account lost this much -cc today, 0 for no limit")
+	flag.StringVar(&source, "source", source, "[backtest] market data: ohlc, store or a recording, see below")
+	flag.DurationVar(&interval, "interval", interval, "[backtest, run] candle interval")
+	flag.Float64Var(&fee, "fee", fee, "[backtest, run] trading fee percentage of simulated orders")
+	flag.Float64Var(&slippage, "slippage", slippage, "[backtest] percentage market orders fill worse than the last price")
+	flag.Float64Var(&startBalance, "balance", startBalance, "[backtest, run] starting balance in the -cc currency of simulated orders")
+	flag.BoolVar(&rewind, "rewind", false, "[history] replay backwards from the current balance instead of forwards from zero")
+	flag.Var(&alarmsf, "a", "[live] add an alarm rule (e.g. '>10000', 'change(1h) < -5 cooldown 30m'), see below")
+	flag.StringVar(&rulesFile, "rules", "", "[live] read alarm rules from this file, one per line (default <config>/alarms if it exists)")
+	flag.StringVar(&alarmCmd, "e", "", "[live] command to execute when an alarm is triggered, %p will be replaced with the current market price, %a with the alarm rule and %n with its name, short for -notify 'e=exec:<command>'")
+	flag.Var(&notifiersf, "notify", "[live] add a notifier for alarms, see below")
+	flag.Var(&indicatorsf, "i", fmt.Sprintf("[live] show an indicator calculated over 5 minute candles (e.g. 'ema(50)', 'bb(20,2)', 'rsi(14)'), one of: %s", strings.Join(indicator.Names(), ", ")))
+	flag.StringVar(&record, "record", "", "[live] record the websocket session to this file")
+	flag.StringVar(&replay, "replay", "", "[live] replay a websocket session recorded with -record")
+	flag.Float64Var(&speed, "speed", speed, "[live] replay speed, 1 is real time, 0 is instant")
+	flag.BoolVar(&nostore, "nostore", false, "[live, watch] do not persist trades in <config>/trades")
+	flag.BoolVar(&inCounter, "counter", false, "[buy, sell] amount is in the counter currency")
+	flag.BoolVar(&dryRun, "dry-run", false, "[buy, sell] show the order and its estimated cost without placing it")
+	flag.BoolVar(&yes, "y", false, "[buy, sell] do not ask for confirmation")
+	flag.BoolVar(&daily, "daily", false, "[buy, sell] limit order is canceled at the end of the day")
+	flag.BoolVar(&ioc, "ioc", false, "[buy, sell] limit order is immediate-or-cancel")
+	flag.BoolVar(&fok, "fok", false, "[buy, sell] limit order is fill-or-kill")
+	flag.StringVar(&method, "method", method, "[pnl, tax] cost basis method: fifo, lifo or avg")
+	flag.StringVar(&taxFormat, "tax-format", taxFormat, "[tax] csv format: generic or 8949 (US IRS Form 8949)")
+	flag.DurationVar(&since, "since", 0, "[transactions, history, backtest] only list transactions or use market data newer than this duration")
+	flag.IntVar(&limit, "n", 0, "[transactions] only list the n most recent transactions")
+	flag.BoolVar(&pairOnly, "p", false, "[transactions] only list transactions for the -bc/-cc pair")
+	flag.StringVar(&types, "type", "", "[transactions] comma separated list of transaction types to list (see list-types), #<code> for any numeric code")
+	flag.StringVar(&format, "o", format, "output format of balance, transactions, current, orders, cancel, buy, sell, list-* and live: text, json, ndjson, csv or tsv")
+	flag.Uint64Var(&sub, "sub", 0, "act as the sub account with this id (see <config>/subaccounts or config add)")
+	flag.StringVar(&profileName, "profile", "", "use this profile (see config list)")
+	flag.BoolVar(&readOnly, "ro", false, "[config add] profile can not place or cancel orders nor transfer")
+	flag.BoolVar(&allAccounts, "all", false, "[balance, portfolio] aggregate the balance of the main and all sub accounts")
 	flag.StringVar(&baseCurrency, "bc", bitstamp.BTC.String(), "base currency")
 	flag.StringVar(&counterCurrency, "cc", bitstamp.EUR.String(), "counter currency")
 
@@ -420,70 +600,271 @@ func main() {
 		fmt.Fprintln(out, "Commands:")
 		fmt.Fprintln(out, "  live | <empty>:   show market data")
 		fmt.Fprintln(out, "  current | c:      show current price")
+		fmt.Fprintln(out, "  watch | w [pair ...]: live table of multiple pairs, defaults to all -cc pairs")
+		fmt.Fprintln(out, "  orderbook | ob:   show the live order book")
 		fmt.Fprintln(out, "  balance | b:      get account balance")
+		fmt.Fprintln(out, "  portfolio:        value all balances in the -cc currency")
+		fmt.Fprintln(out, "  pnl [disposals]:  cost basis and profit in the -cc currency, or every disposal")
+		fmt.Fprintln(out, "  tax [year]:       csv of every disposed lot in year (default last year) in the -cc currency")
 		fmt.Fprintln(out, "  transactions | t: list account transactions")
+		fmt.Fprintln(out, "  history [currency ...]: balance after every transaction, checked against the live balance")
+		fmt.Fprintln(out, "  buy | sell:       place an order, see below")
+		fmt.Fprintln(out, "  backtest <strategy> [trades|equity]: evaluate a strategy on historical data, see below")
+		fmt.Fprintln(out, "  run <strategy>:   run a strategy on live data, see below")
+		fmt.Fprintln(out, "  orders [all]:     list open orders")
+		fmt.Fprintln(out, "  cancel:           cancel open orders")
+		fmt.Fprintln(out, "  transfer-to-main <sub account id> <amount> <currency>")
+		fmt.Fprintln(out, "  transfer-from-main <sub account id> <amount> <currency>")
+		fmt.Fprintln(out, "  config:           manage profiles, see below")
 		fmt.Fprintln(out, "  list-currencies:  list known currency pairs")
+		fmt.Fprintln(out, "  list-types:       list known transaction types, types without a name (e.g. conversions")
+		fmt.Fprintln(out, "                    and fee rebates) are shown and filtered by numeric code: -type '#<code>'")
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, configHelp)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, profileHelp)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, tradeHelp)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, backtestHelp)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, runHelp)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, ruleHelp)
+		fmt.Fprintln(out)
+		out.WriteString(notifyHelp + "\n")
 	}
 	flag.Parse()
 
+	cfg, err := readConfig(configDir)
+	exit(err)
+	exit(cfg.apply(flag.CommandLine))
+
 	pair := generic.CurrencyPair{generic.Currency(baseCurrency), generic.Currency(counterCurrency)}
 
 	cmd := flag.Arg(0)
 	var a action
-	var authed bool
+	var authed, trading bool
 	switch cmd {
 	case "", "live":
 		a = actionLive
 	case "b", "balance":
 		a = actionBalance
 		authed = true
+	case "portfolio":
+		a = actionPortfolio
+		authed = true
+	case "pnl":
+		a = actionPnL
+		authed = true
+	case "tax":
+		a = actionTax
+		authed = true
+	case "history":
+		a = actionHistory
+		authed = true
+	case "backtest":
+		a = actionBacktest
+	case "run":
+		a = actionRun
+		authed, trading = liveRun, liveRun
 	case "t", "transactions":
 		a = actionTransactions
 		authed = true
+	case "transfer-to-main":
+		a = actionTransferToMain
+		authed = true
+		trading = true
+	case "transfer-from-main":
+		a = actionTransferFromMain
+		authed = true
+		trading = true
+	case "buy":
+		a = actionBuy
+		authed = true
+		trading = true
+	case "sell":
+		a = actionSell
+		authed = true
+		trading = true
+	case "orders":
+		a = actionOrders
+		authed = true
+	case "cancel":
+		a = actionCancel
+		authed = true
+		trading = true
+	case "config":
+		a = actionConfig
 	case "list-currencies":
 		a = actionCurrencies
+	case "list-types":
+		a = actionTypes
+	case "w", "watch":
+		a = actionWatch
+	case "ob", "orderbook":
+		a = actionOrderBook
 	case "c", "current":
 		a = actionCurrent
 	}
 
-	var apiKey, apiSecret string
-	if authed {
+	outFormat, err := parseFormat(format)
+	exit(err)
+	var out output
+	rows := true
+	switch a {
+	case actionWatch, actionOrderBook, actionTransferToMain, actionTransferFromMain, actionTax:
+		rows = false
+	case actionConfig:
+		rows = flag.Arg(1) == "list" || flag.Arg(1) == "ls"
+	case actionHistory:
+		rows = !depthChart
+	case actionBacktest:
+		rows = !depthChart || flag.Arg(2) != "equity"
+	}
+	if outFormat != formatText && rows {
+		out = outFormat.writer(os.Stdout)
+	}
+
+	var creds credentials
+	var profs *profiles
+	if authed || a == actionConfig {
 		if configDir == "" {
 			exit(errors.New("please set a config directory"))
 		}
-
-		authFile := filepath.Join(configDir, "auth")
-		f, err := os.Open(authFile)
+		profs, err = readProfiles(configDir)
 		exit(err)
-		authBin, err := io.ReadAll(f)
+	}
+	if authed {
+		var prof profile
+		creds, prof, err = profs.account(configDir, profileName, sub)
 		exit(err)
-
-		lines := strings.Split(strings.TrimSpace(string(authBin)), "\n")
-		if len(lines) < 2 {
-			exit(errors.New("auth file invalid"))
+		if trading && prof.ReadOnly {
+			exit(fmt.Errorf("profile %s is read-only", prof.Name))
 		}
-		apiKey, apiSecret = lines[0], lines[1]
 	}
 
-	client, err := bitstamp.NewDefaults(apiKey, apiSecret)
+	client, err := bitstamp.NewDefaults(creds.key, creds.secret)
 	exit(err)
 
 	switch a {
 	case actionBalance:
-		r, err := client.API.Balance()
+		r, err := client.API.Balances()
 		exit(err)
-		for _, v := range bitstamp.AllCurrencies() {
-			l := r.ForCurrency(v)
-			if len(l) == 0 || l["balance"] == 0 {
-				continue
+		if !allAccounts {
+			exit(printBalance(out, "main", r))
+			break
+		}
+
+		if sub != 0 {
+			exit(errors.New("-all and -sub are mutually exclusive"))
+		}
+
+		header := func(str string) {
+			if out == nil {
+				fmt.Println(str)
 			}
-			p := bitstamp.Precision(v)
-			f := fmt.Sprintf("%%s: %%10.%df / %%10.%df\n", p, p)
-			fmt.Printf(f, v, l["available"], l["balance"])
 		}
+		subs, err := profs.subAccounts(configDir)
+		exit(err)
+		header("main")
+		exit(printBalance(out, "main", r))
+		for _, s := range subs {
+			sc, err := bitstamp.NewDefaults(s.key, s.secret)
+			exit(err)
+			sr, err := sc.API.Balances()
+			exit(err)
+			header("\n" + s.String())
+			exit(printBalance(out, s.String(), sr))
+			r = r.Add(sr)
+		}
+		header("\ntotal")
+		exit(printBalance(out, "total", r))
+	case actionPortfolio:
+		r, err := client.API.Balances()
+		exit(err)
+		if allAccounts {
+			if sub != 0 {
+				exit(errors.New("-all and -sub are mutually exclusive"))
+			}
+			subs, err := profs.subAccounts(configDir)
+			exit(err)
+			for _, s := range subs {
+				sc, err := bitstamp.NewDefaults(s.key, s.secret)
+				exit(err)
+				sr, err := sc.API.Balances()
+				exit(err)
+				r = r.Add(sr)
+			}
+		}
+		amounts := make(map[generic.Currency]float64, len(r.Currencies))
+		for c, l := range r.Currencies {
+			amounts[c] = l.Total
+		}
+		p, err := client.Portfolio(amounts, pair.Counter)
+		exit(err)
+		exit(printPortfolio(out, p))
+	case actionPnL:
+		m, err := pnl.ParseMethod(method)
+		exit(err)
+		e, err := replayPnL(client, pair.Counter, m)
+		exit(err)
+		if flag.Arg(1) == "disposals" {
+			exit(printDisposals(out, e))
+			break
+		}
+		exit(printPnL(client, out, e))
+	case actionTax:
+		year := time.Now().Year() - 1
+		if flag.Arg(1) != "" {
+			year, err = strconv.Atoi(flag.Arg(1))
+			exit(err)
+		}
+		f, err := tax.ParseFormat(taxFormat)
+		exit(err)
+		m, err := pnl.ParseMethod(method)
+		exit(err)
+		e, err := replayPnL(client, pair.Counter, m)
+		exit(err)
+		exit(f.Write(os.Stdout, time.Local, tax.Records(e, year, time.Local)))
+	case actionHistory:
+		o := historyOptions{since: since, rewind: rewind, chart: depthChart}
+		for _, c := range flag.Args()[1:] {
+			o.currencies = append(o.currencies, generic.Currency(strings.ToLower(c)))
+		}
+		exit(printBalanceHistory(client, out, o))
 	case actionTransactions:
-		list, err := client.Transactions()
+		q := api.TransactionsQuery{Limit: limit}
+		if since != 0 {
+			q.Since = time.Now().Add(-since)
+		}
+		if pairOnly {
+			q.Pair = pair
+		}
+		for _, t := range strings.Split(types, ",") {
+			if strings.TrimSpace(t) == "" {
+				continue
+			}
+			typ, err := api.ParseTransactionType(t)
+			exit(err)
+			q.Types = append(q.Types, typ)
+		}
+		if q.Limit != 0 || !q.Since.IsZero() {
+			q.Desc = true
+		}
+
+		list, err := client.Transactions(q)
 		exit(err)
+		if q.Desc {
+			for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+				list[i], list[j] = list[j], list[i]
+			}
+		}
+		if out != nil {
+			exit(writeTransactions(out, list))
+			break
+		}
+
 		type item struct {
 			currency generic.Currency
 			value    float64
@@ -502,32 +883,222 @@ func main() {
 				return items[i].currency < items[j].currency
 			})
 
-			strs := make([]string, len(items), len(items)+1)
+			strs := make([]string, len(items), len(items)+2)
 			for i, it := range items {
 				p := bitstamp.Precision(it.currency)
 				f := fmt.Sprintf("%%s: %%.%df", p)
 				strs[i] = fmt.Sprintf(f, it.currency, it.value)
 			}
+			if n.Rate != 0 {
+				f := fmt.Sprintf("%%s/%%s: %%.%df", bitstamp.Precision(n.Pair.Counter))
+				strs = append(strs, fmt.Sprintf(f, n.Pair.Base, n.Pair.Counter, n.Rate))
+			}
 			strs = append(strs, fmt.Sprintf("FEE: %.2f", n.Fee))
 
 			fmt.Printf(
-				"%s %10s %s\n",
+				"%s %22s %s\n",
 				n.DateTime.Value().Local().Format(dateFormat),
 				n.Type.String(),
 				strings.Join(strs, " | "),
 			)
 		}
+	case actionTransferToMain, actionTransferFromMain:
+		if flag.NArg() != 4 {
+			exit(fmt.Errorf("usage: %s <sub account id> <amount> <currency>", cmd))
+		}
+		id, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		exit(err)
+		amount, err := strconv.ParseFloat(flag.Arg(2), 64)
+		exit(err)
+		currency := generic.Currency(strings.ToLower(flag.Arg(3)))
+
+		if a == actionTransferFromMain {
+			exit(client.API.TransferFromMain(currency, amount, id))
+			break
+		}
+
+		// Prefer the credentials of the sub account itself.
+		transferAPI := client.API
+		if s, _, err := profs.account(configDir, "", id); err == nil {
+			sc, err := bitstamp.NewDefaults(s.key, s.secret)
+			exit(err)
+			transferAPI = sc.API
+		}
+		exit(transferAPI.TransferToMain(currency, amount, id))
+	case actionBuy, actionSell:
+		side := api.Buy
+		if a == actionSell {
+			side = api.Sell
+		}
+		o, err := parseTradeArgs(tradeOptions{
+			side:    side,
+			pair:    pair,
+			counter: inCounter,
+			daily:   daily,
+			ioc:     ioc,
+			fok:     fok,
+			dryRun:  dryRun,
+			yes:     yes,
+		}, flag.Args()[1:])
+		exit(err)
+		exit(trade(client, out, o))
+	case actionOrders:
+		exit(printOrders(client, out, pair, flag.Arg(1) == "all"))
+	case actionCancel:
+		exit(cancelOrders(client, out, flag.Args()[1:]))
+	case actionConfig:
+		if flag.Arg(1) == "show" {
+			notifiers := notifiersf
+			if alarmCmd != "" {
+				notifiers = append(flagNotifiers{"e=exec:" + alarmCmd}, notifiers...)
+			}
+			c := cfg.effective(flag.CommandLine, alarmsf, notifiers, indicatorsf)
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "    ")
+			exit(enc.Encode(c))
+			break
+		}
+		exit(configProfiles(profs, out, flag.Args()[1:], sub, readOnly))
 	case actionCurrencies:
 		for _, p := range bitstamp.AllCurrencies() {
+			if out != nil {
+				exit(out.Write(row{{"currency", p}}))
+				continue
+			}
 			fmt.Println(p)
 		}
+	case actionTypes:
+		for _, t := range api.TransactionTypes() {
+			if out != nil {
+				exit(out.Write(row{{"id", int(t)}, {"name", t.String()}}))
+				continue
+			}
+			fmt.Printf("%3d %s\n", t, t)
+		}
 	case actionLive:
 		alarms, err := alarmsf.Parse()
 		exit(err)
-		exit(live(pair, alarmCmd, alarms, nograph, truncate))
+		if rulesFile == "" && configDir != "" {
+			if _, err := os.Stat(filepath.Join(configDir, "alarms")); err == nil {
+				rulesFile = filepath.Join(configDir, "alarms")
+			}
+		}
+		if rulesFile != "" {
+			rules, err := readRules(rulesFile)
+			exit(err)
+			alarms = append(alarms, rules...)
+		}
+		if alarmCmd != "" {
+			notifiersf = append(flagNotifiers{"e=exec:" + alarmCmd}, notifiersf...)
+		}
+		notifiers, err := notifiersf.Parse()
+		exit(err)
+		indicators, err := indicatorsf.Parse()
+		exit(err)
+		var storeDir string
+		if configDir != "" && !nostore {
+			storeDir = filepath.Join(configDir, "trades")
+		}
+		exit(live(liveOptions{
+			pair:       pair,
+			notifiers:  notifiers,
+			alarms:     alarms,
+			nograph:    nograph,
+			truncate:   truncate,
+			indicators: indicators,
+			record:     record,
+			replay:     replay,
+			speed:      speed,
+			storeDir:   storeDir,
+			out:        out,
+		}))
+	case actionBacktest:
+		if flag.Arg(1) == "" {
+			exit(errors.New("please specify a strategy, see -help"))
+		}
+		r, err := runBacktest(backtestOptions{
+			pair:      pair,
+			spec:      flag.Arg(1),
+			source:    source,
+			configDir: configDir,
+			since:     since,
+			interval:  interval,
+			fee:       fee,
+			slippage:  slippage,
+			balance:   startBalance,
+			limits:    limits,
+		})
+		exit(err)
+		exit(printBacktest(out, r, flag.Arg(2), depthChart))
+	case actionRun:
+		if flag.Arg(1) == "" {
+			exit(errors.New("please specify a strategy, see -help"))
+		}
+		exit(runStrategy(client, out, runOptions{
+			pair:     pair,
+			spec:     flag.Arg(1),
+			live:     liveRun,
+			interval: interval,
+			fee:      fee,
+			balance:  startBalance,
+			limits:   limits,
+		}))
+	case actionOrderBook:
+		exit(orderBook(orderBookOptions{pair: pair, chart: depthChart}))
+	case actionWatch:
+		var pairs []generic.CurrencyPair
+		var listed bool
+		if args := flag.Args()[1:]; len(args) <= 1 {
+			name := "default"
+			if len(args) == 1 {
+				name = args[0]
+			}
+			pairs, listed, err = cfg.watchlist(name)
+			exit(err)
+		}
+		if !listed {
+			for _, arg := range flag.Args()[1:] {
+				p, err := bitstamp.ParsePair(arg)
+				exit(err)
+				pairs = append(pairs, p)
+			}
+		}
+		if len(pairs) == 0 {
+			for _, p := range bitstamp.AllPairs() {
+				if p.Counter == pair.Counter {
+					pairs = append(pairs, p)
+				}
+			}
+		}
+
+		args := []string{"-c", configDir, "-o", string(formatText)}
+		var storeDir string
+		if configDir != "" && !nostore {
+			storeDir = filepath.Join(configDir, "trades")
+		}
+		if nostore {
+			args = append(args, "-nostore")
+		}
+		exit(watch(watchOptions{pairs: pairs, storeDir: storeDir, args: args}))
 	case actionCurrent:
 		r, err := client.API.Ticker(pair, api.TickerHourly)
 		exit(err)
+		if out != nil {
+			exit(out.Write(row{
+				{"pair", pair},
+				{"time", r.Time.Value()},
+				{"last", r.Last.Value()},
+				{"low", r.Low.Value()},
+				{"high", r.High.Value()},
+				{"vwap", r.VWAP.Value()},
+				{"volume", r.Volume.Value()},
+				{"open", r.Open.Value()},
+				{"bid", r.Bid.Value()},
+				{"ask", r.Ask.Value()},
+			}))
+			break
+		}
 		f := fmt.Sprintf("%%.%df", bitstamp.Precision(pair.Counter))
 		fmt.Printf(
 			"%s\nLast:\t"+f+"\nLow:\t"+f+"\nHigh:\t"+f+"\nVWAP:\t"+f+"\n",
@@ -538,4 +1109,8 @@ func main() {
 			r.VWAP,
 		)
 	}
+
+	if out != nil {
+		exit(out.Close())
+	}
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/google/shlex"
+)
+
+// defaultThrottle is the minimum time between two notifications of the
+// same alarm unless the rule specifies a throttle.
+const defaultThrottle = time.Second * 5
+
+const notifyHelp = `Notifiers (-notify [name=]<spec>, name defaults to the backend):
+  exec:<command>       run command, %p is replaced with the price, %a with
+                       the alarm rule and %n with its name
+  webhook:<url>        POST a JSON payload to url (http(s)://... works too)
+  desktop              desktop notification over D-Bus (requires gdbus)
+  stdout               print a JSON line to stdout
+  smtp://[user:pass@]host:port?from=<address>&to=<address>[,<address>]
+                       send an email
+  Rules use all notifiers unless they specify 'notify <name>[,<name>]'.`
+
+type Notification struct {
+	Time  time.Time `json:"time"`
+	Pair  string    `json:"pair"`
+	Price float64   `json:"price"`
+	Alarm string    `json:"alarm"`
+	Rule  string    `json:"rule"`
+}
+
+func newNotification(pair generic.CurrencyPair, t time.Time, price float64, r *Rule) Notification {
+	return Notification{Time: t, Pair: pair.String(), Price: price, Alarm: r.Name, Rule: r.String()}
+}
+
+func (n Notification) String() string {
+	return fmt.Sprintf("%s %s: %s", n.Pair, strconv.FormatFloat(n.Price, 'f', -1, 64), n.Alarm)
+}
+
+type Notifier interface {
+	Notify(Notification) error
+}
+
+type execNotifier []string
+
+func (e execNotifier) Notify(n Notification) error {
+	cmd := make([]string, len(e))
+	for i := range e {
+		cmd[i] = strings.ReplaceAll(e[i], "%p", strconv.FormatFloat(n.Price, 'f', -1, 64))
+		cmd[i] = strings.ReplaceAll(cmd[i], "%a", n.Rule)
+		cmd[i] = strings.ReplaceAll(cmd[i], "%n", n.Alarm)
+	}
+	c := exec.Command(cmd[0], cmd[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (w webhookNotifier) Notify(n Notification) error {
+	d, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	res, err := w.client.Post(w.url, "application/json", bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return nil
+}
+
+type desktopNotifier struct{}
+
+func (desktopNotifier) Notify(n Notification) error {
+	out, err := exec.Command(
+		"gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"bitstamp", "0", "", n.Alarm, n.String(), "[]", "{}", "-1",
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+type stdoutNotifier struct{}
+
+func (stdoutNotifier) Notify(n Notification) error {
+	return json.NewEncoder(os.Stdout).Encode(n)
+}
+
+type smtpNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+func (s smtpNotifier) Notify(n Notification) error {
+	msg := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		s.from,
+		strings.Join(s.to, ", "),
+		n.Alarm,
+		n.Time.Format(time.RFC1123Z),
+		n.String(),
+	)
+	return smtp.SendMail(s.addr, s.auth, s.from, s.to, []byte(msg))
+}
+
+// parseNotifier parses a [name=]<spec> notifier, see notifyHelp.
+func parseNotifier(str string) (string, Notifier, error) {
+	var name string
+	if ix := strings.IndexByte(str, '='); ix != -1 && !strings.ContainsAny(str[:ix], ":/ ") {
+		name, str = str[:ix], str[ix+1:]
+	}
+	kind, arg := str, ""
+	if ix := strings.IndexByte(str, ':'); ix != -1 {
+		kind, arg = str[:ix], str[ix+1:]
+	}
+	if name == "" {
+		name = kind
+	}
+	name = strings.ToLower(name)
+
+	switch kind {
+	case "exec":
+		cmd, err := shlex.Split(arg)
+		if err != nil {
+			return name, nil, err
+		}
+		if len(cmd) == 0 {
+			return name, nil, fmt.Errorf("notifier %s: no command", name)
+		}
+		return name, execNotifier(cmd), nil
+	case "http", "https":
+		if name == kind {
+			name = "webhook"
+		}
+		arg = str
+		fallthrough
+	case "webhook":
+		u, err := url.Parse(arg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return name, nil, fmt.Errorf("notifier %s: invalid url '%s'", name, arg)
+		}
+		return name, webhookNotifier{u.String(), &http.Client{Timeout: time.Second * 10}}, nil
+	case "desktop":
+		return name, desktopNotifier{}, nil
+	case "stdout":
+		return name, stdoutNotifier{}, nil
+	case "smtp":
+		u, err := url.Parse(str)
+		if err != nil {
+			return name, nil, fmt.Errorf("notifier %s: %w", name, err)
+		}
+		s := smtpNotifier{addr: u.Host, from: u.Query().Get("from")}
+		for _, to := range strings.Split(u.Query().Get("to"), ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				s.to = append(s.to, to)
+			}
+		}
+		if s.from == "" || len(s.to) == 0 {
+			return name, nil, fmt.Errorf("notifier %s: from and to are required", name)
+		}
+		host, _, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return name, nil, fmt.Errorf("notifier %s: %w", name, err)
+		}
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			s.auth = smtp.PlainAuth("", u.User.Username(), pass, host)
+		}
+		return name, s, nil
+	}
+
+	return name, nil, fmt.Errorf("unknown notifier '%s'", kind)
+}
+
+type flagNotifiers []string
+
+func (f *flagNotifiers) String() string { return "notifiers" }
+
+func (f *flagNotifiers) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+type namedNotifier struct {
+	name string
+	Notifier
+}
+
+func (f flagNotifiers) Parse() ([]namedNotifier, error) {
+	list := make([]namedNotifier, 0, len(f))
+	seen := make(map[string]struct{}, len(f))
+	for _, spec := range f {
+		name, n, err := parseNotifier(spec)
+		if err != nil {
+			return list, err
+		}
+		if _, ok := seen[name]; ok {
+			return list, fmt.Errorf("duplicate notifier name '%s'", name)
+		}
+		seen[name] = struct{}{}
+		list = append(list, namedNotifier{name, n})
+	}
+	return list, nil
+}
+
+type notifyError struct {
+	time     time.Time
+	alarm    string
+	notifier string
+	err      error
+}
+
+func (n notifyError) Error() string {
+	return fmt.Sprintf("%s notify %s via %s failed: %s", n.time.Format("15:04:05"), n.alarm, n.notifier, n.err)
+}
+
+// dispatcher delivers notifications asynchronously with a queue per
+// notifier, throttled per alarm.
+type dispatcher struct {
+	notifiers []namedNotifier
+	queues    map[string]chan Notification
+	errs      chan notifyError
+
+	l    sync.Mutex
+	last map[*Rule]time.Time
+}
+
+func newDispatcher(notifiers []namedNotifier, rules Rules) (*dispatcher, error) {
+	d := &dispatcher{
+		notifiers: notifiers,
+		queues:    make(map[string]chan Notification, len(notifiers)),
+		errs:      make(chan notifyError, 10),
+		last:      make(map[*Rule]time.Time),
+	}
+
+	for _, r := range rules {
+		for _, n := range r.Notify {
+			if _, ok := d.notifier(n); !ok {
+				return nil, fmt.Errorf("alarm '%s' uses unknown notifier '%s'", r.Name, n)
+			}
+		}
+	}
+
+	for _, n := range notifiers {
+		q := make(chan Notification, 100)
+		d.queues[n.name] = q
+		go func(n namedNotifier) {
+			for msg := range q {
+				if err := n.Notify(msg); err != nil {
+					d.error(notifyError{time.Now(), msg.Alarm, n.name, err})
+				}
+			}
+		}(n)
+	}
+
+	return d, nil
+}
+
+func (d *dispatcher) notifier(name string) (namedNotifier, bool) {
+	for _, n := range d.notifiers {
+		if n.name == name {
+			return n, true
+		}
+	}
+	return namedNotifier{}, false
+}
+
+func (d *dispatcher) error(err notifyError) {
+	select {
+	case d.errs <- err:
+	default:
+	}
+}
+
+// Errors returns delivery errors, errors are dropped if not received.
+func (d *dispatcher) Errors() <-chan notifyError { return d.errs }
+
+// Dispatch queues a notification for each of the rule's notifiers, unless
+// the rule notified within its throttle duration.
+func (d *dispatcher) Dispatch(r *Rule, n Notification) {
+	throttle := r.Throttle
+	if throttle == 0 {
+		throttle = defaultThrottle
+	}
+
+	now := time.Now()
+	d.l.Lock()
+	if last, ok := d.last[r]; ok && now.Sub(last) < throttle {
+		d.l.Unlock()
+		return
+	}
+	d.last[r] = now
+	d.l.Unlock()
+
+	names := r.Notify
+	if len(names) == 0 {
+		for _, n := range d.notifiers {
+			names = append(names, n.name)
+		}
+	}
+
+	for _, name := range names {
+		select {
+		case d.queues[name] <- n:
+		default:
+			d.error(notifyError{now, n.Alarm, name, fmt.Errorf("queue full")})
+		}
+	}
+}
+
+func (d *dispatcher) Close() {
+	for _, q := range d.queues {
+		close(q)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/vdobler/chart"
+	"github.com/vdobler/chart/txtg"
+)
+
+const (
+	fgRed   = "\033[31m"
+	fgGreen = "\033[32m"
+)
+
+type orderBookOptions struct {
+	pair  generic.CurrencyPair
+	chart bool
+}
+
+// bookStats summarizes the top levels of both sides, imbalance is the
+// difference between bid and ask depth relative to the total depth.
+type bookStats struct {
+	mid, spread float64
+	ok          bool
+
+	bidDepth, askDepth float64
+	imbalance          float64
+
+	bids, asks       []bitstamp.OrderBookLevel
+	cumBids, cumAsks []float64
+}
+
+func newBookStats(b bitstamp.OrderBook, levels int) bookStats {
+	s := bookStats{bids: b.Bids, asks: b.Asks}
+	if len(s.bids) > levels {
+		s.bids = s.bids[:levels]
+	}
+	if len(s.asks) > levels {
+		s.asks = s.asks[:levels]
+	}
+
+	cum := func(list []bitstamp.OrderBookLevel) ([]float64, float64) {
+		n := make([]float64, len(list))
+		var total float64
+		for i, l := range list {
+			total += l.Amount
+			n[i] = total
+		}
+		return n, total
+	}
+	s.cumBids, s.bidDepth = cum(s.bids)
+	s.cumAsks, s.askDepth = cum(s.asks)
+	if s.bidDepth+s.askDepth != 0 {
+		s.imbalance = (s.bidDepth - s.askDepth) / (s.bidDepth + s.askDepth)
+	}
+
+	s.mid, s.ok = b.Mid()
+	s.spread, _ = b.Spread()
+	return s
+}
+
+func (s bookStats) summary(pair generic.CurrencyPair) string {
+	if !s.ok {
+		return fmt.Sprintf(" %s/%s waiting for both sides of the book", pair.Base, pair.Counter)
+	}
+	p := bitstamp.Precision(pair.Counter)
+	return fmt.Sprintf(
+		" %s/%s  mid %.*f  spread %.*f (%.3f%%)  imbalance %+.2f",
+		pair.Base,
+		pair.Counter,
+		p, s.mid,
+		p, s.spread,
+		s.spread/s.mid*100,
+		s.imbalance,
+	)
+}
+
+func (s bookStats) table(pair generic.CurrencyPair, width, height int) string {
+	rows := (height - 2) / 2
+	if rows < 1 {
+		rows = 1
+	}
+	asks, bids := s.asks, s.bids
+	if len(asks) > rows {
+		asks = asks[:rows]
+	}
+	if len(bids) > rows {
+		bids = bids[:rows]
+	}
+
+	var max float64
+	if len(asks) != 0 {
+		max = s.cumAsks[len(asks)-1]
+	}
+	if len(bids) != 0 {
+		max = math.Max(max, s.cumBids[len(bids)-1])
+	}
+	pp, pa := bitstamp.Precision(pair.Counter), bitstamp.Precision(pair.Base)
+	const textWidth = 16*3 + 3
+	barWidth := width - textWidth
+	line := func(color string, l bitstamp.OrderBookLevel, cum float64) string {
+		bar := ""
+		if barWidth > 0 && max != 0 {
+			bar = strings.Repeat("█", int(math.Round(cum/max*float64(barWidth))))
+		}
+		return fmt.Sprintf(
+			"%s%16.*f %16.*f %16.*f %s%s%s\n",
+			color,
+			pp, l.Price,
+			pa, l.Amount,
+			pa, cum,
+			bar,
+			rst,
+			clrLine,
+		)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for i := rows - 1; i >= 0; i-- {
+		if i >= len(asks) {
+			buf.WriteString(clrLine + "\n")
+			continue
+		}
+		buf.WriteString(line(fgRed, asks[i], s.cumAsks[i]))
+	}
+	fmt.Fprintf(buf, "%s%s%s%s\n", bg, s.summary(pair), rst, clrLine)
+	for i := range bids {
+		buf.WriteString(line(fgGreen, bids[i], s.cumBids[i]))
+	}
+	return buf.String()
+}
+
+func (s bookStats) chart(pair generic.CurrencyPair, width, height int) string {
+	tgr := txtg.New(width, height-1)
+	p := chart.ScatterChart{
+		Key:    chart.Key{Hide: true},
+		XRange: chart.Range{Label: pair.Counter.String()},
+		YRange: chart.Range{Label: pair.Base.String()},
+	}
+
+	points := func(list []bitstamp.OrderBookLevel, cum []float64) []chart.EPoint {
+		n := make([]chart.EPoint, 0, len(list)*2)
+		prev := 0.0
+		for i, l := range list {
+			n = append(n, chart.EPoint{X: l.Price, Y: prev}, chart.EPoint{X: l.Price, Y: cum[i]})
+			prev = cum[i]
+		}
+		return n
+	}
+
+	if len(s.bids) != 0 {
+		p.AddData("Bids", points(s.bids, s.cumBids), chart.PlotStyleLines, chart.Style{Symbol: '▓'})
+	}
+	if len(s.asks) != 0 {
+		p.AddData("Asks", points(s.asks, s.cumAsks), chart.PlotStyleLines, chart.Style{Symbol: '░'})
+	}
+	p.Plot(tgr)
+
+	return fmt.Sprintf("%s\n%s%s%s%s", tgr, bg, s.summary(pair), rst, clrLine)
+}
+
+func orderBook(o orderBookOptions) error {
+	client, err := bitstamp.NewDefaults("", "")
+	if err != nil {
+		return err
+	}
+
+	books := make(chan bitstamp.OrderBook, 1)
+	errs := make(chan error, 2)
+	go func() {
+		errs <- client.OrderBookLive(o.pair, books)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sig)
+
+	os.Stdout.WriteString(cursorHide)
+	defer os.Stdout.WriteString(clr + cursorHome + cursorShow)
+
+	var book bitstamp.OrderBook
+	var lastRender time.Time
+	buf := bytes.NewBuffer(nil)
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-sig:
+			return nil
+		case book = <-books:
+			if time.Since(lastRender) < time.Millisecond*100 {
+				continue
+			}
+		case <-time.After(time.Second):
+		}
+		lastRender = time.Now()
+
+		termX, termY := termSize()
+		if termX < 20 || termY < 5 {
+			termX, termY = 80, 24
+		}
+
+		s := newBookStats(book, 100)
+		buf.WriteString(cursorHome)
+		switch {
+		case len(s.bids) == 0 && len(s.asks) == 0:
+			buf.WriteString(clr + s.summary(o.pair))
+		case o.chart:
+			buf.WriteString(clr + s.chart(o.pair, termX, termY))
+		default:
+			buf.WriteString(s.table(o.pair, termX, termY))
+		}
+		io.Copy(os.Stdout, buf)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+type outputFormat string
+
+const (
+	formatText   outputFormat = "text"
+	formatJSON   outputFormat = "json"
+	formatNDJSON outputFormat = "ndjson"
+	formatCSV    outputFormat = "csv"
+	formatTSV    outputFormat = "tsv"
+)
+
+func parseFormat(str string) (outputFormat, error) {
+	switch f := outputFormat(str); f {
+	case formatText, formatJSON, formatNDJSON, formatCSV, formatTSV:
+		return f, nil
+	}
+	return formatText, fmt.Errorf("unknown output format '%s'", str)
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// row is a list of fields whose order is preserved in all formats.
+type row []field
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func (r row) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte('{')
+	for i, f := range r {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		var v []byte
+		switch val := f.value.(type) {
+		case float64:
+			v = []byte("null")
+			if str := formatValue(val); str != "" {
+				v = []byte(str)
+			}
+		case time.Time, fmt.Stringer:
+			v, err = json.Marshal(formatValue(val))
+		default:
+			v, err = json.Marshal(val)
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// output writes rows in a machine readable format, Close must be called
+// to complete the output.
+type output interface {
+	Write(row) error
+	Close() error
+}
+
+func (f outputFormat) writer(w io.Writer) output {
+	switch f {
+	case formatJSON:
+		return &jsonOutput{w: w}
+	case formatNDJSON:
+		return ndjsonOutput{json.NewEncoder(w)}
+	case formatTSV:
+		c := csv.NewWriter(w)
+		c.Comma = '\t'
+		return &csvOutput{w: c}
+	}
+	return &csvOutput{w: csv.NewWriter(w)}
+}
+
+type jsonOutput struct {
+	w io.Writer
+	n int
+}
+
+func (j *jsonOutput) Write(r row) error {
+	d, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.n == 0 {
+		sep = "[\n"
+	}
+	j.n++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, d)
+	return err
+}
+
+func (j *jsonOutput) Close() error {
+	str := "\n]\n"
+	if j.n == 0 {
+		str = "[]\n"
+	}
+	_, err := io.WriteString(j.w, str)
+	return err
+}
+
+type ndjsonOutput struct{ enc *json.Encoder }
+
+func (n ndjsonOutput) Write(r row) error { return n.enc.Encode(r) }
+func (n ndjsonOutput) Close() error      { return nil }
+
+// csvOutput writes a header based on the first row, all rows are
+// expected to have the same fields.
+type csvOutput struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvOutput) Write(r row) error {
+	if !c.header {
+		c.header = true
+		keys := make([]string, len(r))
+		for i, f := range r {
+			keys[i] = f.key
+		}
+		if err := c.w.Write(keys); err != nil {
+			return err
+		}
+	}
+
+	values := make([]string, len(r))
+	for i, f := range r {
+		values[i] = formatValue(f.value)
+	}
+	if err := c.w.Write(values); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvOutput) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/pnl"
+)
+
+// replayPnL replays the entire transaction history of the account.
+func replayPnL(client *bitstamp.Bitstamp, quote generic.Currency, method pnl.Method) (*pnl.Engine, error) {
+	prices := client.PriceHistory()
+	e := pnl.New(quote, method, func(c generic.Currency, t time.Time) (float64, error) {
+		return prices.Price(c, quote, t)
+	})
+
+	var err error
+	werr := client.WalkTransactions(api.TransactionsQuery{}, func(t bitstamp.Transaction) bool {
+		err = e.Add(t)
+		return err == nil
+	})
+	if werr != nil {
+		return e, werr
+	}
+	return e, err
+}
+
+func printDisposals(out output, e *pnl.Engine) error {
+	pq := bitstamp.Precision(e.Quote)
+	var total float64
+	for _, d := range e.Disposals() {
+		total += d.Gain()
+		if out != nil {
+			err := out.Write(row{
+				{"datetime", d.Date},
+				{"transaction_id", d.TransactionID},
+				{"currency", d.Currency},
+				{"amount", d.Amount},
+				{"acquired", d.Acquired()},
+				{"proceeds", d.Proceeds},
+				{"cost", d.Cost},
+				{"gain", d.Gain()},
+				{"uncovered", d.Uncovered},
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		warn := ""
+		if d.Uncovered != 0 {
+			warn = fmt.Sprintf(" (%g without cost basis)", d.Uncovered)
+		}
+		fmt.Printf(
+			"%s %-5s %18.*f proceeds %14.*f cost %14.*f gain %+14.*f%s\n",
+			d.Date.Local().Format(dateFormat),
+			d.Currency,
+			bitstamp.Precision(d.Currency), d.Amount,
+			pq, d.Proceeds,
+			pq, d.Cost,
+			pq, d.Gain(),
+			warn,
+		)
+	}
+	if out == nil {
+		fmt.Printf("%-66s gain %+14.*f\n", "total", pq, total)
+	}
+	return nil
+}
+
+func printPnL(client *bitstamp.Bitstamp, out output, e *pnl.Engine) error {
+	positions := e.Positions()
+	amounts := make(map[generic.Currency]float64, len(positions))
+	for _, p := range positions {
+		amounts[p.Currency] = p.Amount
+	}
+	pf, err := client.Portfolio(amounts, e.Quote)
+	if err != nil {
+		return err
+	}
+	prices := make(map[generic.Currency]bitstamp.Holding, len(pf.Holdings))
+	for _, h := range pf.Holdings {
+		prices[h.Currency] = h
+	}
+
+	pq := bitstamp.Precision(e.Quote)
+	if out == nil {
+		fmt.Printf(
+			"%-6s %18s %14s %14s %14s %14s %14s\n",
+			e.Method, "amount", "cost", "avg cost", "value", "unrealized", "realized",
+		)
+	}
+	var realized, unrealized float64
+	for _, p := range positions {
+		h, priced := prices[p.Currency]
+		priced = priced && h.Priced
+		var avg float64
+		if p.Amount != 0 {
+			avg = p.Cost / p.Amount
+		}
+		realized += p.Realized
+		if priced {
+			unrealized += p.Unrealized(h.Price)
+		}
+
+		if out != nil {
+			ifPriced := func(v float64) interface{} {
+				if !priced {
+					return nil
+				}
+				return v
+			}
+			err := out.Write(row{
+				{"currency", p.Currency},
+				{"quote", e.Quote},
+				{"method", e.Method.String()},
+				{"amount", p.Amount},
+				{"cost", p.Cost},
+				{"avg_cost", avg},
+				{"value", ifPriced(h.Value)},
+				{"unrealized", ifPriced(p.Unrealized(h.Price))},
+				{"realized", p.Realized},
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, unr := "-", "-"
+		if priced {
+			value = fmt.Sprintf("%14.*f", pq, h.Value)
+			unr = fmt.Sprintf("%+14.*f", pq, p.Unrealized(h.Price))
+		}
+		fmt.Printf(
+			"%-6s %18.*f %14.*f %14.*f %14s %14s %+14.*f\n",
+			p.Currency,
+			bitstamp.Precision(p.Currency), p.Amount,
+			pq, p.Cost,
+			pq, avg,
+			value,
+			unr,
+			pq, p.Realized,
+		)
+	}
+	if out == nil {
+		fmt.Printf("%-6s %18s %14s %14s %14s %+14.*f %+14.*f\n", "total", "", "", "", "", pq, unrealized, pq, realized)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/frizinak/bitstamp"
+)
+
+func routeString(h bitstamp.Holding) string {
+	if len(h.Route) < 2 {
+		return ""
+	}
+	str := []string{h.Currency.String()}
+	for _, s := range h.Route {
+		next := s.Pair.Counter
+		if s.Inverse {
+			next = s.Pair.Base
+		}
+		str = append(str, next.String())
+	}
+	return strings.Join(str, ">")
+}
+
+func printPortfolio(out output, p bitstamp.Portfolio) error {
+	if out != nil {
+		for _, h := range p.Holdings {
+			priced := func(v float64) interface{} {
+				if !h.Priced {
+					return nil
+				}
+				return v
+			}
+			err := out.Write(row{
+				{"currency", h.Currency},
+				{"amount", h.Amount},
+				{"quote", p.Quote},
+				{"price", priced(h.Price)},
+				{"value", priced(h.Value)},
+				{"change_24h", priced(h.Change)},
+				{"allocation", priced(h.Allocation)},
+				{"route", routeString(h)},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	pq := bitstamp.Precision(p.Quote)
+	quote := strings.ToUpper(p.Quote.String())
+	fmt.Printf(
+		"%-6s %18s %18s %8s %18s %7s\n",
+		"", "amount", "price "+quote, "24h %", "value "+quote, "alloc %",
+	)
+	for _, h := range p.Holdings {
+		amount := fmt.Sprintf("%18.*f", bitstamp.Precision(h.Currency), h.Amount)
+		if !h.Priced {
+			fmt.Printf("%-6s %s %18s\n", h.Currency, amount, "no route")
+			continue
+		}
+		fmt.Printf(
+			"%-6s %s %18.*f %+8.2f %18.*f %7.2f %s\n",
+			h.Currency,
+			amount,
+			pq, h.Price,
+			h.Change,
+			pq, h.Value,
+			h.Allocation,
+			routeString(h),
+		)
+	}
+	fmt.Printf("%-6s %18s %18s %+8.2f %18.*f\n", "total", "", "", p.Change, pq, p.Value)
+	return nil
+}
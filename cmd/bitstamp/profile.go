@@ -0,0 +1,497 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	sourcePlain     = "plain"
+	sourceEncrypted = "encrypted"
+	sourceEnv       = "env"
+	sourceCommand   = "command"
+)
+
+// passphraseEnv overrides the passphrase prompt for encrypted secrets.
+const passphraseEnv = "BITSTAMP_PASSPHRASE"
+
+const (
+	pbkdf2Iterations = 200000
+	// pbkdf2MaxIterations bounds the iterations read from a profile so a
+	// tampered file can not stall the cli.
+	pbkdf2MaxIterations = 10 * pbkdf2Iterations
+)
+
+const profileHelp = `Profiles (<config>/profiles.json, used instead of <config>/auth if present):
+  config list                         list profiles
+  config add <name> [source [arg]]    add a profile, the api key and secret are
+                                      read from stdin, source is one of:
+                                        encrypted      encrypt with a passphrase
+                                                       (default, $` + passphraseEnv + `)
+                                        plain          store the secret as is
+                                        env <VAR>      read from an environment variable
+                                        command <cmd>  first line of output (e.g. pass)
+                                      -sub marks it as a sub account profile,
+                                      -ro refuses orders, cancels and transfers
+  config remove <name>                remove a profile
+  -profile selects a profile, the first one is used by default.`
+
+type profile struct {
+	Name       string `json:"name"`
+	SubAccount uint64 `json:"sub_account,omitempty"`
+	ReadOnly   bool   `json:"read_only,omitempty"`
+	Key        string `json:"key"`
+	Source     string `json:"source"`
+	// Secret is the plain secret, the encrypted secret, the environment
+	// variable or the command depending on Source.
+	Secret string `json:"secret"`
+}
+
+func (p profile) String() string {
+	if p.SubAccount != 0 {
+		return fmt.Sprintf("%s (sub account %d)", p.Name, p.SubAccount)
+	}
+	return p.Name
+}
+
+type profiles struct {
+	file string
+	list []profile
+	pass []byte
+}
+
+func readProfiles(configDir string) (*profiles, error) {
+	p := &profiles{file: filepath.Join(configDir, "profiles.json")}
+	f, err := os.Open(p.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return p, err
+	}
+	defer f.Close()
+
+	var data struct {
+		Profiles []profile `json:"profiles"`
+	}
+	if err := json.NewDecoder(f).Decode(&data); err != nil {
+		return p, fmt.Errorf("%s: %w", p.file, err)
+	}
+	p.list = data.Profiles
+	return p, nil
+}
+
+func (p *profiles) save() error {
+	if err := os.MkdirAll(filepath.Dir(p.file), 0o700); err != nil {
+		return err
+	}
+	d, err := json.MarshalIndent(map[string][]profile{"profiles": p.list}, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := p.file + ".tmp"
+	if err := os.WriteFile(tmp, append(d, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.file)
+}
+
+func (p *profiles) find(name string) (profile, bool) {
+	for _, pr := range p.list {
+		if pr.Name == name {
+			return pr, true
+		}
+	}
+	return profile{}, false
+}
+
+func (p *profiles) findSub(id uint64) (profile, bool) {
+	for _, pr := range p.list {
+		if pr.SubAccount == id {
+			return pr, true
+		}
+	}
+	return profile{}, false
+}
+
+func (p *profiles) passphrase(confirmNew bool) ([]byte, error) {
+	if p.pass != nil {
+		return p.pass, nil
+	}
+	if env := os.Getenv(passphraseEnv); env != "" {
+		p.pass = []byte(env)
+		return p.pass, nil
+	}
+
+	pass, err := readSecret("Passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if confirmNew {
+		again, err := readSecret("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if pass != again {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	if pass == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	p.pass = []byte(pass)
+	return p.pass, nil
+}
+
+// credentials resolves the secret of pr.
+func (p *profiles) credentials(pr profile) (credentials, error) {
+	c := credentials{key: pr.Key}
+	switch pr.Source {
+	case sourcePlain:
+		c.secret = pr.Secret
+	case sourceEncrypted:
+		pass, err := p.passphrase(false)
+		if err != nil {
+			return c, err
+		}
+		d, err := decrypt(pass, pr.Secret)
+		if err != nil {
+			return c, fmt.Errorf("profile %s: %w", pr.Name, err)
+		}
+		c.secret = string(d)
+	case sourceEnv:
+		c.secret = os.Getenv(pr.Secret)
+		if c.secret == "" {
+			return c, fmt.Errorf("profile %s: environment variable %s is empty", pr.Name, pr.Secret)
+		}
+	case sourceCommand:
+		args, err := shlex.Split(pr.Secret)
+		if err != nil || len(args) == 0 {
+			return c, fmt.Errorf("profile %s: invalid command '%s'", pr.Name, pr.Secret)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return c, fmt.Errorf("profile %s: %w", pr.Name, err)
+		}
+		c.secret = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	default:
+		return c, fmt.Errorf("profile %s: unknown secret source '%s'", pr.Name, pr.Source)
+	}
+	return c, nil
+}
+
+// subAccounts resolves the credentials of all sub account profiles and
+// appends those of <config>/subaccounts that are not configured as a profile.
+func (p *profiles) subAccounts(configDir string) (subAccounts, error) {
+	list, err := readSubAccounts(configDir)
+	if err != nil {
+		return list, err
+	}
+	n := make(subAccounts, 0, len(list))
+	for _, pr := range p.list {
+		if pr.SubAccount == 0 {
+			continue
+		}
+		c, err := p.credentials(pr)
+		if err != nil {
+			return n, err
+		}
+		n = append(n, subAccount{id: pr.SubAccount, name: pr.Name, credentials: c})
+	}
+	for _, s := range list {
+		if _, ok := n.find(s.id); !ok {
+			n = append(n, s)
+		}
+	}
+	return n, nil
+}
+
+// account selects the credentials for the given profile and sub account,
+// falling back to <config>/auth and <config>/subaccounts if no profiles
+// are configured.
+func (p *profiles) account(configDir, name string, sub uint64) (credentials, profile, error) {
+	var pr profile
+	switch {
+	case sub != 0:
+		if s, ok := p.findSub(sub); ok {
+			c, err := p.credentials(s)
+			return c, s, err
+		}
+		subs, err := readSubAccounts(configDir)
+		if err != nil {
+			return credentials{}, pr, err
+		}
+		s, ok := subs.find(sub)
+		if !ok {
+			return credentials{}, pr, fmt.Errorf("sub account %d is not configured", sub)
+		}
+		return s.credentials, profile{Name: s.String(), SubAccount: sub}, nil
+	case name != "":
+		var ok bool
+		if pr, ok = p.find(name); !ok {
+			return credentials{}, pr, fmt.Errorf("profile '%s' does not exist", name)
+		}
+	case len(p.list) != 0:
+		pr = p.list[0]
+	default:
+		c, err := readAuth(configDir)
+		return c, profile{Name: "auth"}, err
+	}
+
+	c, err := p.credentials(pr)
+	return c, pr, err
+}
+
+func (p *profiles) add(pr profile) error {
+	if pr.Name == "" || strings.ContainsAny(pr.Name, " \t\n") {
+		return fmt.Errorf("invalid profile name '%s'", pr.Name)
+	}
+	if _, ok := p.find(pr.Name); ok {
+		return fmt.Errorf("profile '%s' already exists", pr.Name)
+	}
+	if pr.SubAccount != 0 {
+		if s, ok := p.findSub(pr.SubAccount); ok {
+			return fmt.Errorf("sub account %d is already configured as '%s'", pr.SubAccount, s.Name)
+		}
+	}
+
+	key, err := readLine("API key: ")
+	if err != nil {
+		return err
+	}
+	if pr.Key = strings.TrimSpace(key); pr.Key == "" {
+		return errors.New("empty api key")
+	}
+
+	switch pr.Source {
+	case sourceEnv, sourceCommand:
+		if pr.Secret == "" {
+			return fmt.Errorf("source %s requires an argument", pr.Source)
+		}
+	case sourcePlain, sourceEncrypted:
+		secret, err := readSecret("API secret: ")
+		if err != nil {
+			return err
+		}
+		if secret == "" {
+			return errors.New("empty api secret")
+		}
+		pr.Secret = secret
+		if pr.Source == sourcePlain {
+			break
+		}
+		pass, err := p.passphrase(!p.hasEncrypted())
+		if err != nil {
+			return err
+		}
+		if pr.Secret, err = encrypt(pass, []byte(secret)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown secret source '%s'", pr.Source)
+	}
+
+	p.list = append(p.list, pr)
+	return p.save()
+}
+
+func (p *profiles) hasEncrypted() bool {
+	for _, pr := range p.list {
+		if pr.Source == sourceEncrypted {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *profiles) remove(name string) error {
+	for i, pr := range p.list {
+		if pr.Name == name {
+			p.list = append(p.list[:i], p.list[i+1:]...)
+			return p.save()
+		}
+	}
+	return fmt.Errorf("profile '%s' does not exist", name)
+}
+
+// readSecret reads a line from stdin with echo disabled.
+func readSecret(question string) (string, error) {
+	cmd := exec.Command("stty", "-echo")
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err == nil {
+		defer func() {
+			cmd := exec.Command("stty", "echo")
+			cmd.Stdin = os.Stdin
+			cmd.Run()
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	return readLine(question)
+}
+
+// readLine reads a line from stdin byte by byte so nothing after the line
+// is consumed.
+func readLine(question string) (string, error) {
+	fmt.Fprint(os.Stderr, question)
+	var l []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 && b[0] != '\n' {
+			l = append(l, b[0])
+			continue
+		}
+		if err != nil && len(l) == 0 {
+			return "", err
+		}
+		return strings.TrimSpace(string(l)), nil
+	}
+}
+
+// deriveKey derives an AES-256 key from pass with PBKDF2-HMAC-SHA256.
+func deriveKey(pass, salt []byte, iter int) []byte {
+	return pbkdf2.Key(pass, salt, iter, 32, sha256.New)
+}
+
+// encrypt seals data with AES-256-GCM using a key derived from pass,
+// formatted as pbkdf2-sha256$<iterations>$<salt>$<nonce + ciphertext>.
+func encrypt(pass, data []byte) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(pass, salt, pbkdf2Iterations)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf(
+		"pbkdf2-sha256$%d$%s$%s",
+		pbkdf2Iterations,
+		enc.EncodeToString(salt),
+		enc.EncodeToString(gcm.Seal(nonce, nonce, data, nil)),
+	), nil
+}
+
+func decrypt(pass []byte, str string) ([]byte, error) {
+	errInvalid := errors.New("invalid encrypted secret")
+	parts := strings.Split(str, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return nil, errInvalid
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 || iter > pbkdf2MaxIterations {
+		return nil, errInvalid
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalid
+	}
+	data, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return nil, errInvalid
+	}
+
+	gcm, err := newGCM(pass, salt, iter)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errInvalid
+	}
+	d, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase")
+	}
+	return d, nil
+}
+
+func newGCM(pass, salt []byte, iter int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(pass, salt, iter))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", len(key)-8) + key[len(key)-4:]
+}
+
+// configProfiles runs the config list|add|remove subcommands.
+func configProfiles(p *profiles, out output, args []string, sub uint64, readOnly bool) error {
+	usage := errors.New("usage: config list | add <name> [source [arg]] | remove <name>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		for _, pr := range p.list {
+			if out != nil {
+				err := out.Write(row{
+					{"name", pr.Name},
+					{"sub_account", pr.SubAccount},
+					{"read_only", pr.ReadOnly},
+					{"source", pr.Source},
+					{"key", maskKey(pr.Key)},
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			var flags []string
+			if pr.SubAccount != 0 {
+				flags = append(flags, fmt.Sprintf("sub account %d", pr.SubAccount))
+			}
+			if pr.ReadOnly {
+				flags = append(flags, "read-only")
+			}
+			fmt.Printf("%-16s %-10s %s %s\n", pr.Name, pr.Source, maskKey(pr.Key), strings.Join(flags, ", "))
+		}
+		return nil
+	case "add":
+		if len(args) < 2 {
+			return usage
+		}
+		pr := profile{Name: args[1], SubAccount: sub, ReadOnly: readOnly, Source: sourceEncrypted}
+		if len(args) > 2 {
+			pr.Source = args[2]
+			pr.Secret = strings.Join(args[3:], " ")
+		}
+		return p.add(pr)
+	case "remove", "rm":
+		if len(args) != 2 {
+			return usage
+		}
+		return p.remove(args[1])
+	}
+
+	return usage
+}
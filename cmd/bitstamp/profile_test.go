@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	// RFC 7914 section 11, truncated to the 32 byte key length.
+	vectors := []struct {
+		pass, salt string
+		iter       int
+		key        string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	}
+	for _, v := range vectors {
+		key := hex.EncodeToString(deriveKey([]byte(v.pass), []byte(v.salt), v.iter))
+		if key != v.key {
+			t.Errorf("%s %s %d: %s, expected %s", v.pass, v.salt, v.iter, key, v.key)
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	pass, secret := []byte("correct horse"), []byte("api secret")
+	str, err := encrypt(pass, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(str, string(secret)) {
+		t.Fatal("secret stored in plain text")
+	}
+
+	d, err := decrypt(pass, str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != string(secret) {
+		t.Errorf("decrypted %q, expected %q", d, secret)
+	}
+
+	if _, err := decrypt([]byte("wrong horse"), str); err == nil {
+		t.Error("decrypted with the wrong passphrase")
+	}
+
+	parts := strings.Split(str, "$")
+	tamper := func(ix int, v string) string {
+		p := append([]string{}, parts...)
+		p[ix] = v
+		return strings.Join(p, "$")
+	}
+	invalid := []string{
+		"",
+		"pbkdf2-sha256$1$2",
+		tamper(0, "pbkdf2-sha1"),
+		tamper(1, "0"),
+		tamper(1, "x"),
+		tamper(1, strconv.Itoa(pbkdf2MaxIterations+1)),
+		tamper(2, "!"),
+		tamper(3, "!"),
+		tamper(3, "AAAA"),
+	}
+	for _, s := range invalid {
+		if _, err := decrypt(pass, s); err == nil {
+			t.Errorf("%q decrypted", s)
+		}
+	}
+}
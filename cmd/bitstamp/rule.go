@@ -0,0 +1,538 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/frizinak/bitstamp/indicator"
+)
+
+// ruleHelp documents the alarm rule syntax, conditions that are already
+// true when enough data becomes available do not fire.
+const ruleHelp = `Alarm rules:
+  [name:] <condition> [cooldown <duration>] [rearm <condition> | once]
+          [throttle <duration>] [notify <notifier>[,<notifier>]]
+
+  Conditions compare values with < <= > >= == != and can be combined with
+  and, or, not and parentheses. Values are numbers, arithmetic (+ - * /) or:
+    price                last trade price
+    bid, ask, mid        top of the order book
+    spread, spread_pct   ask - bid, absolute and as a percentage of mid
+    change(d)            price change in percent over duration d
+    vwap(d)              volume weighted average price over duration d
+    volume(d)            traded volume over duration d
+    spike(d, w)          volume over d relative to the average volume per d
+                         in the preceding window w
+    sma(n) ema(n) rsi(n) atr(n) vwap(n) bb(n,k) macd(f,s,n)
+                         indicators over 5 minute candles, bb_lower,
+                         bb_upper, macd_signal and macd_hist select a line
+
+  A fired rule re-arms once its condition is false, or once the rearm
+  condition is true. It doesn't fire again within the cooldown and never
+  again if marked once. Notifications of a rule are throttled to one per
+  5s by default. '>N' and '<N' compare the price.
+
+  e.g.: 'price > 50000'
+        'dip: change(1h) < -5 and volume(1h) > 10 cooldown 30m'
+        'price > bb_upper(20,2) rearm price < ema(20)'
+        'spike(5m,6h) > 3 or spread_pct > 0.5'`
+
+type tokenType byte
+
+const (
+	tokenEOF tokenType = iota
+	tokenNumber
+	tokenDuration
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	typ tokenType
+	str string
+	num float64
+	dur time.Duration
+	pos int
+}
+
+// lex tokenizes str starting at rune from, positions are relative to the
+// start of str.
+func lex(str string, from int) ([]token, error) {
+	list := make([]token, 0)
+	rs := []rune(str)
+	for i := from; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			s := i
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			num := string(rs[s:i])
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			if unit := string(rs[s:i]); unit != num {
+				d, err := parseDuration(unit)
+				if err != nil {
+					return nil, fmt.Errorf("invalid duration '%s' at %d", unit, s)
+				}
+				list = append(list, token{typ: tokenDuration, str: unit, dur: d, pos: s})
+				continue
+			}
+			v, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s' at %d", num, s)
+			}
+			list = append(list, token{typ: tokenNumber, str: num, num: v, pos: s})
+		case unicode.IsLetter(r) || r == '_':
+			s := i
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+				i++
+			}
+			list = append(list, token{typ: tokenIdent, str: strings.ToLower(string(rs[s:i])), pos: s})
+		default:
+			op := string(r)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case ">=", "<=", "==", "!=", "&&", "||":
+					op = two
+				}
+			}
+			if !strings.Contains("><=!&|+-*/(),", op[:1]) || op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("unexpected '%s' at %d", op, i)
+			}
+			list = append(list, token{typ: tokenOp, str: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(list, token{typ: tokenEOF, pos: len(rs)}), nil
+}
+
+// parseDuration is time.ParseDuration with support for days.
+func parseDuration(str string) (time.Duration, error) {
+	if strings.HasSuffix(str, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(str, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(str)
+}
+
+type node interface {
+	// eval returns the value of the node, booleans are 1 or 0. ok is false
+	// if there is not enough data yet.
+	eval(m *market) (v float64, ok bool)
+}
+
+type number float64
+
+func (n number) eval(*market) (float64, bool) { return float64(n), true }
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+func boolean(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (b binary) eval(m *market) (float64, bool) {
+	l, okl := b.l.eval(m)
+	r, okr := b.r.eval(m)
+	switch b.op {
+	case "and":
+		return boolean(l != 0 && r != 0), (okl && okr) || (okl && l == 0) || (okr && r == 0)
+	case "or":
+		return boolean(l != 0 || r != 0), (okl && okr) || (okl && l != 0) || (okr && r != 0)
+	}
+
+	if !okl || !okr {
+		return 0, false
+	}
+	switch b.op {
+	case ">":
+		return boolean(l > r), true
+	case ">=":
+		return boolean(l >= r), true
+	case "<":
+		return boolean(l < r), true
+	case "<=":
+		return boolean(l <= r), true
+	case "==":
+		return boolean(l == r), true
+	case "!=":
+		return boolean(l != r), true
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+	return 0, false
+}
+
+type not struct{ n node }
+
+func (n not) eval(m *market) (float64, bool) {
+	v, ok := n.n.eval(m)
+	return boolean(v == 0), ok
+}
+
+type neg struct{ n node }
+
+func (n neg) eval(m *market) (float64, bool) {
+	v, ok := n.n.eval(m)
+	return -v, ok
+}
+
+// variable is a market value, see market.value.
+type variable struct {
+	name string
+	durs []time.Duration
+	ind  indicator.Indicator
+}
+
+func (v variable) eval(m *market) (float64, bool) { return m.value(v) }
+
+var variables = map[string]int{
+	"price":      0,
+	"bid":        0,
+	"ask":        0,
+	"mid":        0,
+	"spread":     0,
+	"spread_pct": 0,
+	"change":     1,
+	"volume":     1,
+	"spike":      2,
+}
+
+var indicatorAccessors = map[string]string{
+	"bb_lower":    "bb",
+	"bb_upper":    "bb",
+	"macd_signal": "macd",
+	"macd_hist":   "macd",
+}
+
+type parser struct {
+	tokens []token
+	ix     int
+}
+
+func (p *parser) peek() token { return p.tokens[p.ix] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.ix]
+	if t.typ != tokenEOF {
+		p.ix++
+	}
+	return t
+}
+
+func (p *parser) is(strs ...string) bool {
+	t := p.peek()
+	if t.typ != tokenOp && t.typ != tokenIdent {
+		return false
+	}
+	for _, s := range strs {
+		if t.str == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(str string) error {
+	if t := p.next(); t.str != str || (t.typ != tokenOp && t.typ != tokenIdent) {
+		return p.errorf(t, "expected '%s'", str)
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	str := t.str
+	if t.typ == tokenEOF {
+		str = "end of rule"
+	}
+	return fmt.Errorf("%s, got '%s' at %d", fmt.Sprintf(format, args...), str, t.pos)
+}
+
+// condition parses boolean expressions.
+func (p *parser) condition() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("or", "||") {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{"or", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("and", "&&") {
+		p.next()
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{"and", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.is("not", "!") {
+		p.next()
+		n, err := p.unary()
+		return not{n}, err
+	}
+
+	// Parenthesized conditions, backtrack if it turns out to be arithmetic.
+	if p.is("(") {
+		ix := p.ix
+		p.next()
+		n, err := p.condition()
+		if err == nil && p.is(")") {
+			p.next()
+			if !p.is("+", "-", "*", "/", ">", ">=", "<", "<=", "==", "!=") {
+				return n, nil
+			}
+		}
+		p.ix = ix
+	}
+
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	l, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(">", ">=", "<", "<=", "==", "!=") {
+		return nil, p.errorf(p.peek(), "expected a comparison")
+	}
+	op := p.next().str
+	r, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	return binary{op, l, r}, nil
+}
+
+func (p *parser) sum() (node, error) {
+	l, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("+", "-") {
+		op := p.next().str
+		r, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op, l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) product() (node, error) {
+	l, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("*", "/") {
+		op := p.next().str
+		r, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op, l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) operand() (node, error) {
+	t := p.next()
+	switch {
+	case t.typ == tokenNumber:
+		return number(t.num), nil
+	case t.typ == tokenOp && t.str == "-":
+		n, err := p.operand()
+		return neg{n}, err
+	case t.typ == tokenOp && t.str == "(":
+		n, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case t.typ == tokenIdent:
+		return p.variable(t)
+	}
+	return nil, p.errorf(t, "expected a value")
+}
+
+func (p *parser) args() ([]token, error) {
+	if !p.is("(") {
+		return nil, nil
+	}
+	p.next()
+	args := make([]token, 0)
+	for !p.is(")") {
+		if len(args) != 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		t := p.next()
+		if t.typ != tokenNumber && t.typ != tokenDuration {
+			return nil, p.errorf(t, "expected a number or duration")
+		}
+		args = append(args, t)
+	}
+	p.next()
+	return args, nil
+}
+
+func (p *parser) variable(t token) (node, error) {
+	args, err := p.args()
+	if err != nil {
+		return nil, err
+	}
+
+	// vwap is an indicator when given a candle count.
+	isIndicator := t.str != "vwap" || (len(args) != 0 && args[0].typ == tokenNumber)
+	if n, ok := variables[t.str]; ok || (t.str == "vwap" && !isIndicator) {
+		if t.str == "vwap" {
+			n = 1
+		}
+		if len(args) != n {
+			return nil, p.errorf(t, "%s expects %d duration arguments", t.str, n)
+		}
+		v := variable{name: t.str}
+		for _, a := range args {
+			if a.typ != tokenDuration || a.dur <= 0 {
+				return nil, p.errorf(a, "%s expects a duration", t.str)
+			}
+			v.durs = append(v.durs, a.dur)
+		}
+		return v, nil
+	}
+
+	name := t.str
+	if n, ok := indicatorAccessors[t.str]; ok {
+		name = n
+	}
+	spec := make([]string, len(args))
+	for i, a := range args {
+		if a.typ != tokenNumber {
+			return nil, p.errorf(a, "%s expects numbers", t.str)
+		}
+		spec[i] = a.str
+	}
+	ind, err := indicator.Parse(fmt.Sprintf("%s(%s)", name, strings.Join(spec, ",")))
+	if err != nil {
+		return nil, fmt.Errorf("%w at %d", err, t.pos)
+	}
+	return variable{name: t.str, ind: ind}, nil
+}
+
+// ParseRule parses a single alarm rule, see ruleHelp.
+func ParseRule(str string) (*Rule, error) {
+	str = strings.TrimSpace(str)
+	if strings.HasPrefix(str, ">") || strings.HasPrefix(str, "<") {
+		str = "price " + str
+	}
+
+	r := &Rule{src: str}
+	var from int
+	if ix := strings.IndexByte(str, ':'); ix != -1 {
+		name := strings.TrimSpace(str[:ix])
+		if name != "" && !strings.ContainsAny(name, " \t()<>=!") {
+			r.Name = name
+			from = utf8.RuneCountInString(str[:ix+1])
+		}
+	}
+
+	tokens, err := lex(str, from)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if r.cond, err = p.condition(); err != nil {
+		return nil, err
+	}
+
+	for p.peek().typ != tokenEOF {
+		t := p.next()
+		switch {
+		case t.typ == tokenIdent && t.str == "cooldown":
+			d := p.next()
+			if d.typ != tokenDuration {
+				return nil, p.errorf(d, "expected a duration")
+			}
+			r.Cooldown = d.dur
+		case t.typ == tokenIdent && t.str == "rearm":
+			if r.rearm, err = p.condition(); err != nil {
+				return nil, err
+			}
+		case t.typ == tokenIdent && t.str == "throttle":
+			d := p.next()
+			if d.typ != tokenDuration {
+				return nil, p.errorf(d, "expected a duration")
+			}
+			r.Throttle = d.dur
+		case t.typ == tokenIdent && t.str == "notify":
+			for {
+				n := p.next()
+				if n.typ != tokenIdent {
+					return nil, p.errorf(n, "expected a notifier name")
+				}
+				r.Notify = append(r.Notify, n.str)
+				if !p.is(",") {
+					break
+				}
+				p.next()
+			}
+		case t.typ == tokenIdent && t.str == "once":
+			r.Once = true
+		default:
+			return nil, p.errorf(t, "expected 'and', 'or', 'cooldown', 'rearm', 'once', 'throttle' or 'notify'")
+		}
+	}
+
+	if r.Name == "" {
+		r.Name = str
+	}
+
+	return r, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dump formats a node as a prefix expression.
+func dump(n node) string {
+	switch n := n.(type) {
+	case number:
+		return fmt.Sprintf("%g", float64(n))
+	case binary:
+		return fmt.Sprintf("(%s %s %s)", n.op, dump(n.l), dump(n.r))
+	case not:
+		return fmt.Sprintf("(not %s)", dump(n.n))
+	case neg:
+		return fmt.Sprintf("(- %s)", dump(n.n))
+	case variable:
+		if n.ind != nil {
+			return fmt.Sprintf("%s:%s", n.name, n.ind)
+		}
+		if len(n.durs) == 0 {
+			return n.name
+		}
+		durs := make([]string, len(n.durs))
+		for i, d := range n.durs {
+			durs[i] = d.String()
+		}
+		return fmt.Sprintf("%s(%s)", n.name, strings.Join(durs, ","))
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		exp  string
+	}{
+		{"price > 50000", "(> price 50000)"},
+		{">50000", "(> price 50000)"},
+		{"<= 1.5", "(<= price 1.5)"},
+		{"price > 1 + 2 * 3", "(> price (+ 1 (* 2 3)))"},
+		{"price - 1 - 2 / 4 / 2 > 0", "(> (- (- price 1) (/ (/ 2 4) 2)) 0)"},
+		{"-price < -1", "(< (- price) (- 1))"},
+		{"price > 1 or price < 2 and price > 3", "(or (> price 1) (and (< price 2) (> price 3)))"},
+		{"price > 1 || price < 2 && price > 3", "(or (> price 1) (and (< price 2) (> price 3)))"},
+		{"not price > 1 and !price < 2", "(and (not (> price 1)) (not (< price 2)))"},
+		{"not not price == 1", "(not (not (== price 1)))"},
+
+		// Parentheses group conditions or arithmetic.
+		{"(price > 1 or price < 0) and price != 5", "(and (or (> price 1) (< price 0)) (!= price 5))"},
+		{"((price > 1))", "(> price 1)"},
+		{"(price + 1) * 2 > 3", "(> (* (+ price 1) 2) 3)"},
+		{"(price) > 1", "(> price 1)"},
+		{"((price - 1)) >= (2)", "(>= (- price 1) 2)"},
+		{"not (price > 1 or (price + 1) / 2 < 3)", "(not (or (> price 1) (< (/ (+ price 1) 2) 3)))"},
+
+		{"PRICE > BID", "(> price bid)"},
+		{"spread_pct > 0.5 or spread > mid / 100", "(or (> spread_pct 0.5) (> spread (/ mid 100)))"},
+		{"change(1h) < -5", "(< change(1h0m0s) (- 5))"},
+		{"change(1.5d) < 0", "(< change(36h0m0s) 0)"},
+		{"spike(5m, 6h) > 3", "(> spike(5m0s,6h0m0s) 3)"},
+		{"vwap(1h) > vwap(20)", "(> vwap(1h0m0s) vwap:vwap(20))"},
+		{"price > bb_upper(20,2) and macd_hist(12,26,9) > 0", "(and (> price bb_upper:bb(20,2)) (> macd_hist:macd(12,26,9) 0))"},
+		{"rsi < 30", "(< rsi:rsi(14) 30)"},
+	}
+
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("%q: %s", test.rule, err)
+			continue
+		}
+		if d := dump(r.cond); d != test.exp {
+			t.Errorf("%q parsed as %s, expected %s", test.rule, d, test.exp)
+		}
+	}
+}
+
+func TestParseRuleOptions(t *testing.T) {
+	tests := []struct {
+		rule     string
+		name     string
+		cooldown time.Duration
+		rearm    string
+		once     bool
+		throttle time.Duration
+		notify   []string
+	}{
+		{rule: " price > 1 ", name: "price > 1"},
+		{rule: "dip: price < 5", name: "dip"},
+		{rule: " dip :price < 5 once", name: "dip", once: true},
+		{rule: "price < 5 cooldown 30m", name: "price < 5 cooldown 30m", cooldown: 30 * time.Minute},
+		{rule: "dip: change(1h) < -5 and volume(1h) > 10 cooldown 1.5d", name: "dip", cooldown: 36 * time.Hour},
+		{
+			rule:     "top: price > bb_upper(20,2) rearm price < ema(20) or price < 10 throttle 1m notify desktop, bell cooldown 5m",
+			name:     "top",
+			cooldown: 5 * time.Minute,
+			rearm:    "(or (< price ema:ema(20)) (< price 10))",
+			throttle: time.Minute,
+			notify:   []string{"desktop", "bell"},
+		},
+		{rule: "price > 1 notify desktop once", name: "price > 1 notify desktop once", once: true, notify: []string{"desktop"}},
+	}
+
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("%q: %s", test.rule, err)
+			continue
+		}
+		rearm := ""
+		if r.rearm != nil {
+			rearm = dump(r.rearm)
+		}
+		if r.Name != test.name || r.Cooldown != test.cooldown || rearm != test.rearm || r.Once != test.once || r.Throttle != test.throttle || !reflect.DeepEqual(r.Notify, test.notify) {
+			t.Errorf(
+				"%q: name %q cooldown %s rearm %s once %t throttle %s notify %v, expected %q %s %s %t %s %v",
+				test.rule,
+				r.Name, r.Cooldown, rearm, r.Once, r.Throttle, r.Notify,
+				test.name, test.cooldown, test.rearm, test.once, test.throttle, test.notify,
+			)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  string
+	}{
+		{"", "expected a value, got 'end of rule' at 0"},
+		{"price", "expected a comparison, got 'end of rule' at 5"},
+		{"price >", "expected a value, got 'end of rule' at 7"},
+		{"price > 1 and", "expected a value, got 'end of rule' at 13"},
+		{"price = 1", "unexpected '=' at 6"},
+		{"price > 1 ?", "unexpected '?' at 10"},
+		{"price > 1.2.3", "invalid number '1.2.3' at 8"},
+		{"price > 5x", "invalid duration '5x' at 8"},
+		{"price > 1h", "expected a value, got '1h' at 8"},
+		{"price > 1 2", "expected 'and', 'or', 'cooldown', 'rearm', 'once', 'throttle' or 'notify', got '2' at 10"},
+		{"price > 1 foo", "expected 'and', 'or', 'cooldown', 'rearm', 'once', 'throttle' or 'notify', got 'foo' at 10"},
+		{"(price > 1) > 0", "expected ')', got '>' at 7"},
+		{"(price + 1 > 0", "expected ')', got '>' at 11"},
+		{"price > (1", "expected ')', got 'end of rule' at 10"},
+		{"change() > 1", "change expects 1 duration arguments, got 'change' at 0"},
+		{"spike(5m) > 1", "spike expects 2 duration arguments, got 'spike' at 0"},
+		{"change(5) > 1", "change expects a duration, got '5' at 7"},
+		{"volume(0s) > 1", "volume expects a duration, got '0s' at 7"},
+		{"vwap(1h, 2) > 1", "vwap expects 1 duration arguments, got 'vwap' at 0"},
+		{"sma(1h) > 1", "sma expects numbers, got '1h' at 4"},
+		{"sma(3 4) > 1", "expected ',', got '4' at 6"},
+		{"sma(,) > 1", "expected a number or duration, got ',' at 4"},
+		{"sma(3", "expected ',', got 'end of rule' at 5"},
+		{"foo > 1", "unknown indicator 'foo' at 0"},
+		{"price > sma(0)", "invalid sma argument '0' at 8"},
+		{"price > 1 cooldown 5", "expected a duration, got '5' at 19"},
+		{"price > 1 throttle", "expected a duration, got 'end of rule' at 18"},
+		{"price > 1 rearm", "expected a value, got 'end of rule' at 15"},
+		{"price > 1 notify", "expected a notifier name, got 'end of rule' at 16"},
+		{"price > 1 notify a,", "expected a notifier name, got 'end of rule' at 19"},
+
+		// Positions include the name.
+		{"dip: price >", "expected a value, got 'end of rule' at 12"},
+		{"dip:price = 1", "unexpected '=' at 10"},
+		{"my dip: price > 1", "unexpected ':' at 6"},
+		{": price > 1", "unexpected ':' at 0"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseRule(test.rule)
+		if err == nil {
+			t.Errorf("%q: parsed", test.rule)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%q: %s, expected %s", test.rule, err, test.err)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		rule string
+		v    float64
+		ok   bool
+	}{
+		{"price > 10 and 1 < 2", 1, true},
+		{"price > 20 or 1 > 2", 0, true},
+		{"price / 0 > 1", 0, false},
+		{"price * 2 - 4 / 2 == 20", 1, true},
+
+		// bid is unknown without an order book.
+		{"bid > 1", 0, false},
+		{"not bid > 1", 1, false},
+		{"bid > 1 and price > 1", 0, false},
+		{"bid > 1 and price > 20", 0, true},
+		{"price > 20 and bid > 1", 0, true},
+		{"bid > 1 or price > 20", 0, false},
+		{"bid > 1 or price > 1", 1, true},
+		{"price > 1 or bid > 1", 1, true},
+	}
+
+	m := &market{price: 11, trade: true}
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Fatalf("%q: %s", test.rule, err)
+		}
+		v, ok := r.cond.eval(m)
+		if ok != test.ok || (ok && v != test.v) {
+			t.Errorf("%q: %g %t, expected %g %t", test.rule, v, ok, test.v, test.ok)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	type step struct {
+		min   int
+		price float64
+		fire  bool
+	}
+	tests := []struct {
+		rule  string
+		steps []step
+	}{
+		{
+			// Doesn't fire when already true, re-arms once false.
+			"price > 10",
+			[]step{{0, 11, false}, {1, 12, false}, {2, 9, false}, {3, 11, true}, {4, 12, false}, {5, 9, false}, {6, 11, true}},
+		},
+		{
+			"price > 10 cooldown 5m",
+			[]step{{0, 9, false}, {1, 11, true}, {2, 9, false}, {3, 11, false}, {6, 11, true}},
+		},
+		{
+			"price > 10 rearm price < 5",
+			[]step{{0, 9, false}, {1, 11, true}, {2, 9, false}, {3, 11, false}, {4, 4, false}, {5, 11, true}},
+		},
+		{
+			"price > 10 once",
+			[]step{{0, 9, false}, {1, 11, true}, {2, 9, false}, {3, 11, false}},
+		},
+	}
+
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Fatalf("%q: %s", test.rule, err)
+		}
+		m := &market{}
+		if r.Check(m) {
+			t.Errorf("%q fired without a price", test.rule)
+		}
+		m.trade = true
+		for _, s := range test.steps {
+			m.now, m.price = start.Add(time.Duration(s.min)*time.Minute), s.price
+			if fired := r.Check(m); fired != s.fire {
+				t.Errorf("%q at minute %d, price %g: fired %t, expected %t", test.rule, s.min, s.price, fired, s.fire)
+			}
+		}
+	}
+}
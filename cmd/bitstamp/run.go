@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/strategy"
+)
+
+const runHelp = `Run:
+  run <strategy>
+  Runs a built-in strategy (see backtest) on live market data of the -bc/-cc
+  pair. Orders are simulated on a paper account with -balance in the -cc
+  currency unless -live is passed. Every order is checked against
+  -max-order, -max-position and -max-rate, -max-loss cancels the orders of
+  the strategy and stops once the value of the account dropped by that much
+  -cc today. Interrupting cancels the open orders of the strategy, orders
+  placed otherwise are left alone.`
+
+type runOptions struct {
+	pair     generic.CurrencyPair
+	spec     string
+	live     bool
+	interval time.Duration
+	fee      float64
+	balance  float64
+	limits   strategy.Limits
+}
+
+// logged prints the own orders and fills of a strategy.
+type logged struct {
+	strategy.Strategy
+	out output
+}
+
+func (l logged) Start(ctx strategy.Context) error {
+	if s, ok := l.Strategy.(strategy.Starter); ok {
+		return s.Start(ctx)
+	}
+	return nil
+}
+
+func (l logged) Stop(ctx strategy.Context) error {
+	if s, ok := l.Strategy.(strategy.Stopper); ok {
+		return s.Stop(ctx)
+	}
+	return nil
+}
+
+func (l logged) Book(ctx strategy.Context, b bitstamp.OrderBook) error {
+	if s, ok := l.Strategy.(strategy.BookHandler); ok {
+		return s.Book(ctx, b)
+	}
+	return nil
+}
+
+func (l logged) Fill(ctx strategy.Context, f strategy.Fill) error {
+	var err error
+	if l.out != nil {
+		err = l.out.Write(row{
+			{"datetime", f.Time},
+			{"event", "fill"},
+			{"order_id", f.OrderID},
+			{"type", f.Side},
+			{"amount", f.Amount},
+			{"price", f.Price},
+			{"fee", f.Fee},
+		})
+	} else {
+		fmt.Printf(
+			"%s fill  #%-10d %-4s %18.*f @ %14.*f fee %.*f\n",
+			f.Time.Local().Format(dateFormat),
+			f.OrderID,
+			f.Side,
+			bitstamp.Precision(ctx.Pair.Base), f.Amount,
+			bitstamp.Precision(ctx.Pair.Counter), f.Price,
+			bitstamp.Precision(ctx.Pair.Counter), f.Fee,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	if s, ok := l.Strategy.(strategy.FillHandler); ok {
+		return s.Fill(ctx, f)
+	}
+	return nil
+}
+
+func (l logged) Order(ctx strategy.Context, o strategy.Order) error {
+	var err error
+	if l.out != nil {
+		err = l.out.Write(row{
+			{"datetime", ctx.Time},
+			{"event", "order"},
+			{"order_id", o.ID},
+			{"type", o.Side},
+			{"amount", o.Amount},
+			{"price", o.Price},
+			{"state", o.State},
+		})
+	} else {
+		fmt.Printf(
+			"%s order #%-10d %-4s %18.*f @ %14.*f %s\n",
+			ctx.Time.Local().Format(dateFormat),
+			o.ID,
+			o.Side,
+			bitstamp.Precision(ctx.Pair.Base), o.Amount,
+			bitstamp.Precision(ctx.Pair.Counter), o.Price,
+			o.State,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	if s, ok := l.Strategy.(strategy.OrderHandler); ok {
+		return s.Order(ctx, o)
+	}
+	return nil
+}
+
+func (l logged) Candle(ctx strategy.Context, c candle.Candle) error {
+	return l.Strategy.Candle(ctx, c)
+}
+
+func (l logged) Trade(ctx strategy.Context, t bitstamp.Trade) error {
+	return l.Strategy.Trade(ctx, t)
+}
+
+func runStrategy(client *bitstamp.Bitstamp, out output, o runOptions) error {
+	s, err := strategy.Parse(o.spec)
+	if err != nil {
+		return err
+	}
+
+	var exec bitstamp.Executor = client.API
+	fills := strategy.LiveFills(client, o.pair)
+	if !o.live {
+		paper := bitstamp.NewPaper(o.fee, map[generic.Currency]float64{o.pair.Counter: o.balance})
+		exec, fills = paper, strategy.PaperFills(paper)
+	}
+	rt := strategy.New(o.pair, logged{s, out}, exec, fills, o.interval, o.limits)
+	if o.live {
+		rt.SyncEvery = 5 * time.Second
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sig)
+	interrupted := make(chan struct{})
+	go func() {
+		<-sig
+		close(interrupted)
+		rt.Kill("interrupted")
+	}()
+
+	if out == nil {
+		mode := "paper"
+		if o.live {
+			mode = "live"
+		}
+		fmt.Printf("running %s on %s (%s)\n", s, o.pair, mode)
+	}
+	err = rt.Run(client)
+	select {
+	case <-interrupted:
+		if errors.Is(err, strategy.ErrKilled) {
+			err = nil
+		}
+	default:
+	}
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		b, err := exec.BalancesPair(o.pair)
+		if err != nil {
+			return err
+		}
+		for _, c := range []generic.Currency{o.pair.Base, o.pair.Counter} {
+			fmt.Printf("%-6s %18.*f\n", c, bitstamp.Precision(c), b.Currency(c).Total)
+		}
+		if rt.Rejected != 0 {
+			fmt.Printf("%d orders rejected by limits\n", rt.Rejected)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+const tradeHelp = `Trading:
+  buy|sell [market] <amount>          market order
+  buy|sell instant <amount>           instant order
+  buy|sell limit <amount> <price>     limit order (-daily, -ioc, -fok)
+  buy|sell stop <amount> <trigger> [limit price]
+                                      wait until the price crosses trigger
+                                      (buy: >=, sell: <=) and place a market
+                                      or limit order, keep the command running
+  orders [all]                        list open orders of the -bc/-cc pair
+  cancel <id> [id ...] | all          cancel open orders
+  Amounts are in the base currency unless -counter is given.`
+
+type tradeOptions struct {
+	side    api.TradeType
+	kind    string
+	pair    generic.CurrencyPair
+	amount  float64
+	price   float64
+	limit   float64
+	counter bool
+
+	daily, ioc, fok bool
+
+	dryRun bool
+	yes    bool
+}
+
+func parseTradeArgs(o tradeOptions, args []string) (tradeOptions, error) {
+	o.kind = "market"
+	if len(args) != 0 {
+		if _, err := strconv.ParseFloat(args[0], 64); err != nil {
+			o.kind, args = args[0], args[1:]
+		}
+	}
+
+	nums := make([]float64, len(args))
+	for i, a := range args {
+		v, err := strconv.ParseFloat(a, 64)
+		if err != nil || v <= 0 {
+			return o, fmt.Errorf("invalid number '%s'", a)
+		}
+		nums[i] = v
+	}
+
+	usage := func(args string) error {
+		return fmt.Errorf("usage: %s %s %s", o.side, o.kind, args)
+	}
+	switch o.kind {
+	case "market", "instant":
+		if len(nums) != 1 {
+			return o, usage("<amount>")
+		}
+	case "limit":
+		if len(nums) != 2 {
+			return o, usage("<amount> <price>")
+		}
+		o.price = nums[1]
+	case "stop":
+		if len(nums) != 2 && len(nums) != 3 {
+			return o, usage("<amount> <trigger> [limit price]")
+		}
+		o.price = nums[1]
+		if len(nums) == 3 {
+			o.limit = nums[2]
+		}
+	default:
+		return o, fmt.Errorf("unknown order type '%s'", o.kind)
+	}
+	o.amount = nums[0]
+
+	if o.kind != "limit" && (o.daily || o.ioc || o.fok) {
+		return o, errors.New("-daily, -ioc and -fok only apply to limit orders")
+	}
+
+	return o, nil
+}
+
+func round(v float64, decimals int) float64 {
+	p := math.Pow10(decimals)
+	return math.Round(v*p) / p
+}
+
+// tradeEstimate is the expected fill of an order.
+type tradeEstimate struct {
+	base, counter float64
+	fee           float64
+	complete      bool
+}
+
+func (e tradeEstimate) price() float64 {
+	if e.base == 0 {
+		return 0
+	}
+	return e.counter / e.base
+}
+
+// estimate the fill of o by walking the order book, limit and stop orders
+// are estimated at their limit or trigger price.
+func (o tradeOptions) estimate(book bitstamp.OrderBook, feePct float64) tradeEstimate {
+	var e tradeEstimate
+	price := o.price
+	if o.limit != 0 {
+		price = o.limit
+	}
+
+	switch {
+	case o.kind == "market" || o.kind == "instant":
+		e.base, e.counter, e.complete = book.Fill(o.side, o.amount, o.counter)
+	case o.counter:
+		e.base, e.counter, e.complete = o.amount/price, o.amount, true
+	default:
+		e.base, e.counter, e.complete = o.amount, o.amount*price, true
+	}
+	e.fee = e.counter * feePct / 100
+	return e
+}
+
+// order creates the api order, market orders do not accept amounts in
+// counter currency so the estimated base amount is used instead.
+func (o tradeOptions) order(e tradeEstimate) (api.Order, error) {
+	pp := bitstamp.Precision(o.pair.Counter)
+	base := round(o.amount, 8)
+	if o.counter {
+		base = round(e.base, 8)
+	}
+	if base <= 0 {
+		return nil, errors.New("amount too small")
+	}
+
+	kind := o.kind
+	if kind == "stop" {
+		kind = "market"
+		if o.limit != 0 {
+			kind = "limit"
+		}
+		if kind == "market" && o.counter {
+			kind = "instant"
+		}
+	}
+
+	switch kind {
+	case "market":
+		if o.side == api.Buy {
+			return api.NewBuyOrder(o.pair, base), nil
+		}
+		return api.NewSellOrder(o.pair, base), nil
+	case "instant":
+		var order api.SimpleOrder
+		if o.side == api.Buy {
+			order = api.NewInstantBuyOrder(o.pair, base)
+		} else {
+			order = api.NewInstantSellOrder(o.pair, base)
+		}
+		if o.counter {
+			order.Amount, order.AmountCounter = round(o.amount, pp), true
+		}
+		return order, nil
+	}
+
+	price := o.price
+	if o.limit != 0 {
+		price = o.limit
+	}
+	order := api.NewLimitSell(o.pair, base, round(price, pp))
+	if o.side == api.Buy {
+		order = api.NewLimitBuy(o.pair, base, round(price, pp))
+	}
+	order.Daily, order.IOC, order.FOK = o.daily, o.ioc, o.fok
+	return order, nil
+}
+
+func (o tradeOptions) summary(e tradeEstimate, feePct float64) string {
+	pb, pc := bitstamp.Precision(o.pair.Base), bitstamp.Precision(o.pair.Counter)
+	base, counter := strings.ToUpper(o.pair.Base.String()), strings.ToUpper(o.pair.Counter.String())
+	lines := make([]string, 0, 6)
+	add := func(f string, args ...interface{}) { lines = append(lines, fmt.Sprintf(f, args...)) }
+
+	add("%s %s %s/%s", o.kind, o.side, base, counter)
+	if o.kind == "stop" {
+		cmp := ">="
+		if o.side == api.Sell {
+			cmp = "<="
+		}
+		add("trigger:  price %s %.*f", cmp, pc, o.price)
+	}
+	if o.kind == "limit" || o.limit != 0 {
+		price := o.price
+		if o.limit != 0 {
+			price = o.limit
+		}
+		add("limit:    %.*f %s", pc, price, counter)
+	}
+	add("amount:   %.*f %s", pb, e.base, base)
+	add("price:    ~%.*f %s", pc, e.price(), counter)
+
+	total, label := e.counter+e.fee, "cost"
+	if o.side == api.Sell {
+		total, label = e.counter-e.fee, "proceeds"
+	}
+	add("fee:      ~%.*f %s (%g%%)", pc, e.fee, counter, feePct)
+	add("%-9s ~%.*f %s", label+":", pc, total, counter)
+	if !e.complete {
+		add("warning:  the order book lacks the liquidity to fill this order")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func confirm(w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	l, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	l = strings.ToLower(strings.TrimSpace(l))
+	return l == "y" || l == "yes"
+}
+
+// trade places the order described by o, if out is set the summary and
+// prompt are written to stderr and the placed order to out.
+func trade(client *bitstamp.Bitstamp, out output, o tradeOptions) error {
+	info := io.Writer(os.Stdout)
+	if out != nil {
+		info = os.Stderr
+	}
+
+	book, err := client.OrderBook(o.pair)
+	if err != nil {
+		return err
+	}
+	bal, err := client.API.BalancesPair(o.pair)
+	if err != nil {
+		return err
+	}
+	fee, _ := bal.Fee(o.pair)
+	e := o.estimate(book, fee)
+	order, err := o.order(e)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(info, o.summary(e, fee))
+	if o.dryRun {
+		if out == nil {
+			return nil
+		}
+		return out.Write(row{
+			{"kind", o.kind},
+			{"side", o.side},
+			{"pair", o.pair},
+			{"amount", e.base},
+			{"price", e.price()},
+			{"counter", e.counter},
+			{"fee", e.fee},
+			{"complete", e.complete},
+		})
+	}
+	if !o.yes && !confirm(info, "Place order?") {
+		return errors.New("aborted")
+	}
+
+	if o.kind == "stop" {
+		if err := waitStop(client, info, o); err != nil {
+			return err
+		}
+	}
+
+	r, err := client.API.Place(order)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		return out.Write(row{
+			{"id", r.ID},
+			{"datetime", r.DateTime.Value()},
+			{"pair", o.pair},
+			{"type", r.Type},
+			{"amount", r.Amount.Value()},
+			{"price", r.Price.Value()},
+		})
+	}
+	fmt.Printf(
+		"placed order %d: %s %.*f @ %.*f\n",
+		r.ID,
+		r.Type,
+		bitstamp.Precision(o.pair.Base), r.Amount.Value(),
+		bitstamp.Precision(o.pair.Counter), r.Price.Value(),
+	)
+	return nil
+}
+
+// waitStop blocks until the price of a live trade crosses the trigger price.
+func waitStop(client *bitstamp.Bitstamp, w io.Writer, o tradeOptions) error {
+	trades := make(chan bitstamp.Trade, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.TradesLive(api.TradesHistoryNone, o.pair, trades)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sig)
+
+	pc := bitstamp.Precision(o.pair.Counter)
+	fmt.Fprintf(w, "waiting for trigger %.*f\n", pc, o.price)
+	for {
+		select {
+		case err := <-errs:
+			if err == nil {
+				err = errors.New("trade stream closed before the stop triggered")
+			}
+			return err
+		case <-sig:
+			return errors.New("aborted, stop did not trigger")
+		case t := <-trades:
+			if !t.Live {
+				continue
+			}
+			fmt.Fprintf(w, "%s%s %.*f%s", cursorBOL, t.Date.Local().Format(dateFormat), pc, t.Price, clrLine)
+			if (o.side == api.Buy && t.Price >= o.price) || (o.side == api.Sell && t.Price <= o.price) {
+				fmt.Fprintln(w)
+				return nil
+			}
+		}
+	}
+}
+
+func printOrders(client *bitstamp.Bitstamp, out output, pair generic.CurrencyPair, all bool) error {
+	var list []api.OpenOrder
+	var err error
+	if all {
+		list, err = client.API.OpenOrdersAll()
+	} else {
+		list, err = client.API.OpenOrders(pair)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, o := range list {
+		p := o.Pair()
+		if !all {
+			p = pair
+		}
+		if out != nil {
+			err := out.Write(row{
+				{"id", o.ID},
+				{"datetime", o.DateTime.Value()},
+				{"pair", p},
+				{"type", o.Type},
+				{"amount", o.Amount.Value()},
+				{"price", o.Price.Value()},
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf(
+			"%12d %s %9s %-4s %16.*f @ %.*f\n",
+			o.ID,
+			o.DateTime.Value().Local().Format(dateFormat),
+			fmt.Sprintf("%s/%s", p.Base, p.Counter),
+			o.Type,
+			bitstamp.Precision(p.Base), o.Amount.Value(),
+			bitstamp.Precision(p.Counter), o.Price.Value(),
+		)
+	}
+	return nil
+}
+
+func cancelOrders(client *bitstamp.Bitstamp, out output, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: cancel <id> [id ...] | all")
+	}
+
+	show := func(o api.CanceledOrder) error {
+		if out != nil {
+			return out.Write(row{
+				{"id", o.ID},
+				{"pair", o.Pair()},
+				{"type", o.Type},
+				{"amount", o.Amount.Value()},
+				{"price", o.Price.Value()},
+			})
+		}
+		fmt.Printf("canceled order %d: %s %g @ %g\n", o.ID, o.Type, o.Amount.Value(), o.Price.Value())
+		return nil
+	}
+
+	if len(args) == 1 && args[0] == "all" {
+		list, err := client.API.CancelAll()
+		for _, o := range list {
+			if err := show(o); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	ids := make([]uint64, len(args))
+	for i, a := range args {
+		id, err := strconv.ParseUint(a, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid order id '%s'", a)
+		}
+		ids[i] = id
+	}
+	for _, id := range ids {
+		o, err := client.API.Cancel(id)
+		if err != nil {
+			return fmt.Errorf("cancel %d: %w", id, err)
+		}
+		if err := show(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/store"
+)
+
+const (
+	reverse = "\033[7m"
+	bold    = "\033[1m"
+)
+
+type watchOptions struct {
+	pairs    []generic.CurrencyPair
+	storeDir string
+
+	// args are passed to the live command when drilling into a pair.
+	args []string
+}
+
+type watchRow struct {
+	pair generic.CurrencyPair
+	mkt  *market
+
+	pingValue float64
+	pingTime  time.Time
+}
+
+type watchColumn struct {
+	name  string
+	width int
+	value func(r *watchRow) (float64, bool)
+}
+
+var watchColumns = []watchColumn{
+	{"pair", 9, nil},
+	{"price", 16, func(r *watchRow) (float64, bool) { return r.mkt.price, r.mkt.trade }},
+	{"1h %", 9, func(r *watchRow) (float64, bool) { return r.mkt.change(time.Hour) }},
+	{"24h %", 9, func(r *watchRow) (float64, bool) { return r.mkt.change(24 * time.Hour) }},
+	{"vwap 24h", 16, func(r *watchRow) (float64, bool) {
+		v, _ := r.mkt.vwap(24 * time.Hour)
+		return v, v != 0
+	}},
+	{"volume 24h", 16, func(r *watchRow) (float64, bool) {
+		list, _ := r.mkt.window(24 * time.Hour)
+		return list.Volume(), len(list) != 0
+	}},
+}
+
+func (c watchColumn) format(r *watchRow) string {
+	if c.value == nil {
+		return fmt.Sprintf("%-*s", c.width, fmt.Sprintf("%s/%s", r.pair.Base, r.pair.Counter))
+	}
+	v, ok := c.value(r)
+	if !ok {
+		return fmt.Sprintf("%*s", c.width, "-")
+	}
+	if strings.HasSuffix(c.name, "%") {
+		return fmt.Sprintf("%+*.2f", c.width, v)
+	}
+	p := bitstamp.Precision(r.pair.Counter)
+	if c.name == "volume 24h" {
+		p = bitstamp.Precision(r.pair.Base)
+	}
+	return fmt.Sprintf("%*.*f", c.width, p, v)
+}
+
+// rawTerm disables line buffering and echo on stdin, the returned func
+// restores the previous terminal state.
+func rawTerm() (func(), error) {
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = os.Stdin
+	state, err := cmd.Output()
+	if err != nil {
+		return func() {}, err
+	}
+	cmd = exec.Command("stty", "-icanon", "-echo", "min", "1")
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		return func() {}, err
+	}
+	return func() {
+		cmd := exec.Command("stty", strings.TrimSpace(string(state)))
+		cmd.Stdin = os.Stdin
+		cmd.Run()
+	}, nil
+}
+
+func watch(o watchOptions) error {
+	if len(o.pairs) == 0 {
+		return fmt.Errorf("no pairs to watch")
+	}
+
+	client, err := bitstamp.NewDefaults("", "")
+	if err != nil {
+		return err
+	}
+	if o.storeDir != "" {
+		st, err := store.Open(o.storeDir)
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		client.Store = st
+		client.Gap = warnGap
+	}
+
+	type pairTrade struct {
+		row   *watchRow
+		trade bitstamp.Trade
+	}
+
+	rows := make([]*watchRow, len(o.pairs))
+	trades := make(chan pairTrade, len(o.pairs))
+	errs := make(chan error, len(o.pairs)+1)
+	since := time.Now().Add(-24 * time.Hour)
+	for i, p := range o.pairs {
+		row := &watchRow{pair: p, mkt: newMarketKeep(nil, 24*time.Hour+time.Minute)}
+		rows[i] = row
+		ch := make(chan bitstamp.Trade, 1)
+		go func() {
+			for t := range ch {
+				trades <- pairTrade{row, t}
+			}
+		}()
+		go func(p generic.CurrencyPair) {
+			errs <- client.TradesLiveSince(since, p, ch)
+		}(p)
+	}
+
+	restore, err := rawTerm()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	keys := make(chan []byte, 10)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			k := make([]byte, n)
+			copy(k, buf[:n])
+			keys <- k
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sig)
+
+	var child *exec.Cmd
+	var childExit time.Time
+	childDone := make(chan error, 1)
+	// drill runs live for pair in a child process. It shares the trade store
+	// with this process, the store locks its files so both can append to
+	// the same pair.
+	drill := func(pair generic.CurrencyPair) error {
+		bin, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		args := append([]string{}, o.args...)
+		args = append(args, "-bc", pair.Base.String(), "-cc", pair.Counter.String(), "live")
+		child = exec.Command(bin, args...)
+		child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := child.Start(); err != nil {
+			child = nil
+			return err
+		}
+		go func(c *exec.Cmd) { childDone <- c.Wait() }(child)
+		return nil
+	}
+
+	sortCol, desc := 0, false
+	selected := o.pairs[0]
+	move := func(n int) {
+		sortRows(rows, sortCol, desc)
+		ix := 0
+		for i, r := range rows {
+			if r.pair == selected {
+				ix = i
+			}
+		}
+		ix += n
+		if ix >= 0 && ix < len(rows) {
+			selected = rows[ix].pair
+		}
+	}
+	var lastErr string
+	var lastRender time.Time
+	buf := bytes.NewBuffer(nil)
+	os.Stdout.WriteString(cursorHide)
+	defer os.Stdout.WriteString(clr + cursorHome + cursorShow)
+
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-sig:
+			if child != nil || time.Since(childExit) < time.Second {
+				continue
+			}
+			return nil
+		case err := <-childDone:
+			child, childExit = nil, time.Now()
+			if err != nil {
+				lastErr = fmt.Sprintf("live: %s", err)
+			}
+		case t := <-trades:
+			r := t.row
+			if r.mkt.trade && r.mkt.price != t.trade.Price && t.trade.Live {
+				r.pingValue, r.pingTime = r.mkt.price, time.Now()
+			}
+			r.mkt.addTrade(t.trade)
+			if !t.trade.Live || time.Since(lastRender) < time.Millisecond*100 {
+				continue
+			}
+		case k := <-keys:
+			if child != nil {
+				if string(k) == "q" {
+					child.Process.Signal(syscall.SIGTERM)
+				}
+				continue
+			}
+			switch key := string(k); key {
+			case "q":
+				return nil
+			case "j", "\033[B":
+				move(1)
+			case "k", "\033[A":
+				move(-1)
+			case "\n", "\r", "l", "\033[C":
+				if err := drill(selected); err != nil {
+					lastErr = err.Error()
+				}
+			case "r":
+				desc = !desc
+			default:
+				if len(key) == 1 && key[0] >= '1' && int(key[0]-'0') <= len(watchColumns) {
+					col := int(key[0] - '1')
+					desc = col == sortCol && !desc
+					sortCol = col
+				}
+			}
+		case <-time.After(time.Second):
+		}
+
+		if child != nil {
+			continue
+		}
+		lastRender = time.Now()
+
+		sortRows(rows, sortCol, desc)
+		buf.WriteString(clr + cursorHome)
+		for i, c := range watchColumns {
+			name := c.name
+			if i == sortCol {
+				name = "▲" + name
+				if desc {
+					name = "▼" + c.name
+				}
+			}
+			format := "%*s "
+			if c.value == nil {
+				format = "%-*s "
+			}
+			fmt.Fprintf(buf, bold+format+rst, c.width, fmt.Sprintf("%d:%s", i+1, name))
+		}
+		buf.WriteString("\n")
+
+		now := time.Now()
+		for _, r := range rows {
+			sel := r.pair == selected
+			if sel {
+				buf.WriteString(reverse)
+			}
+			for j, c := range watchColumns {
+				str := c.format(r)
+				if j == 1 && r.pingValue != r.mkt.price && now.Sub(r.pingTime) < time.Second {
+					color := clrRed
+					if r.mkt.price >= r.pingValue {
+						color = clrGreen
+					}
+					str = color + str + rst
+					if sel {
+						str += reverse
+					}
+				}
+				buf.WriteString(str + " ")
+			}
+			buf.WriteString(rst + clrLine + "\n")
+		}
+
+		buf.WriteString("\n 1-6: sort  r: reverse  j/k: select  enter: graph (q to return)  q: quit\n")
+		if lastErr != "" {
+			buf.WriteString(" " + lastErr + "\n")
+		}
+		io.Copy(os.Stdout, buf)
+	}
+}
+
+func sortRows(rows []*watchRow, col int, desc bool) {
+	c := watchColumns[col]
+	sort.SliceStable(rows, func(i, j int) bool {
+		if c.value == nil {
+			if desc {
+				return rows[i].pair.String() > rows[j].pair.String()
+			}
+			return rows[i].pair.String() < rows[j].pair.String()
+		}
+		a, oka := c.value(rows[i])
+		b, okb := c.value(rows[j])
+		if oka != okb {
+			return oka
+		}
+		if desc {
+			return a > b
+		}
+		return a < b
+	})
+}
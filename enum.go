@@ -1,6 +1,11 @@
 package bitstamp
 
-import "github.com/frizinak/bitstamp/generic"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/frizinak/bitstamp/generic"
+)
 
 const (
 	BTC  generic.Currency = "btc"
@@ -44,6 +49,67 @@ func AllCurrencies() []generic.Currency {
 	}
 }
 
+func AllPairs() []generic.CurrencyPair {
+	return []generic.CurrencyPair{
+		BTCUSD(),
+		BTCEUR(),
+		BTCGBP(),
+		BTCPAX(),
+		GBPUSD(),
+		GBPEUR(),
+		EURUSD(),
+		XRPUSD(),
+		XRPEUR(),
+		XRPBTC(),
+		XRPGBP(),
+		XRPPAX(),
+		LTCUSD(),
+		LTCEUR(),
+		LTCBTC(),
+		LTCGBP(),
+		ETHUSD(),
+		ETHEUR(),
+		ETHBTC(),
+		ETHGBP(),
+		ETHPAX(),
+		BCHUSD(),
+		BCHEUR(),
+		BCHBTC(),
+		BCHGBP(),
+		PAXUSD(),
+		PAXEUR(),
+		PAXGBP(),
+		XLMBTC(),
+		XLMUSD(),
+		XLMEUR(),
+		XLMGBP(),
+		OMGUSD(),
+		OMGEUR(),
+		OMGGBP(),
+		OMGBTC(),
+		LINKUSD(),
+		LINKEUR(),
+		LINKGBP(),
+		LINKBTC(),
+		LINKETH(),
+		USDCUSD(),
+		USDCEUR(),
+		ETHUSDC(),
+		BTCUSDC(),
+	}
+}
+
+// ParsePair parses a known pair like 'btcusd', 'BTC/USD' or 'btc-usd'.
+func ParsePair(str string) (generic.CurrencyPair, error) {
+	str = strings.ToLower(strings.NewReplacer("/", "", "-", "", "_", "").Replace(str))
+	for _, p := range AllPairs() {
+		if p.String() == str {
+			return p, nil
+		}
+	}
+	return generic.CurrencyPair{}, fmt.Errorf("unknown currency pair '%s'", str)
+}
+
 func BTCUSD() generic.CurrencyPair { return generic.CurrencyPair{BTC, USD} }
 func BTCEUR() generic.CurrencyPair { return generic.CurrencyPair{BTC, EUR} }
 func BTCGBP() generic.CurrencyPair { return generic.CurrencyPair{BTC, GBP} }
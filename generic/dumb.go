@@ -120,8 +120,9 @@ func (i *UTCDateString) UnmarshalJSON(d []byte) error {
 		return err
 	}
 
+	// Fractional seconds are optional.
 	t, err := time.ParseInLocation(
-		"2006-01-02 15:04:05.000000",
+		"2006-01-02 15:04:05",
 		str,
 		time.UTC,
 	)
@@ -0,0 +1,75 @@
+package indicator
+
+import (
+	"fmt"
+
+	"github.com/frizinak/bitstamp/candle"
+)
+
+// SMA is the simple moving average of the close price over n candles.
+type SMA struct {
+	w     *window
+	value float64
+	ok    bool
+}
+
+func NewSMA(n int) *SMA { return &SMA{w: newWindow(n)} }
+
+func (s *SMA) Update(c candle.Candle, final bool) {
+	n, sum, _ := s.w.peek(c.Close)
+	s.value, s.ok = sum/float64(n), n == len(s.w.list)
+	if final {
+		s.w.push(c.Close)
+	}
+}
+
+func (s *SMA) Value() (float64, bool) { return s.value, s.ok }
+func (s *SMA) String() string         { return fmt.Sprintf("sma(%d)", len(s.w.list)) }
+func (s *SMA) Overlay()               {}
+
+// EMA is the exponential moving average of the close price over n candles.
+type EMA struct {
+	a     average
+	value float64
+	ok    bool
+}
+
+func NewEMA(n int) *EMA { return &EMA{a: newEMA(n)} }
+
+func (e *EMA) Update(c candle.Candle, final bool) {
+	e.value, e.ok = e.a.peek(c.Close)
+	if final {
+		e.a.push(c.Close)
+	}
+}
+
+func (e *EMA) Value() (float64, bool) { return e.value, e.ok }
+func (e *EMA) String() string         { return fmt.Sprintf("ema(%d)", e.a.n) }
+func (e *EMA) Overlay()               {}
+
+// VWAP is the volume weighted average price over n candles.
+type VWAP struct {
+	quote  *window
+	volume *window
+	value  float64
+	ok     bool
+}
+
+func NewVWAP(n int) *VWAP { return &VWAP{quote: newWindow(n), volume: newWindow(n)} }
+
+func (v *VWAP) Update(c candle.Candle, final bool) {
+	n, q, _ := v.quote.peek(c.QuoteVolume)
+	_, vol, _ := v.volume.peek(c.Volume)
+	v.ok = n == len(v.quote.list) && vol != 0
+	if vol != 0 {
+		v.value = q / vol
+	}
+	if final {
+		v.quote.push(c.QuoteVolume)
+		v.volume.push(c.Volume)
+	}
+}
+
+func (v *VWAP) Value() (float64, bool) { return v.value, v.ok }
+func (v *VWAP) String() string         { return fmt.Sprintf("vwap(%d)", len(v.quote.list)) }
+func (v *VWAP) Overlay()               {}
@@ -0,0 +1,151 @@
+// Package indicator implements technical indicators that are updated
+// incrementally with candles.
+//
+// Every indicator accepts both final and forming candles. A forming candle
+// only affects the current value until it is replaced by the next update,
+// only final candles advance the internal state.
+//
+// Constructors panic for periods smaller than 1, Parse returns an error
+// instead and should be used for user input.
+package indicator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/candle"
+)
+
+type Indicator interface {
+	Update(c candle.Candle, final bool)
+	// Value returns the current value, ok is false until enough candles
+	// were seen.
+	Value() (v float64, ok bool)
+	String() string
+}
+
+// Overlay is implemented by indicators that are expressed in price and
+// can be drawn on top of a price chart.
+type Overlay interface {
+	Indicator
+	Overlay()
+}
+
+// Set updates multiple indicators at once.
+type Set []Indicator
+
+func (s Set) Update(events ...candle.Event) {
+	for _, e := range events {
+		for _, i := range s {
+			i.Update(e.Candle, e.Final)
+		}
+	}
+}
+
+// Tracker feeds trades through a candle.Builder into a Set.
+type Tracker struct {
+	*candle.Builder
+	Set Set
+}
+
+func NewTracker(interval time.Duration, list ...Indicator) *Tracker {
+	return &Tracker{Builder: candle.NewBuilder(interval, true), Set: list}
+}
+
+// Seed seeds both the candle builder and the indicators, see
+// candle.Builder.Seed.
+func (t *Tracker) Seed(candles []candle.Candle, asOf time.Time) []candle.Event {
+	events := t.Builder.Seed(candles, asOf)
+	t.Set.Update(events...)
+	return events
+}
+
+func (t *Tracker) Add(trade bitstamp.Trade) []candle.Event {
+	events := t.Builder.Add(trade)
+	t.Set.Update(events...)
+	return events
+}
+
+func (t *Tracker) Tick(now time.Time) []candle.Event {
+	events := t.Builder.Tick(now)
+	t.Set.Update(events...)
+	return events
+}
+
+// window is a fixed size ring buffer of values.
+type window struct {
+	list  []float64
+	ix    int
+	full  bool
+	sum   float64
+	sumSq float64
+}
+
+// period panics if n is not a valid indicator period.
+func period(n int) int {
+	if n < 1 {
+		panic(fmt.Sprintf("indicator: period must be at least 1, got %d", n))
+	}
+	return n
+}
+
+func newWindow(n int) *window { return &window{list: make([]float64, period(n))} }
+
+func (w *window) len() int {
+	if w.full {
+		return len(w.list)
+	}
+	return w.ix
+}
+
+// oldest returns the value that would be dropped by the next push.
+func (w *window) oldest() float64 {
+	if !w.full {
+		return 0
+	}
+	return w.list[w.ix]
+}
+
+// peek returns the sums as if v were pushed.
+func (w *window) peek(v float64) (n int, sum, sumSq float64) {
+	o := w.oldest()
+	n = w.len()
+	if !w.full {
+		n++
+	}
+	return n, w.sum - o + v, w.sumSq - o*o + v*v
+}
+
+func (w *window) push(v float64) {
+	_, w.sum, w.sumSq = w.peek(v)
+	w.list[w.ix] = v
+	w.ix++
+	if w.ix == len(w.list) {
+		w.ix, w.full = 0, true
+	}
+}
+
+// average is an exponential moving average seeded with the simple average
+// of the first n values.
+type average struct {
+	n     int
+	alpha float64
+	count int
+	avg   float64
+}
+
+func newEMA(n int) average    { return average{n: period(n), alpha: 2 / float64(n+1)} }
+func newWilder(n int) average { return average{n: period(n), alpha: 1 / float64(n)} }
+
+func (a average) peek(v float64) (float64, bool) {
+	if a.count < a.n {
+		return (a.avg*float64(a.count) + v) / float64(a.count+1), a.count+1 >= a.n
+	}
+	return a.avg + a.alpha*(v-a.avg), true
+}
+
+func (a *average) push(v float64) {
+	a.avg, _ = a.peek(v)
+	a.count++
+}
@@ -0,0 +1,210 @@
+package indicator
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp/candle"
+)
+
+var closes = []float64{10, 11, 12, 11, 13, 14, 13, 15, 16, 15}
+
+// series returns candles with the given closes, high and low are one
+// above and below the close, the volume of the i-th candle is i+1.
+func series() []candle.Candle {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := make([]candle.Candle, len(closes))
+	for i, c := range closes {
+		v := float64(i + 1)
+		list[i] = candle.Candle{
+			Start:       start.Add(time.Duration(i) * time.Minute),
+			Interval:    time.Minute,
+			Open:        c,
+			High:        c + 1,
+			Low:         c - 1,
+			Close:       c,
+			Volume:      v,
+			QuoteVolume: v * c,
+			Trades:      1,
+		}
+	}
+	return list
+}
+
+// none marks values that are not available yet.
+var none = math.NaN()
+
+func TestReference(t *testing.T) {
+	signal := func(i Indicator) (float64, bool) { return i.(*MACD).Signal() }
+	upper := func(i Indicator) (float64, bool) {
+		_, _, u, ok := i.(*Bollinger).Bands()
+		return u, ok
+	}
+	tests := []struct {
+		ind   Indicator
+		value func(Indicator) (float64, bool)
+		exp   []float64
+	}{
+		{NewSMA(3), nil, []float64{none, none, 11, 34.0 / 3, 12, 38.0 / 3, 40.0 / 3, 14, 44.0 / 3, 46.0 / 3}},
+		{NewEMA(3), nil, []float64{none, none, 11, 11, 12, 13, 13, 14, 15, 15}},
+		{NewRSI(3), nil, []float64{
+			none, none, none,
+			66.66666666666666, 83.33333333333334, 87.87878787878788, 62.365591397849464,
+			79.88505747126436, 85.09052183173588, 61.29650939777522,
+		}},
+		{NewMACD(2, 3, 2), nil, []float64{
+			none, none, none,
+			0.16666666666666607, 0.3888888888888893, 0.4629629629629637, 0.15432098765432123,
+			0.3847736625514404, 0.46159122085047954, 0.1538637402834926,
+		}},
+		{NewMACD(2, 3, 2), signal, []float64{
+			none, none, none,
+			0.33333333333333304, 0.3703703703703705, 0.4320987654320993, 0.24691358024691393,
+			0.33882030178326494, 0.420667581161408, 0.24279835390946441,
+		}},
+		{NewBollinger(3, 2), nil, []float64{none, none, 11, 34.0 / 3, 12, 38.0 / 3, 40.0 / 3, 14, 44.0 / 3, 46.0 / 3}},
+		{NewBollinger(3, 2), upper, []float64{
+			none, none,
+			12.632993161855453, 12.276142374915397, 13.632993161855453, 15.16110492451596,
+			14.276142374915397, 15.632993161855453, 17.16110492451596, 16.2761423749154,
+		}},
+		{NewATR(3), nil, []float64{
+			none, none, 2, 2, 7.0 / 3, 20.0 / 9, 58.0 / 27, 197.0 / 81, 556.0 / 243, 1598.0 / 729,
+		}},
+		{NewVWAP(3), nil, []float64{
+			none, none, 68.0 / 6, 102.0 / 9, 145.0 / 12, 193.0 / 15, 240.0 / 18, 295.0 / 21, 355.0 / 24, 414.0 / 27,
+		}},
+	}
+
+	for _, test := range tests {
+		value := test.value
+		if value == nil {
+			value = func(i Indicator) (float64, bool) { return i.Value() }
+		}
+		for i, c := range series() {
+			test.ind.Update(c, true)
+			v, ok := value(test.ind)
+			if math.IsNaN(test.exp[i]) {
+				if ok {
+					t.Errorf("%s candle %d: value %g available too early", test.ind, i, v)
+				}
+				continue
+			}
+			if !ok || math.Abs(v-test.exp[i]) > 1e-9 {
+				t.Errorf("%s candle %d: %g %t, expected %g", test.ind, i, v, ok, test.exp[i])
+			}
+		}
+	}
+}
+
+func newIndicators() []func() Indicator {
+	return []func() Indicator{
+		func() Indicator { return NewSMA(3) },
+		func() Indicator { return NewEMA(3) },
+		func() Indicator { return NewRSI(3) },
+		func() Indicator { return NewMACD(2, 3, 2) },
+		func() Indicator { return NewBollinger(3, 2) },
+		func() Indicator { return NewATR(3) },
+		func() Indicator { return NewVWAP(3) },
+	}
+}
+
+func TestForming(t *testing.T) {
+	list := series()
+	for _, fn := range newIndicators() {
+		for n := 0; n < len(list)-1; n++ {
+			a, b := fn(), fn()
+			for _, c := range list[:n] {
+				a.Update(c, true)
+				b.Update(c, true)
+			}
+
+			// A forming candle with wild values followed by the final one.
+			forming := list[n]
+			forming.Close, forming.High, forming.Low = 1000, 1000, 1
+			forming.Volume, forming.QuoteVolume = 50, 50000
+			a.Update(forming, false)
+			a.Update(list[n], true)
+			b.Update(list[n], true)
+			if !reflect.DeepEqual(a, b) {
+				t.Fatalf("%s after %d candles: forming candle changed the state\n%+v\n%+v", a, n, a, b)
+			}
+
+			a.Update(list[n+1], true)
+			b.Update(list[n+1], true)
+			av, aok := a.Value()
+			bv, bok := b.Value()
+			if av != bv || aok != bok {
+				t.Fatalf("%s after %d candles: %g %t, expected %g %t", a, n, av, aok, bv, bok)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	valid := map[string]string{
+		"sma":             "sma(20)",
+		"EMA( 50 )":       "ema(50)",
+		"rsi(7)":          "rsi(7)",
+		"macd(5,10)":      "macd(5,10,9)",
+		"macd(5, 10, 3)":  "macd(5,10,3)",
+		"bb(10,2.5)":      "bb(10,2.5)",
+		"atr":             "atr(14)",
+		"vwap(1)":         "vwap(1)",
+		"sma()":           "sma(20)",
+		" bb ( 20 , 1 ) ": "bb(20,1)",
+	}
+	for spec, exp := range valid {
+		ind, err := Parse(spec)
+		if err != nil {
+			t.Errorf("%q: %s", spec, err)
+			continue
+		}
+		if ind.String() != exp {
+			t.Errorf("%q parsed as %s, expected %s", spec, ind, exp)
+		}
+	}
+
+	invalid := []string{
+		"foo",
+		"sma(3",
+		"sma(x)",
+		"sma(2.5)",
+		"sma(0)",
+		"sma(-1)",
+		"sma(3,4)",
+		"bb(20,2,1)",
+		"bb(20,0)",
+		"bb(20,-1)",
+		"macd(12,26,0)",
+		"macd(1,2,3,4)",
+	}
+	for _, spec := range invalid {
+		if ind, err := Parse(spec); err == nil {
+			t.Errorf("%q parsed as %s", spec, ind)
+		}
+	}
+}
+
+func TestPeriodPanics(t *testing.T) {
+	for _, fn := range []func(){
+		func() { NewSMA(0) },
+		func() { NewEMA(-1) },
+		func() { NewRSI(0) },
+		func() { NewMACD(12, 0, 9) },
+		func() { NewBollinger(0, 2) },
+		func() { NewATR(0) },
+		func() { NewVWAP(-5) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("constructor accepted a period below 1")
+				}
+			}()
+			fn()
+		}()
+	}
+}
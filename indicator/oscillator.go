@@ -0,0 +1,96 @@
+package indicator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/frizinak/bitstamp/candle"
+)
+
+// RSI is the relative strength index over n candles using wilder's
+// smoothing, ranging from 0 to 100.
+type RSI struct {
+	n         int
+	gain      average
+	loss      average
+	prevClose float64
+	hasPrev   bool
+	value     float64
+	ok        bool
+}
+
+func NewRSI(n int) *RSI { return &RSI{n: n, gain: newWilder(n), loss: newWilder(n)} }
+
+func (r *RSI) Update(c candle.Candle, final bool) {
+	if !r.hasPrev {
+		if final {
+			r.prevClose, r.hasPrev = c.Close, true
+		}
+		return
+	}
+
+	d := c.Close - r.prevClose
+	g, l := math.Max(d, 0), math.Max(-d, 0)
+	gain, ok := r.gain.peek(g)
+	loss, _ := r.loss.peek(l)
+	r.ok = ok
+	switch {
+	case loss == 0 && gain == 0:
+		r.value = 50
+	case loss == 0:
+		r.value = 100
+	default:
+		r.value = 100 - 100/(1+gain/loss)
+	}
+
+	if final {
+		r.gain.push(g)
+		r.loss.push(l)
+		r.prevClose = c.Close
+	}
+}
+
+func (r *RSI) Value() (float64, bool) { return r.value, r.ok }
+func (r *RSI) String() string         { return fmt.Sprintf("rsi(%d)", r.n) }
+
+// MACD is the moving average convergence divergence. Value returns the
+// macd line, i.e. the difference between the fast and slow EMA.
+type MACD struct {
+	fast, slow, signal average
+
+	macd, sig float64
+	ok        bool
+}
+
+func NewMACD(fast, slow, signal int) *MACD {
+	return &MACD{fast: newEMA(fast), slow: newEMA(slow), signal: newEMA(signal)}
+}
+
+func (m *MACD) Update(c candle.Candle, final bool) {
+	fast, _ := m.fast.peek(c.Close)
+	slow, ok := m.slow.peek(c.Close)
+	m.macd, m.ok = fast-slow, false
+	if ok {
+		m.sig, m.ok = m.signal.peek(m.macd)
+	}
+
+	if final {
+		m.fast.push(c.Close)
+		m.slow.push(c.Close)
+		if ok {
+			m.signal.push(m.macd)
+		}
+	}
+}
+
+func (m *MACD) Value() (float64, bool) { return m.macd, m.ok }
+
+// Signal returns the signal line, the EMA of the macd line.
+func (m *MACD) Signal() (float64, bool) { return m.sig, m.ok }
+
+// Histogram returns the macd line minus the signal line.
+func (m *MACD) Histogram() (float64, bool) { return m.macd - m.sig, m.ok }
+
+func (m *MACD) String() string {
+	return fmt.Sprintf("macd(%d,%d,%d)", m.fast.n, m.slow.n, m.signal.n)
+}
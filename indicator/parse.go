@@ -0,0 +1,73 @@
+package indicator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type constructor struct {
+	// ints is the amount of leading arguments that are periods.
+	ints     int
+	defaults []float64
+	new      func(args []float64) Indicator
+}
+
+var constructors = map[string]constructor{
+	"sma":  {1, []float64{20}, func(a []float64) Indicator { return NewSMA(int(a[0])) }},
+	"ema":  {1, []float64{20}, func(a []float64) Indicator { return NewEMA(int(a[0])) }},
+	"rsi":  {1, []float64{14}, func(a []float64) Indicator { return NewRSI(int(a[0])) }},
+	"atr":  {1, []float64{14}, func(a []float64) Indicator { return NewATR(int(a[0])) }},
+	"vwap": {1, []float64{24}, func(a []float64) Indicator { return NewVWAP(int(a[0])) }},
+	"bb":   {1, []float64{20, 2}, func(a []float64) Indicator { return NewBollinger(int(a[0]), a[1]) }},
+	"macd": {3, []float64{12, 26, 9}, func(a []float64) Indicator {
+		return NewMACD(int(a[0]), int(a[1]), int(a[2]))
+	}},
+}
+
+// Names returns the names Parse understands.
+func Names() []string {
+	return []string{"sma", "ema", "rsi", "macd", "bb", "atr", "vwap"}
+}
+
+// Parse parses an indicator spec like 'ema(50)', 'bb(20,2)' or 'rsi'.
+// Omitted arguments take their default values.
+func Parse(spec string) (Indicator, error) {
+	spec = strings.ToLower(strings.Join(strings.Fields(spec), ""))
+	name, args := spec, ""
+	if ix := strings.IndexByte(spec, '('); ix != -1 {
+		if !strings.HasSuffix(spec, ")") {
+			return nil, fmt.Errorf("invalid indicator '%s': missing ')'", spec)
+		}
+		name, args = spec[:ix], spec[ix+1:len(spec)-1]
+	}
+
+	c, ok := constructors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown indicator '%s'", name)
+	}
+
+	values := make([]float64, len(c.defaults))
+	copy(values, c.defaults)
+	if args != "" {
+		list := strings.Split(args, ",")
+		if len(list) > len(values) {
+			return nil, fmt.Errorf("%s accepts at most %d arguments", name, len(values))
+		}
+		for i, a := range list {
+			v, err := strconv.ParseFloat(a, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s argument '%s'", name, a)
+			}
+			values[i] = v
+		}
+	}
+
+	for i, v := range values {
+		if v <= 0 || (i < c.ints && v != float64(int(v))) {
+			return nil, fmt.Errorf("invalid %s argument '%s'", name, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	return c.new(values), nil
+}
@@ -0,0 +1,71 @@
+package indicator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/frizinak/bitstamp/candle"
+)
+
+// Bollinger are the bollinger bands over n candles, k standard deviations
+// around the SMA. Value returns the middle band.
+type Bollinger struct {
+	w *window
+	k float64
+
+	lower, middle, upper float64
+	ok                   bool
+}
+
+func NewBollinger(n int, k float64) *Bollinger { return &Bollinger{w: newWindow(n), k: k} }
+
+func (b *Bollinger) Update(c candle.Candle, final bool) {
+	n, sum, sumSq := b.w.peek(c.Close)
+	mean := sum / float64(n)
+	dev := math.Sqrt(math.Max(sumSq/float64(n)-mean*mean, 0))
+	b.lower, b.middle, b.upper = mean-b.k*dev, mean, mean+b.k*dev
+	b.ok = n == len(b.w.list)
+	if final {
+		b.w.push(c.Close)
+	}
+}
+
+func (b *Bollinger) Value() (float64, bool) { return b.middle, b.ok }
+
+func (b *Bollinger) Bands() (lower, middle, upper float64, ok bool) {
+	return b.lower, b.middle, b.upper, b.ok
+}
+
+func (b *Bollinger) String() string {
+	return fmt.Sprintf("bb(%d,%s)", len(b.w.list), strconv.FormatFloat(b.k, 'f', -1, 64))
+}
+
+func (b *Bollinger) Overlay() {}
+
+// ATR is the average true range over n candles using wilder's smoothing.
+type ATR struct {
+	a         average
+	prevClose float64
+	hasPrev   bool
+	value     float64
+	ok        bool
+}
+
+func NewATR(n int) *ATR { return &ATR{a: newWilder(n)} }
+
+func (a *ATR) Update(c candle.Candle, final bool) {
+	tr := c.High - c.Low
+	if a.hasPrev {
+		tr = math.Max(tr, math.Max(math.Abs(c.High-a.prevClose), math.Abs(c.Low-a.prevClose)))
+	}
+
+	a.value, a.ok = a.a.peek(tr)
+	if final {
+		a.a.push(tr)
+		a.prevClose, a.hasPrev = c.Close, true
+	}
+}
+
+func (a *ATR) Value() (float64, bool) { return a.value, a.ok }
+func (a *ATR) String() string         { return fmt.Sprintf("atr(%d)", a.a.n) }
@@ -0,0 +1,146 @@
+package bitstamp
+
+import (
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/ws"
+)
+
+type OrderBookLevel struct {
+	Price  float64
+	Amount float64
+}
+
+// OrderBook contains bids in descending and asks in ascending price order.
+type OrderBook struct {
+	Date time.Time
+	Bids []OrderBookLevel
+	Asks []OrderBookLevel
+}
+
+func (o OrderBook) Bid() (OrderBookLevel, bool) {
+	if len(o.Bids) == 0 {
+		return OrderBookLevel{}, false
+	}
+	return o.Bids[0], true
+}
+
+func (o OrderBook) Ask() (OrderBookLevel, bool) {
+	if len(o.Asks) == 0 {
+		return OrderBookLevel{}, false
+	}
+	return o.Asks[0], true
+}
+
+// Mid returns the average of the best bid and ask.
+func (o OrderBook) Mid() (float64, bool) {
+	bid, ok1 := o.Bid()
+	ask, ok2 := o.Ask()
+	return (bid.Price + ask.Price) / 2, ok1 && ok2
+}
+
+// Spread returns the difference between the best ask and bid.
+func (o OrderBook) Spread() (float64, bool) {
+	bid, ok1 := o.Bid()
+	ask, ok2 := o.Ask()
+	return ask.Price - bid.Price, ok1 && ok2
+}
+
+// Fill simulates a market order against the book, returning the filled
+// base and counter amounts. A buy consumes asks, a sell bids. amount is
+// expressed in counter currency if inCounter is set. complete is false if
+// the book lacks the liquidity to fill the entire amount.
+func (o OrderBook) Fill(side api.TradeType, amount float64, inCounter bool) (base, counter float64, complete bool) {
+	levels := o.Asks
+	if side == api.Sell {
+		levels = o.Bids
+	}
+
+	for _, l := range levels {
+		b, c := l.Amount, l.Amount*l.Price
+		remaining := amount - base
+		if inCounter {
+			remaining = amount - counter
+		}
+		if remaining <= 0 {
+			break
+		}
+		if inCounter && c > remaining {
+			b, c = remaining/l.Price, remaining
+		} else if !inCounter && b > remaining {
+			b, c = remaining, remaining*l.Price
+		}
+		base += b
+		counter += c
+	}
+
+	filled := base
+	if inCounter {
+		filled = counter
+	}
+	return base, counter, filled >= amount*(1-1e-9)
+}
+
+// OrderBook fetches the current order book over the rest api.
+func (b *Bitstamp) OrderBook(pair generic.CurrencyPair) (OrderBook, error) {
+	r, err := b.API.OrderBook(pair)
+	if err != nil {
+		return OrderBook{}, err
+	}
+	return OrderBook{
+		Date: r.MicroTime.Value(),
+		Bids: orderBookLevels(r.Bids),
+		Asks: orderBookLevels(r.Asks),
+	}, nil
+}
+
+func orderBookLevels(l [][2]generic.Float64String) []OrderBookLevel {
+	n := make([]OrderBookLevel, len(l))
+	for i, v := range l {
+		n[i] = OrderBookLevel{Price: v[0].Value(), Amount: v[1].Value()}
+	}
+	return n
+}
+
+// OrderBookLive sends a snapshot of the top of the order book every time
+// it changes.
+func (b *Bitstamp) OrderBookLive(pair generic.CurrencyPair, books chan<- OrderBook) error {
+	ch := b.subscribe()
+	defer b.release(ch)
+	b.eventLoop()
+
+	channel := ws.OrderBook.ForCurrencyPair(pair)
+	if err := b.WS.Subscribe(channel); err != nil {
+		return err
+	}
+
+	for e := range ch {
+		if e.err != nil {
+			return e.err
+		}
+
+		if e.Channel == channel && e.Event == ws.DataEvent {
+			book, err := liveBook(e)
+			if err != nil {
+				return err
+			}
+			books <- book
+		}
+	}
+
+	return nil
+}
+
+func liveBook(e event) (OrderBook, error) {
+	d, err := e.DataOrderBook()
+	if err != nil {
+		return OrderBook{}, err
+	}
+	return OrderBook{
+		Date: d.MicroTimestamp.Value(),
+		Bids: orderBookLevels(d.Bids),
+		Asks: orderBookLevels(d.Asks),
+	}, nil
+}
@@ -0,0 +1,579 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+// Executor places and manages orders. It is implemented by *api.API and by
+// Paper for simulated trading.
+type Executor interface {
+	Place(order api.Order) (api.OrderResponse, error)
+	OpenOrders(pair generic.CurrencyPair) ([]api.OpenOrder, error)
+	OpenOrdersAll() ([]api.OpenOrder, error)
+	Cancel(id uint64) (api.CanceledOrder, error)
+	CancelAll() ([]api.CanceledOrder, error)
+	Balances() (api.Balances, error)
+	BalancesPair(pair generic.CurrencyPair) (api.Balances, error)
+}
+
+var (
+	_ Executor = (*api.API)(nil)
+	_ Executor = (*Paper)(nil)
+)
+
+var (
+	ErrInsufficientFunds = errors.New("You have insufficient funds to place this order")
+	ErrNoLiquidity       = errors.New("Order could not be placed, no liquidity")
+	ErrNotFilled         = errors.New("Order could not be fully filled")
+	ErrOrderNotFound     = errors.New("Order not found")
+)
+
+const paperEpsilon = 1e-12
+
+type paperOrder struct {
+	id     uint64
+	date   time.Time
+	pair   generic.CurrencyPair
+	side   api.TradeType
+	price  float64
+	amount float64
+	daily  bool
+}
+
+func (o *paperOrder) crosses(price float64) bool {
+	if o.side == api.Buy {
+		return price <= o.price
+	}
+	return price >= o.price
+}
+
+func (o *paperOrder) open() api.OpenOrder {
+	return api.OpenOrder{
+		ID:           generic.Uint64String(o.id),
+		DateTime:     generic.UTCDateString(o.date),
+		Type:         o.side,
+		Price:        generic.Float64String(o.price),
+		Amount:       generic.Float64String(o.amount),
+		CurrencyPair: slashPair(o.pair),
+	}
+}
+
+func slashPair(pair generic.CurrencyPair) string {
+	return fmt.Sprintf("%s/%s", pair.Base, pair.Counter)
+}
+
+// Paper simulates order execution against market data fed through
+// UpdateBook and AddTrade, or Follow for live data.
+//
+// Market orders and the marketable part of limit orders are filled
+// against the last known order book, consuming its liquidity until the next
+// update. Resting limit orders are filled by trades at or through their
+// price, at most the traded amount, or when a book update crosses them.
+// Every fill is charged Fee and recorded as a transaction.
+type Paper struct {
+	// Fee is the trading fee percentage.
+	Fee float64
+
+	l            sync.Mutex
+	id           uint64
+	now          time.Time
+	available    map[generic.Currency]float64
+	reserved     map[generic.Currency]float64
+	books        map[generic.CurrencyPair]OrderBook
+	orders       []*paperOrder
+	transactions []Transaction
+}
+
+// NewPaper creates a simulated account with the given fee percentage and
+// available balances.
+func NewPaper(fee float64, balances map[generic.Currency]float64) *Paper {
+	p := &Paper{
+		Fee:       fee,
+		available: make(map[generic.Currency]float64),
+		reserved:  make(map[generic.Currency]float64),
+		books:     make(map[generic.CurrencyPair]OrderBook),
+	}
+	for c, v := range balances {
+		p.available[c] = v
+	}
+	return p
+}
+
+func (p *Paper) nextID() uint64 {
+	p.id++
+	return p.id
+}
+
+// time returns the time of the most recent market data.
+func (p *Paper) time() time.Time {
+	if p.now.IsZero() {
+		return time.Now()
+	}
+	return p.now
+}
+
+func (p *Paper) setTime(t time.Time) {
+	if t.After(p.now) {
+		p.now = t
+	}
+	p.expire()
+}
+
+// expire cancels daily orders placed before the current utc day.
+func (p *Paper) expire() {
+	y, m, d := p.now.UTC().Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	for _, o := range append([]*paperOrder{}, p.orders...) {
+		if o.daily && o.date.Before(day) {
+			p.cancel(o)
+		}
+	}
+}
+
+func (p *Paper) Deposit(c generic.Currency, amount float64) {
+	p.l.Lock()
+	p.available[c] += amount
+	p.l.Unlock()
+}
+
+// UpdateBook replaces the order book of pair and fills resting orders it
+// crosses.
+func (p *Paper) UpdateBook(pair generic.CurrencyPair, book OrderBook) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	book.Bids = append([]OrderBookLevel{}, book.Bids...)
+	book.Asks = append([]OrderBookLevel{}, book.Asks...)
+	p.books[pair] = book
+	p.setTime(book.Date)
+
+	for _, o := range p.sorted(pair) {
+		base, counter := p.take(pair, o.side, o.price, o.amount, false)
+		p.fillResting(o, base, counter)
+	}
+}
+
+// AddTrade fills resting orders of pair the trade went through.
+func (p *Paper) AddTrade(pair generic.CurrencyPair, t Trade) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.setTime(t.Date)
+
+	remaining := t.Amount
+	for _, o := range p.sorted(pair) {
+		if remaining <= paperEpsilon {
+			break
+		}
+		if !o.crosses(t.Price) {
+			continue
+		}
+		q := math.Min(remaining, o.amount)
+		remaining -= q
+		p.fillResting(o, q, q*o.price)
+	}
+}
+
+// Follow feeds the live order book and trades of pair until stop is
+// closed or an error occurs.
+func (p *Paper) Follow(b *Bitstamp, pair generic.CurrencyPair, stop <-chan struct{}) error {
+	book, err := b.OrderBook(pair)
+	if err != nil {
+		return err
+	}
+	p.UpdateBook(pair, book)
+
+	books := make(chan OrderBook, 1)
+	trades := make(chan Trade, 16)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		errs <- b.MarketLive(pair, trades, books, done)
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-errs:
+			return err
+		case book := <-books:
+			p.UpdateBook(pair, book)
+		case t := <-trades:
+			p.AddTrade(pair, t)
+		}
+	}
+}
+
+// sorted returns the resting orders of pair, best priced first.
+func (p *Paper) sorted(pair generic.CurrencyPair) []*paperOrder {
+	list := make([]*paperOrder, 0, len(p.orders))
+	for _, o := range p.orders {
+		if o.pair == pair {
+			list = append(list, o)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.side != b.side {
+			return a.side == api.Buy
+		}
+		if a.side == api.Buy {
+			return a.price > b.price
+		}
+		return a.price < b.price
+	})
+	return list
+}
+
+// take consumes liquidity from the book for a taker order. A limit of 0
+// means no limit, amount is in counter if inCounter is set.
+func (p *Paper) take(pair generic.CurrencyPair, side api.TradeType, limit, amount float64, inCounter bool) (base, counter float64) {
+	book := p.books[pair]
+	levels := &book.Asks
+	if side == api.Sell {
+		levels = &book.Bids
+	}
+
+	for len(*levels) != 0 {
+		l := &(*levels)[0]
+		remaining := amount - base
+		if inCounter {
+			remaining = amount - counter
+		}
+		if remaining <= paperEpsilon {
+			break
+		}
+		if limit != 0 && ((side == api.Buy && l.Price > limit) || (side == api.Sell && l.Price < limit)) {
+			break
+		}
+
+		q := math.Min(l.Amount, remaining)
+		if inCounter {
+			q = math.Min(l.Amount, remaining/l.Price)
+		}
+		base += q
+		counter += q * l.Price
+		l.Amount -= q
+		if l.Amount <= paperEpsilon {
+			*levels = (*levels)[1:]
+		}
+	}
+
+	p.books[pair] = book
+	return base, counter
+}
+
+// settle books a fill, reservePrice is the price the funds were reserved
+// at, 0 if they are taken from the available balance.
+func (p *Paper) settle(pair generic.CurrencyPair, side api.TradeType, base, counter, reservePrice float64, orderID uint64) {
+	fee := counter * p.Fee / 100
+	sign := 1.0
+	switch side {
+	case api.Buy:
+		if reservePrice != 0 {
+			p.release(pair.Counter, base*reservePrice*(1+p.Fee/100))
+		}
+		p.available[pair.Counter] -= counter + fee
+		p.available[pair.Base] += base
+	default:
+		sign = -1
+		if reservePrice != 0 {
+			p.release(pair.Base, base)
+		}
+		p.available[pair.Base] -= base
+		p.available[pair.Counter] += counter - fee
+	}
+
+	rate := counter / base
+	amounts := map[generic.Currency]float64{
+		pair.Base:    sign * base,
+		pair.Counter: -sign * counter,
+	}
+	p.transactions = append(p.transactions, newTransaction(api.Transaction{
+		DateTime: generic.UTCDateString(p.time()),
+		ID:       generic.Uint64String(p.nextID()),
+		Type:     api.MarketTrade,
+		OrderID:  generic.Uint64String(orderID),
+		Fee:      generic.Float64String(fee),
+		Amounts:  amounts,
+		Rates:    map[generic.CurrencyPair]float64{pair: rate},
+		Pair:     pair,
+		Rate:     rate,
+	}))
+}
+
+func (p *Paper) fillResting(o *paperOrder, base, counter float64) {
+	if base <= paperEpsilon {
+		return
+	}
+	p.settle(o.pair, o.side, base, counter, o.price, o.id)
+	o.amount -= base
+	if o.amount <= paperEpsilon {
+		p.remove(o)
+	}
+}
+
+func (p *Paper) remove(o *paperOrder) {
+	for i, n := range p.orders {
+		if n == o {
+			p.orders = append(p.orders[:i], p.orders[i+1:]...)
+			return
+		}
+	}
+}
+
+// cancel removes the order and releases its reserved funds.
+func (p *Paper) cancel(o *paperOrder) {
+	p.remove(o)
+	if o.side == api.Buy {
+		p.release(o.pair.Counter, o.amount*o.price*(1+p.Fee/100))
+		return
+	}
+	p.release(o.pair.Base, o.amount)
+}
+
+// reserve moves amount of c from available to reserved.
+func (p *Paper) reserve(c generic.Currency, amount float64) error {
+	if p.available[c] < amount-paperEpsilon {
+		return ErrInsufficientFunds
+	}
+	p.available[c] -= amount
+	p.reserved[c] += amount
+	return nil
+}
+
+// release moves amount of c from reserved back to available.
+func (p *Paper) release(c generic.Currency, amount float64) {
+	p.reserved[c] -= amount
+	p.available[c] += amount
+	if math.Abs(p.reserved[c]) < paperEpsilon*1e3 {
+		p.reserved[c] = 0
+	}
+}
+
+// Place simulates api.Place for api.LimitOrder and api.SimpleOrder values.
+func (p *Paper) Place(order api.Order) (api.OrderResponse, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	switch o := order.(type) {
+	case api.LimitOrder:
+		return p.placeLimit(o)
+	case api.SimpleOrder:
+		return p.placeSimple(o)
+	}
+	return api.OrderResponse{}, fmt.Errorf("unsupported order type %T", order)
+}
+
+func parseSide(action string) (api.TradeType, error) {
+	switch action {
+	case "buy":
+		return api.Buy, nil
+	case "sell":
+		return api.Sell, nil
+	}
+	return 0, fmt.Errorf("unknown order action '%s'", action)
+}
+
+func (p *Paper) placeSimple(o api.SimpleOrder) (api.OrderResponse, error) {
+	var r api.OrderResponse
+	side, err := parseSide(o.Action)
+	if err != nil {
+		return r, err
+	}
+	if o.Amount <= 0 {
+		return r, errors.New("Invalid amount")
+	}
+	inCounter := o.AmountCounter || (o.Type == "instant" && side == api.Buy)
+
+	book := p.books[o.Pair]
+	base, counter, _ := book.Fill(side, o.Amount, inCounter)
+	if base <= paperEpsilon {
+		return r, ErrNoLiquidity
+	}
+	if side == api.Buy && p.available[o.Pair.Counter] < counter*(1+p.Fee/100)-paperEpsilon {
+		return r, ErrInsufficientFunds
+	}
+	if side == api.Sell && p.available[o.Pair.Base] < base-paperEpsilon {
+		return r, ErrInsufficientFunds
+	}
+
+	id := p.nextID()
+	base, counter = p.take(o.Pair, side, 0, o.Amount, inCounter)
+	p.settle(o.Pair, side, base, counter, 0, id)
+	return api.OrderResponse{
+		ID:       generic.Uint64String(id),
+		DateTime: generic.UTCDateString(p.time()),
+		Type:     side,
+		Price:    generic.Float64String(counter / base),
+		Amount:   generic.Float64String(base),
+	}, nil
+}
+
+func (p *Paper) placeLimit(o api.LimitOrder) (api.OrderResponse, error) {
+	var r api.OrderResponse
+	side, err := parseSide(o.Action)
+	if err != nil {
+		return r, err
+	}
+	if o.Amount <= 0 {
+		return r, errors.New("Invalid amount")
+	}
+	if o.Price <= 0 {
+		return r, errors.New("Invalid price")
+	}
+
+	if o.FOK {
+		book := p.books[o.Pair]
+		levels := book.Asks
+		if side == api.Sell {
+			levels = book.Bids
+		}
+		var available float64
+		for _, l := range levels {
+			if (side == api.Buy && l.Price > o.Price) || (side == api.Sell && l.Price < o.Price) {
+				break
+			}
+			available += l.Amount
+		}
+		if available < o.Amount-paperEpsilon {
+			return r, ErrNotFilled
+		}
+	}
+
+	reserve, c := o.Amount, o.Pair.Base
+	if side == api.Buy {
+		reserve, c = o.Amount*o.Price*(1+p.Fee/100), o.Pair.Counter
+	}
+	if err := p.reserve(c, reserve); err != nil {
+		return r, err
+	}
+
+	po := &paperOrder{
+		id:     p.nextID(),
+		date:   p.time(),
+		pair:   o.Pair,
+		side:   side,
+		price:  o.Price,
+		amount: o.Amount,
+		daily:  o.Daily,
+	}
+	p.orders = append(p.orders, po)
+	base, counter := p.take(o.Pair, side, o.Price, o.Amount, false)
+	p.fillResting(po, base, counter)
+	if o.IOC && po.amount > paperEpsilon {
+		p.cancel(po)
+	}
+
+	return api.OrderResponse{
+		ID:       generic.Uint64String(po.id),
+		DateTime: generic.UTCDateString(po.date),
+		Type:     side,
+		Price:    generic.Float64String(o.Price),
+		Amount:   generic.Float64String(o.Amount),
+	}, nil
+}
+
+func (p *Paper) openOrders(all bool, pair generic.CurrencyPair) []api.OpenOrder {
+	p.l.Lock()
+	defer p.l.Unlock()
+	list := make([]api.OpenOrder, 0, len(p.orders))
+	for _, o := range p.orders {
+		if all || o.pair == pair {
+			list = append(list, o.open())
+		}
+	}
+	return list
+}
+
+func (p *Paper) OpenOrders(pair generic.CurrencyPair) ([]api.OpenOrder, error) {
+	return p.openOrders(false, pair), nil
+}
+
+func (p *Paper) OpenOrdersAll() ([]api.OpenOrder, error) {
+	return p.openOrders(true, generic.CurrencyPair{}), nil
+}
+
+func (p *Paper) Cancel(id uint64) (api.CanceledOrder, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	for _, o := range p.orders {
+		if o.id == id {
+			p.cancel(o)
+			return canceledOrder(o), nil
+		}
+	}
+	return api.CanceledOrder{}, ErrOrderNotFound
+}
+
+func (p *Paper) CancelAll() ([]api.CanceledOrder, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	list := make([]api.CanceledOrder, 0, len(p.orders))
+	for _, o := range append([]*paperOrder{}, p.orders...) {
+		p.cancel(o)
+		list = append(list, canceledOrder(o))
+	}
+	return list, nil
+}
+
+func canceledOrder(o *paperOrder) api.CanceledOrder {
+	return api.CanceledOrder{
+		ID:           generic.Uint64String(o.id),
+		Type:         o.side,
+		Price:        generic.Float64String(o.price),
+		Amount:       generic.Float64String(o.amount),
+		CurrencyPair: slashPair(o.pair),
+	}
+}
+
+func (p *Paper) Balances() (api.Balances, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	b := api.Balances{
+		Currencies: make(map[generic.Currency]api.CurrencyBalance),
+		Fees:       make(map[generic.CurrencyPair]float64),
+		Other:      make(api.Balance),
+	}
+	for _, pair := range AllPairs() {
+		b.Fees[pair] = p.Fee
+	}
+	for _, m := range []map[generic.Currency]float64{p.available, p.reserved} {
+		for c := range m {
+			b.Currencies[c] = api.CurrencyBalance{
+				Available: p.available[c],
+				Reserved:  p.reserved[c],
+				Total:     p.available[c] + p.reserved[c],
+			}
+		}
+	}
+	return b, nil
+}
+
+func (p *Paper) BalancesPair(pair generic.CurrencyPair) (api.Balances, error) {
+	b, err := p.Balances()
+	n := api.Balances{
+		Currencies: map[generic.Currency]api.CurrencyBalance{
+			pair.Base:    b.Currencies[pair.Base],
+			pair.Counter: b.Currencies[pair.Counter],
+		},
+		Fees:  map[generic.CurrencyPair]float64{pair: p.Fee},
+		Other: make(api.Balance),
+	}
+	return n, err
+}
+
+// Transactions returns every simulated fill in chronological order.
+func (p *Paper) Transactions() []Transaction {
+	p.l.Lock()
+	defer p.l.Unlock()
+	return append([]Transaction{}, p.transactions...)
+}
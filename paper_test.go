@@ -0,0 +1,80 @@
+package bitstamp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/bitstamptest"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/ws"
+)
+
+func TestPaperFollowStop(t *testing.T) {
+	s := bitstamptest.New()
+	defer s.Close()
+	pair := bitstamp.BTCUSD()
+	s.SetBook(pair, []bitstamptest.Level{{Price: 99, Amount: 1}}, []bitstamptest.Level{{Price: 101, Amount: 1}})
+	client, err := s.Client("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := bitstamp.NewPaper(0, map[generic.Currency]float64{bitstamp.USD: 1000})
+	if _, err := p.Place(api.NewLimitBuy(pair, 1, 90)); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() { errs <- p.Follow(client, pair, stop) }()
+
+	channel := ws.LiveTrades.ForCurrencyPair(pair)
+	for i := 0; s.Subscribers(channel) == 0; i++ {
+		if i == 200 {
+			t.Fatal("no subscription")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Trade(pair, 90, 1, api.Sell)
+	for i := 0; len(p.Transactions()) == 0; i++ {
+		if i == 200 {
+			t.Fatal("resting order not filled by a live trade")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Publish more than the feeds buffer without anyone reading them.
+	for i := 0; i < 64; i++ {
+		s.Trade(pair, 100, 0.1, api.Buy)
+	}
+	close(stop)
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Follow did not return after stop")
+	}
+
+	// The client must still serve other consumers, trades still in flight
+	// on the connection may arrive first.
+	trades := make(chan bitstamp.Trade, 1)
+	go client.TradesLive(api.TradesHistoryNone, pair, trades)
+	time.Sleep(50 * time.Millisecond)
+	s.Trade(pair, 123, 1, api.Buy)
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case tr := <-trades:
+			if tr.Price == 123 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("client blocked after Follow returned")
+		}
+	}
+}
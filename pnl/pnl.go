@@ -0,0 +1,301 @@
+// Package pnl computes cost basis and realized and unrealized profit and
+// loss by replaying account transactions.
+package pnl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+type Method byte
+
+const (
+	FIFO Method = iota
+	LIFO
+	Average
+)
+
+func (m Method) String() string {
+	switch m {
+	case FIFO:
+		return "fifo"
+	case LIFO:
+		return "lifo"
+	case Average:
+		return "avg"
+	}
+	return "unknown"
+}
+
+func ParseMethod(str string) (Method, error) {
+	switch strings.ToLower(str) {
+	case "fifo":
+		return FIFO, nil
+	case "lifo":
+		return LIFO, nil
+	case "avg", "average":
+		return Average, nil
+	}
+	return 0, fmt.Errorf("unknown cost basis method '%s', use fifo, lifo or avg", str)
+}
+
+// PriceFunc returns the price of c in the quote currency at time t.
+type PriceFunc func(c generic.Currency, t time.Time) (float64, error)
+
+// Lot is an acquired amount with its total cost in the quote currency.
+type Lot struct {
+	Date   time.Time
+	Amount float64
+	Cost   float64
+}
+
+// Disposal is a sale or trade of an asset, Lots are the (partial) lots it
+// consumed. Amounts not covered by any lot are disposed at zero cost and
+// reported as Uncovered.
+type Disposal struct {
+	Date          time.Time
+	TransactionID uint64
+	Currency      generic.Currency
+	Amount        float64
+	Proceeds      float64
+	Cost          float64
+	Lots          []Lot
+	Uncovered     float64
+}
+
+func (d Disposal) Gain() float64 { return d.Proceeds - d.Cost }
+
+// Acquired returns the date of the oldest consumed lot.
+func (d Disposal) Acquired() time.Time {
+	var t time.Time
+	for _, l := range d.Lots {
+		if t.IsZero() || l.Date.Before(t) {
+			t = l.Date
+		}
+	}
+	return t
+}
+
+type Position struct {
+	Currency generic.Currency
+	Amount   float64
+	Cost     float64
+	Realized float64
+	Lots     []Lot
+}
+
+// Unrealized returns the gain if the position were sold at price.
+func (p Position) Unrealized(price float64) float64 { return p.Amount*price - p.Cost }
+
+// Engine replays transactions in chronological order.
+//
+// Trades dispose of one currency and acquire the other at the value of the
+// trade in the quote currency, fees are added to the cost of a buy and
+// subtracted from the proceeds of a sale. Trades not against the quote
+// currency are valued using Price. Deposits and other incoming transfers
+// acquire at the market price, withdrawals and outgoing transfers remove
+// lots without realizing a gain. The quote currency itself is not tracked.
+type Engine struct {
+	Quote  generic.Currency
+	Method Method
+	Price  PriceFunc
+
+	lots      map[generic.Currency][]Lot
+	realized  map[generic.Currency]float64
+	disposals []Disposal
+	last      time.Time
+}
+
+func New(quote generic.Currency, method Method, price PriceFunc) *Engine {
+	return &Engine{
+		Quote:    quote,
+		Method:   method,
+		Price:    price,
+		lots:     make(map[generic.Currency][]Lot),
+		realized: make(map[generic.Currency]float64),
+	}
+}
+
+// dust is the amount below which lots are considered empty.
+const dust = 1e-12
+
+// Add replays a single transaction, transactions must be added in
+// chronological order.
+func (e *Engine) Add(t bitstamp.Transaction) error {
+	date := t.DateTime.Value()
+	if date.Before(e.last) {
+		return fmt.Errorf("transaction %d is older than the previous one", t.ID)
+	}
+	e.last = date
+
+	switch t.Type {
+	case api.MarketTrade:
+		return e.trade(t)
+	case api.Deposit, api.Withdrawal, api.SubAccountTransfer, api.InterAccountTransfer,
+		api.StakingReward, api.ReferralReward:
+		for c, v := range t.Values {
+			if c == e.Quote || v == 0 {
+				continue
+			}
+			if v < 0 {
+				e.take(c, -v)
+				continue
+			}
+			price, err := e.price(c, date)
+			if err != nil {
+				return fmt.Errorf("transaction %d: %w", t.ID, err)
+			}
+			e.acquire(c, date, v, v*price)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) price(c generic.Currency, t time.Time) (float64, error) {
+	if c == e.Quote {
+		return 1, nil
+	}
+	if e.Price == nil {
+		return 0, fmt.Errorf("no price for %s in %s", c, e.Quote)
+	}
+	return e.Price(c, t)
+}
+
+func (e *Engine) trade(t bitstamp.Transaction) error {
+	pair := t.Pair
+	base, counter := t.Values[pair.Base], t.Values[pair.Counter]
+	if pair.Base == "" || base == 0 {
+		return nil
+	}
+	fee := t.Fee.Value()
+	date := t.DateTime.Value()
+
+	// Fees are paid in the counter currency.
+	inC, inAmount := pair.Base, base
+	outC, outAmount := pair.Counter, -counter+fee
+	value := outAmount
+	if base < 0 {
+		inC, inAmount = pair.Counter, counter-fee
+		outC, outAmount = pair.Base, -base
+		value = inAmount
+	}
+
+	price, err := e.price(pair.Counter, date)
+	if err != nil {
+		return fmt.Errorf("transaction %d: %w", t.ID, err)
+	}
+	value *= price
+
+	if outC != e.Quote {
+		e.dispose(outC, date, t.ID.Value(), outAmount, value)
+	}
+	if inC != e.Quote {
+		e.acquire(inC, date, inAmount, value)
+	}
+	return nil
+}
+
+func (e *Engine) acquire(c generic.Currency, date time.Time, amount, cost float64) {
+	lots := e.lots[c]
+	if e.Method == Average && len(lots) != 0 {
+		lots[0].Amount += amount
+		lots[0].Cost += cost
+		return
+	}
+	e.lots[c] = append(lots, Lot{Date: date, Amount: amount, Cost: cost})
+}
+
+// take removes amount from the lots of c according to the method.
+func (e *Engine) take(c generic.Currency, amount float64) ([]Lot, float64) {
+	lots := e.lots[c]
+	taken := make([]Lot, 0, 1)
+	for amount > dust && len(lots) != 0 {
+		ix := 0
+		if e.Method == LIFO {
+			ix = len(lots) - 1
+		}
+		l := lots[ix]
+		n := math.Min(amount, l.Amount)
+		cost := l.Cost * n / l.Amount
+		taken = append(taken, Lot{Date: l.Date, Amount: n, Cost: cost})
+		amount -= n
+		l.Amount -= n
+		l.Cost -= cost
+
+		if l.Amount > dust {
+			lots[ix] = l
+			continue
+		}
+		if e.Method == LIFO {
+			lots = lots[:ix]
+			continue
+		}
+		lots = lots[1:]
+	}
+	e.lots[c] = lots
+	if amount < dust {
+		amount = 0
+	}
+	return taken, amount
+}
+
+func (e *Engine) dispose(c generic.Currency, date time.Time, id uint64, amount, proceeds float64) {
+	lots, uncovered := e.take(c, amount)
+	d := Disposal{
+		Date:          date,
+		TransactionID: id,
+		Currency:      c,
+		Amount:        amount,
+		Proceeds:      proceeds,
+		Lots:          lots,
+		Uncovered:     uncovered,
+	}
+	for _, l := range lots {
+		d.Cost += l.Cost
+	}
+	e.realized[c] += d.Gain()
+	e.disposals = append(e.disposals, d)
+}
+
+// Disposals returns all disposals in chronological order.
+func (e *Engine) Disposals() []Disposal { return e.disposals }
+
+// Realized returns the sum of all realized gains.
+func (e *Engine) Realized() float64 {
+	var n float64
+	for _, v := range e.realized {
+		n += v
+	}
+	return n
+}
+
+// Positions returns the remaining position and realized gain of every
+// currency ever held, sorted by currency.
+func (e *Engine) Positions() []Position {
+	seen := make(map[generic.Currency]struct{})
+	for c := range e.lots {
+		seen[c] = struct{}{}
+	}
+	for c := range e.realized {
+		seen[c] = struct{}{}
+	}
+
+	list := make([]Position, 0, len(seen))
+	for c := range seen {
+		p := Position{Currency: c, Realized: e.realized[c], Lots: append([]Lot{}, e.lots[c]...)}
+		for _, l := range p.Lots {
+			p.Amount += l.Amount
+			p.Cost += l.Cost
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return list
+}
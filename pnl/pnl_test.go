@@ -0,0 +1,235 @@
+package pnl
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+var start = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func day(n int) time.Time { return start.AddDate(0, 0, n) }
+
+func tx(id uint64, d int, typ api.TransactionType, pair generic.CurrencyPair, fee float64, values map[generic.Currency]float64) bitstamp.Transaction {
+	return bitstamp.Transaction{
+		Transaction: api.Transaction{
+			DateTime: generic.UTCDateString(day(d)),
+			ID:       generic.Uint64String(id),
+			Type:     typ,
+			Fee:      generic.Float64String(fee),
+			Pair:     pair,
+		},
+		Values: values,
+	}
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+type expLot struct {
+	day    int
+	amount float64
+	cost   float64
+}
+
+func checkLots(t *testing.T, name string, got []Lot, exp []expLot) {
+	t.Helper()
+	if len(got) != len(exp) {
+		t.Fatalf("%s: %d lots %+v, expected %d", name, len(got), got, len(exp))
+	}
+	for i, l := range got {
+		e := exp[i]
+		if !l.Date.Equal(day(e.day)) || !near(l.Amount, e.amount) || !near(l.Cost, e.cost) {
+			t.Errorf("%s: lot %d is %s %g %g, expected %s %g %g", name, i, l.Date, l.Amount, l.Cost, day(e.day), e.amount, e.cost)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	pair := bitstamp.BTCUSD()
+	list := []bitstamp.Transaction{
+		tx(1, 0, api.MarketTrade, pair, 1, map[generic.Currency]float64{bitstamp.BTC: 1, bitstamp.USD: -100}),
+		tx(2, 1, api.MarketTrade, pair, 2, map[generic.Currency]float64{bitstamp.BTC: 1, bitstamp.USD: -200}),
+		tx(3, 2, api.MarketTrade, pair, 3, map[generic.Currency]float64{bitstamp.BTC: -1.5, bitstamp.USD: 450}),
+		// Withdrawals remove lots without realizing a gain.
+		tx(4, 3, api.Withdrawal, generic.CurrencyPair{}, 0, map[generic.Currency]float64{bitstamp.BTC: -0.25}),
+		// Only 0.25 is left, the rest is disposed at zero cost.
+		tx(5, 4, api.MarketTrade, pair, 0, map[generic.Currency]float64{bitstamp.BTC: -1, bitstamp.USD: 400}),
+	}
+
+	type disposal struct {
+		cost      float64
+		lots      []expLot
+		uncovered float64
+	}
+	tests := []struct {
+		method    Method
+		afterSale []expLot
+		afterWd   []expLot
+		disposals []disposal
+		realized  float64
+	}{
+		{
+			FIFO,
+			[]expLot{{1, 0.5, 101}},
+			[]expLot{{1, 0.25, 50.5}},
+			[]disposal{
+				{202, []expLot{{0, 1, 101}, {1, 0.5, 101}}, 0},
+				{50.5, []expLot{{1, 0.25, 50.5}}, 0.75},
+			},
+			245 + 349.5,
+		},
+		{
+			LIFO,
+			[]expLot{{0, 0.5, 50.5}},
+			[]expLot{{0, 0.25, 25.25}},
+			[]disposal{
+				{252.5, []expLot{{1, 1, 202}, {0, 0.5, 50.5}}, 0},
+				{25.25, []expLot{{0, 0.25, 25.25}}, 0.75},
+			},
+			194.5 + 374.75,
+		},
+		{
+			Average,
+			[]expLot{{0, 0.5, 75.75}},
+			[]expLot{{0, 0.25, 37.875}},
+			[]disposal{
+				{227.25, []expLot{{0, 1.5, 227.25}}, 0},
+				{37.875, []expLot{{0, 0.25, 37.875}}, 0.75},
+			},
+			219.75 + 362.125,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method.String(), func(t *testing.T) {
+			e := New(bitstamp.USD, test.method, nil)
+			for i, tr := range list {
+				if err := e.Add(tr); err != nil {
+					t.Fatal(err)
+				}
+				switch i {
+				case 2:
+					checkLots(t, "after sale", e.lots[bitstamp.BTC], test.afterSale)
+				case 3:
+					checkLots(t, "after withdrawal", e.lots[bitstamp.BTC], test.afterWd)
+					if !near(e.Realized(), 447-test.disposals[0].cost) {
+						t.Errorf("withdrawal realized a gain: %g", e.Realized())
+					}
+				}
+			}
+
+			ds := e.Disposals()
+			if len(ds) != 2 {
+				t.Fatalf("%d disposals, expected 2", len(ds))
+			}
+			proceeds := []float64{447, 400}
+			amounts := []float64{1.5, 1}
+			for i, d := range ds {
+				exp := test.disposals[i]
+				if d.Currency != bitstamp.BTC || d.TransactionID != uint64(i*2+3) || !d.Date.Equal(day(i*2+2)) {
+					t.Errorf("disposal %d: %s %d %s", i, d.Currency, d.TransactionID, d.Date)
+				}
+				if !near(d.Amount, amounts[i]) || !near(d.Proceeds, proceeds[i]) || !near(d.Cost, exp.cost) {
+					t.Errorf("disposal %d: amount %g proceeds %g cost %g, expected %g %g %g", i, d.Amount, d.Proceeds, d.Cost, amounts[i], proceeds[i], exp.cost)
+				}
+				if !near(d.Gain(), proceeds[i]-exp.cost) {
+					t.Errorf("disposal %d: gain %g", i, d.Gain())
+				}
+				if !near(d.Uncovered, exp.uncovered) {
+					t.Errorf("disposal %d: uncovered %g, expected %g", i, d.Uncovered, exp.uncovered)
+				}
+				checkLots(t, "disposal", d.Lots, exp.lots)
+			}
+
+			if !near(e.Realized(), test.realized) {
+				t.Errorf("realized %g, expected %g", e.Realized(), test.realized)
+			}
+			pos := e.Positions()
+			if len(pos) != 1 || pos[0].Currency != bitstamp.BTC {
+				t.Fatalf("positions %+v", pos)
+			}
+			if !near(pos[0].Amount, 0) || !near(pos[0].Cost, 0) || !near(pos[0].Realized, test.realized) {
+				t.Errorf("position %+v", pos[0])
+			}
+		})
+	}
+}
+
+func TestPrice(t *testing.T) {
+	prices := map[generic.Currency]float64{bitstamp.BTC: 300, bitstamp.ETH: 20}
+	price := func(c generic.Currency, at time.Time) (float64, error) {
+		p, ok := prices[c]
+		if !ok {
+			return 0, errors.New("no price")
+		}
+		return p, nil
+	}
+	pair := bitstamp.ETHBTC()
+	list := []bitstamp.Transaction{
+		tx(1, 0, api.MarketTrade, bitstamp.BTCUSD(), 0, map[generic.Currency]float64{bitstamp.BTC: 1, bitstamp.USD: -100}),
+		// 0.51 btc, including the fee, worth 153 usd for 10 eth.
+		tx(2, 1, api.MarketTrade, pair, 0.01, map[generic.Currency]float64{bitstamp.ETH: 10, bitstamp.BTC: -0.5}),
+		// 5 eth for 0.297 btc after fees, worth 89.1 usd.
+		tx(3, 2, api.MarketTrade, pair, 0.003, map[generic.Currency]float64{bitstamp.ETH: -5, bitstamp.BTC: 0.3}),
+		// Deposits are acquired at the market price.
+		tx(4, 3, api.Deposit, generic.CurrencyPair{}, 0, map[generic.Currency]float64{bitstamp.ETH: 1}),
+	}
+
+	e := New(bitstamp.USD, FIFO, price)
+	for _, tr := range list {
+		if err := e.Add(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ds := e.Disposals()
+	if len(ds) != 2 {
+		t.Fatalf("%d disposals, expected 2", len(ds))
+	}
+	if d := ds[0]; d.Currency != bitstamp.BTC || !near(d.Amount, 0.51) || !near(d.Proceeds, 153) || !near(d.Cost, 51) {
+		t.Errorf("btc disposal %+v", d)
+	}
+	if d := ds[1]; d.Currency != bitstamp.ETH || !near(d.Amount, 5) || !near(d.Proceeds, 89.1) || !near(d.Cost, 76.5) {
+		t.Errorf("eth disposal %+v", d)
+	}
+	if !near(e.Realized(), 102+12.6) {
+		t.Errorf("realized %g, expected %g", e.Realized(), 102+12.6)
+	}
+
+	pos := e.Positions()
+	if len(pos) != 2 || pos[0].Currency != bitstamp.BTC || pos[1].Currency != bitstamp.ETH {
+		t.Fatalf("positions %+v", pos)
+	}
+	checkLots(t, "btc", pos[0].Lots, []expLot{{0, 0.49, 49}, {2, 0.297, 89.1}})
+	checkLots(t, "eth", pos[1].Lots, []expLot{{1, 5, 76.5}, {3, 1, 20}})
+	if !near(pos[0].Realized, 102) || !near(pos[1].Realized, 12.6) {
+		t.Errorf("realized btc %g eth %g", pos[0].Realized, pos[1].Realized)
+	}
+	if !near(pos[1].Unrealized(20), 6*20-96.5) {
+		t.Errorf("unrealized eth %g", pos[1].Unrealized(20))
+	}
+
+	e = New(bitstamp.USD, FIFO, nil)
+	if err := e.Add(list[1]); err == nil {
+		t.Error("trade without quote valued without a price func")
+	}
+}
+
+func TestOrder(t *testing.T) {
+	pair := bitstamp.BTCUSD()
+	e := New(bitstamp.USD, FIFO, nil)
+	if err := e.Add(tx(1, 1, api.MarketTrade, pair, 0, map[generic.Currency]float64{bitstamp.BTC: 1, bitstamp.USD: -100})); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(tx(2, 1, api.MarketTrade, pair, 0, map[generic.Currency]float64{bitstamp.BTC: 1, bitstamp.USD: -100})); err != nil {
+		t.Errorf("transaction at the same time rejected: %s", err)
+	}
+	if err := e.Add(tx(3, 0, api.MarketTrade, pair, 0, map[generic.Currency]float64{bitstamp.BTC: 1, bitstamp.USD: -100})); err == nil {
+		t.Error("older transaction accepted")
+	}
+}
@@ -0,0 +1,182 @@
+package bitstamp
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+// RouteStep converts along Pair, from base to counter or from counter to
+// base if Inverse is set.
+type RouteStep struct {
+	Pair    generic.CurrencyPair
+	Inverse bool
+}
+
+// Route finds the shortest chain of known pairs to convert from into to.
+func Route(from, to generic.Currency) ([]RouteStep, bool) {
+	return route(from, to, nil)
+}
+
+func route(from, to generic.Currency, exclude map[generic.CurrencyPair]struct{}) ([]RouteStep, bool) {
+	if from == to {
+		return nil, true
+	}
+
+	type node struct {
+		prev generic.Currency
+		step RouteStep
+	}
+	visited := map[generic.Currency]node{from: {}}
+	queue := []generic.Currency{from}
+	for len(queue) != 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, p := range AllPairs() {
+			if _, ok := exclude[p]; ok {
+				continue
+			}
+			next, step := p.Counter, RouteStep{Pair: p}
+			switch c {
+			case p.Base:
+			case p.Counter:
+				next, step.Inverse = p.Base, true
+			default:
+				continue
+			}
+			if _, ok := visited[next]; ok {
+				continue
+			}
+			visited[next] = node{c, step}
+			if next != to {
+				queue = append(queue, next)
+				continue
+			}
+
+			route := make([]RouteStep, 0, 2)
+			for n := next; n != from; n = visited[n].prev {
+				route = append([]RouteStep{visited[n].step}, route...)
+			}
+			return route, true
+		}
+	}
+
+	return nil, false
+}
+
+type Holding struct {
+	Currency generic.Currency
+	Amount   float64
+	// Price and Value are expressed in the portfolio's quote currency.
+	Price float64
+	Value float64
+	// Change is the price change over the last 24 hours in percent.
+	Change float64
+	// Allocation is the share of the total value in percent.
+	Allocation float64
+
+	Route  []RouteStep
+	Priced bool
+}
+
+type Portfolio struct {
+	Quote    generic.Currency
+	Holdings []Holding
+	Value    float64
+	// Change is the change in value over the last 24 hours in percent had
+	// the current amounts been held.
+	Change float64
+}
+
+// Portfolio values the non-zero amounts in quote using daily tickers,
+// routing through intermediate pairs if needed. Pairs without a ticker or
+// recent trades are avoided. Currencies that can not be converted to quote
+// are listed but not priced. Holdings are sorted by value.
+func (b *Bitstamp) Portfolio(amounts map[generic.Currency]float64, quote generic.Currency) (Portfolio, error) {
+	p := Portfolio{Quote: quote, Holdings: make([]Holding, 0, len(amounts))}
+	tickers := make(map[generic.CurrencyPair]api.TickerResult)
+	unusable := make(map[generic.CurrencyPair]struct{})
+	ticker := func(pair generic.CurrencyPair) (api.TickerResult, bool, error) {
+		if t, ok := tickers[pair]; ok {
+			return t, true, nil
+		}
+		t, err := b.API.TickerDaily(pair)
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return t, false, err
+		}
+		if err != nil || t.Last.Value() == 0 || t.Open24.Value() == 0 {
+			unusable[pair] = struct{}{}
+			return t, false, nil
+		}
+		tickers[pair] = t
+		return t, true, nil
+	}
+
+	// price converts along the route, ok is false if any of its pairs
+	// turned out to be unusable.
+	price := func(route []RouteStep) (last, open float64, ok bool, err error) {
+		last, open = 1, 1
+		for _, s := range route {
+			t, ok, err := ticker(s.Pair)
+			if !ok || err != nil {
+				return 0, 0, false, err
+			}
+			l, o := t.Last.Value(), t.Open24.Value()
+			if s.Inverse {
+				l, o = 1/l, 1/o
+			}
+			last *= l
+			open *= o
+		}
+		return last, open, true, nil
+	}
+
+	var open float64
+	for c, amount := range amounts {
+		if amount == 0 {
+			continue
+		}
+		h := Holding{Currency: c, Amount: amount}
+		for !h.Priced {
+			r, ok := route(c, quote, unusable)
+			if !ok {
+				break
+			}
+			last, o, ok, err := price(r)
+			if err != nil {
+				return p, err
+			}
+			if !ok {
+				continue
+			}
+
+			h.Route, h.Priced = r, true
+			h.Price, h.Value = last, last*amount
+			h.Change = (last/o - 1) * 100
+			p.Value += h.Value
+			open += o * amount
+		}
+		p.Holdings = append(p.Holdings, h)
+	}
+
+	if open != 0 {
+		p.Change = (p.Value/open - 1) * 100
+	}
+	for i := range p.Holdings {
+		if p.Value != 0 {
+			p.Holdings[i].Allocation = p.Holdings[i].Value / p.Value * 100
+		}
+	}
+	sort.SliceStable(p.Holdings, func(i, j int) bool {
+		if p.Holdings[i].Value != p.Holdings[j].Value {
+			return p.Holdings[i].Value > p.Holdings[j].Value
+		}
+		return p.Holdings[i].Currency < p.Holdings[j].Currency
+	})
+
+	return p, nil
+}
@@ -0,0 +1,89 @@
+package bitstamp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+const (
+	priceStep   = 24 * time.Hour
+	priceWindow = priceStep * api.OHLCLimit
+)
+
+// PriceHistory looks up historical prices using the close of daily
+// candles, routing through intermediate pairs if needed.
+type PriceHistory struct {
+	api *api.API
+
+	l       sync.Mutex
+	windows map[priceWindowKey][]api.OHLC
+}
+
+type priceWindowKey struct {
+	pair generic.CurrencyPair
+	ix   int64
+}
+
+func (b *Bitstamp) PriceHistory() *PriceHistory {
+	return &PriceHistory{api: b.API, windows: make(map[priceWindowKey][]api.OHLC)}
+}
+
+// Price returns the price of c in quote at time t, being the close of the
+// daily candle t falls in or the last one before it.
+func (p *PriceHistory) Price(c, quote generic.Currency, t time.Time) (float64, error) {
+	route, ok := Route(c, quote)
+	if !ok {
+		return 0, fmt.Errorf("no route from %s to %s", c, quote)
+	}
+	price := 1.0
+	for _, s := range route {
+		v, err := p.close(s.Pair, t)
+		if err != nil {
+			return 0, err
+		}
+		if s.Inverse {
+			v = 1 / v
+		}
+		price *= v
+	}
+	return price, nil
+}
+
+func (p *PriceHistory) close(pair generic.CurrencyPair, t time.Time) (float64, error) {
+	ix := t.Unix() / int64(priceWindow/time.Second)
+	for i := ix; i >= ix-1; i-- {
+		list, err := p.window(priceWindowKey{pair, i})
+		if err != nil {
+			return 0, err
+		}
+		n := sort.Search(len(list), func(j int) bool { return list[j].Time.Value().After(t) })
+		if n != 0 {
+			return list[n-1].Close.Value(), nil
+		}
+	}
+	return 0, fmt.Errorf("no %s price available at %s", pair, t.Format(time.RFC3339))
+}
+
+func (p *PriceHistory) window(key priceWindowKey) ([]api.OHLC, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if list, ok := p.windows[key]; ok {
+		return list, nil
+	}
+
+	start := time.Unix(key.ix*int64(priceWindow/time.Second), 0)
+	r, err := p.api.OHLC(key.pair, priceStep, api.OHLCLimit, start, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(r.List, func(i, j int) bool {
+		return r.List[i].Time.Value().Before(r.List[j].Time.Value())
+	})
+	p.windows[key] = r.List
+	return r.List, nil
+}
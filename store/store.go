@@ -0,0 +1,366 @@
+// Package store persists trades in an append-only file per currency pair,
+// indexed by trade id and time. Pair files are locked while written so
+// multiple processes can share a store, trades added by other processes
+// are picked up on the next access.
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+const (
+	header     = "bitstamp-trades-1\n"
+	recordSize = 8 + 8 + 8 + 8 + 1
+)
+
+var ErrInvalidFile = errors.New("invalid trade store file")
+
+type Store struct {
+	dir string
+
+	l     sync.Mutex
+	pairs map[generic.CurrencyPair]*pairStore
+}
+
+var _ bitstamp.TradeStore = &Store{}
+
+// Open opens or creates a store in the given directory.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, pairs: make(map[generic.CurrencyPair]*pairStore)}, nil
+}
+
+func (s *Store) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	var err error
+	for p, ps := range s.pairs {
+		if e := ps.f.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(s.pairs, p)
+	}
+	return err
+}
+
+func (s *Store) pair(pair generic.CurrencyPair) (*pairStore, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if ps, ok := s.pairs[pair]; ok {
+		return ps, nil
+	}
+
+	ps, err := openPair(filepath.Join(s.dir, pair.String()+".trades"))
+	if err != nil {
+		return nil, err
+	}
+	s.pairs[pair] = ps
+	return ps, nil
+}
+
+// Add stores the given trades, trades with an id that is already stored
+// are ignored. Returns the newly stored trades.
+func (s *Store) Add(pair generic.CurrencyPair, trades ...bitstamp.Trade) ([]bitstamp.Trade, error) {
+	ps, err := s.pair(pair)
+	if err != nil {
+		return nil, err
+	}
+	return ps.add(trades)
+}
+
+// Range returns all trades in [from, until) ordered by time.
+func (s *Store) Range(pair generic.CurrencyPair, from, until time.Time) ([]bitstamp.Trade, error) {
+	ps, err := s.pair(pair)
+	if err != nil {
+		return nil, err
+	}
+	return ps.rng(from, until)
+}
+
+// Last returns the most recent trade.
+func (s *Store) Last(pair generic.CurrencyPair) (bitstamp.Trade, bool, error) {
+	ps, err := s.pair(pair)
+	if err != nil {
+		return bitstamp.Trade{}, false, err
+	}
+	return ps.last()
+}
+
+// Has reports whether a trade with the given id is stored.
+func (s *Store) Has(pair generic.CurrencyPair, id uint64) (bool, error) {
+	ps, err := s.pair(pair)
+	if err != nil {
+		return false, err
+	}
+	ps.l.Lock()
+	defer ps.l.Unlock()
+	if err := ps.sync(); err != nil {
+		return false, err
+	}
+	_, ok := ps.ids[id]
+	return ok, nil
+}
+
+// Count returns the amount of stored trades.
+func (s *Store) Count(pair generic.CurrencyPair) (int, error) {
+	ps, err := s.pair(pair)
+	if err != nil {
+		return 0, err
+	}
+	ps.l.Lock()
+	defer ps.l.Unlock()
+	if err := ps.sync(); err != nil {
+		return 0, err
+	}
+	return len(ps.index), nil
+}
+
+type entry struct {
+	time int64
+	id   uint64
+	off  int64
+}
+
+type pairStore struct {
+	l     sync.Mutex
+	f     *os.File
+	size  int64
+	ids   map[uint64]struct{}
+	index []entry
+}
+
+func openPair(path string) (*pairStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &pairStore{f: f, ids: make(map[uint64]struct{})}
+	if err := ps.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return ps, nil
+}
+
+func (ps *pairStore) load() error {
+	if err := lockFile(ps.f, true); err != nil {
+		return err
+	}
+	defer unlockFile(ps.f)
+
+	stat, err := ps.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() == 0 {
+		if _, err := ps.f.WriteString(header); err != nil {
+			return err
+		}
+		ps.size = int64(len(header))
+		return nil
+	}
+
+	h := make([]byte, len(header))
+	if _, err := ps.f.ReadAt(h, 0); err != nil || string(h) != header {
+		return ErrInvalidFile
+	}
+
+	ps.size = int64(len(header))
+	if err := ps.refresh(); err != nil {
+		return err
+	}
+
+	// Drop a partially written record.
+	if ps.size != stat.Size() {
+		return ps.f.Truncate(ps.size)
+	}
+	return nil
+}
+
+// refresh indexes the records appended since the last refresh, e.g. by
+// another process. The caller must hold the file lock.
+func (ps *pairStore) refresh() error {
+	stat, err := ps.f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size()-ps.size < recordSize {
+		return nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(ps.f, ps.size, stat.Size()-ps.size))
+	rec := make([]byte, recordSize)
+	var added bool
+	for ps.size+recordSize <= stat.Size() {
+		if _, err := io.ReadFull(r, rec); err != nil {
+			return err
+		}
+		t := decode(rec)
+		if _, ok := ps.ids[t.ID]; !ok {
+			ps.ids[t.ID] = struct{}{}
+			ps.index = append(ps.index, entry{t.Date.UnixNano(), t.ID, ps.size})
+			added = true
+		}
+		ps.size += recordSize
+	}
+
+	if added {
+		sort.SliceStable(ps.index, func(i, j int) bool { return ps.index[i].less(ps.index[j]) })
+	}
+	return nil
+}
+
+// sync refreshes the index under a shared file lock.
+func (ps *pairStore) sync() error {
+	if err := lockFile(ps.f, false); err != nil {
+		return err
+	}
+	defer unlockFile(ps.f)
+	return ps.refresh()
+}
+
+func (e entry) less(o entry) bool {
+	if e.time == o.time {
+		return e.id < o.id
+	}
+	return e.time < o.time
+}
+
+func (ps *pairStore) add(trades []bitstamp.Trade) ([]bitstamp.Trade, error) {
+	ps.l.Lock()
+	defer ps.l.Unlock()
+	if err := lockFile(ps.f, true); err != nil {
+		return nil, err
+	}
+	defer unlockFile(ps.f)
+	// A partially written record left by a crashed process is overwritten
+	// as ps.size only covers complete records.
+	if err := ps.refresh(); err != nil {
+		return nil, err
+	}
+
+	n := make([]bitstamp.Trade, 0, len(trades))
+	buf := make([]byte, 0, len(trades)*recordSize)
+	for _, t := range trades {
+		if _, ok := ps.ids[t.ID]; ok {
+			continue
+		}
+		ps.ids[t.ID] = struct{}{}
+		buf = append(buf, encode(t)...)
+		n = append(n, t)
+	}
+
+	if len(n) == 0 {
+		return n, nil
+	}
+
+	if _, err := ps.f.WriteAt(buf, ps.size); err != nil {
+		for _, t := range n {
+			delete(ps.ids, t.ID)
+		}
+		return nil, err
+	}
+
+	for i, t := range n {
+		e := entry{t.Date.UnixNano(), t.ID, ps.size + int64(i*recordSize)}
+		ix := sort.Search(len(ps.index), func(i int) bool { return e.less(ps.index[i]) })
+		ps.index = append(ps.index, entry{})
+		copy(ps.index[ix+1:], ps.index[ix:])
+		ps.index[ix] = e
+	}
+	ps.size += int64(len(buf))
+
+	return n, nil
+}
+
+func (ps *pairStore) read(e entry) (bitstamp.Trade, error) {
+	rec := make([]byte, recordSize)
+	if _, err := ps.f.ReadAt(rec, e.off); err != nil {
+		return bitstamp.Trade{}, err
+	}
+	return decode(rec), nil
+}
+
+func (ps *pairStore) rng(from, until time.Time) ([]bitstamp.Trade, error) {
+	ps.l.Lock()
+	defer ps.l.Unlock()
+	if err := ps.sync(); err != nil {
+		return nil, err
+	}
+
+	start, end := 0, len(ps.index)
+	if !from.IsZero() {
+		f := from.UnixNano()
+		start = sort.Search(len(ps.index), func(i int) bool { return ps.index[i].time >= f })
+	}
+	if !until.IsZero() {
+		u := until.UnixNano()
+		end = sort.Search(len(ps.index), func(i int) bool { return ps.index[i].time >= u })
+	}
+	if end < start {
+		end = start
+	}
+
+	list := make([]bitstamp.Trade, 0, end-start)
+	for _, e := range ps.index[start:end] {
+		t, err := ps.read(e)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, t)
+	}
+
+	return list, nil
+}
+
+func (ps *pairStore) last() (bitstamp.Trade, bool, error) {
+	ps.l.Lock()
+	defer ps.l.Unlock()
+	if err := ps.sync(); err != nil {
+		return bitstamp.Trade{}, false, err
+	}
+	if len(ps.index) == 0 {
+		return bitstamp.Trade{}, false, nil
+	}
+	t, err := ps.read(ps.index[len(ps.index)-1])
+	return t, err == nil, err
+}
+
+func encode(t bitstamp.Trade) []byte {
+	b := make([]byte, recordSize)
+	binary.LittleEndian.PutUint64(b[0:], t.ID)
+	binary.LittleEndian.PutUint64(b[8:], uint64(t.Date.UnixNano()))
+	binary.LittleEndian.PutUint64(b[16:], math.Float64bits(t.Price))
+	binary.LittleEndian.PutUint64(b[24:], math.Float64bits(t.Amount))
+	b[32] = byte(t.Type)
+	return b
+}
+
+func decode(b []byte) bitstamp.Trade {
+	return bitstamp.Trade{
+		ID:     binary.LittleEndian.Uint64(b[0:]),
+		Date:   time.Unix(0, int64(binary.LittleEndian.Uint64(b[8:]))),
+		Price:  math.Float64frombits(binary.LittleEndian.Uint64(b[16:])),
+		Amount: math.Float64frombits(binary.LittleEndian.Uint64(b[24:])),
+		Type:   api.TradeType(b[32]),
+	}
+}
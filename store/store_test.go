@@ -0,0 +1,76 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+)
+
+func TestShared(t *testing.T) {
+	dir := t.TempDir()
+	pair := bitstamp.BTCUSD()
+	start := time.Unix(1600000000, 0)
+
+	stores := make([]*Store, 2)
+	for i := range stores {
+		s, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		stores[i] = s
+	}
+
+	// Both stores add every trade, as live processes sharing a store do.
+	const n = 500
+	var wg sync.WaitGroup
+	for _, s := range stores {
+		wg.Add(1)
+		go func(s *Store) {
+			defer wg.Done()
+			for i := 1; i <= n; i++ {
+				tr := bitstamp.Trade{ID: uint64(i), Date: start.Add(time.Duration(i) * time.Second), Price: float64(i)}
+				if _, err := s.Add(pair, tr); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	check := func(name string, s *Store) {
+		list, err := s.Range(pair, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != n {
+			t.Fatalf("%s: %d trades, expected %d", name, len(list), n)
+		}
+		for i, tr := range list {
+			if tr.ID != uint64(i+1) || tr.Price != float64(i+1) {
+				t.Fatalf("%s: trade %d is %+v", name, i, tr)
+			}
+		}
+	}
+	check("first", stores[0])
+	check("second", stores[1])
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check("reopened", s)
+
+	tr := bitstamp.Trade{ID: n + 1, Date: start.Add(time.Hour), Price: 1}
+	if _, err := stores[0].Add(pair, tr); err != nil {
+		t.Fatal(err)
+	}
+	last, ok, err := stores[1].Last(pair)
+	if err != nil || !ok || last.ID != tr.ID {
+		t.Errorf("last trade of other store %+v %t %v, expected id %d", last, ok, err, tr.ID)
+	}
+}
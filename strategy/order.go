@@ -0,0 +1,132 @@
+package strategy
+
+import (
+	"sort"
+	"time"
+
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+type OrderState byte
+
+const (
+	// OrderOpen orders were accepted but not filled yet.
+	OrderOpen OrderState = iota
+	OrderPartial
+	OrderFilled
+	// OrderCanceled orders were canceled, expired or removed by the
+	// exchange before being filled completely.
+	OrderCanceled
+)
+
+func (s OrderState) String() string {
+	switch s {
+	case OrderOpen:
+		return "open"
+	case OrderPartial:
+		return "partially filled"
+	case OrderFilled:
+		return "filled"
+	case OrderCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
+
+// Done reports whether the order can no longer change.
+func (s OrderState) Done() bool { return s == OrderFilled || s == OrderCanceled }
+
+type Transition struct {
+	Time  time.Time
+	State OrderState
+}
+
+// Order is an order placed by a strategy. Price is zero for market orders.
+type Order struct {
+	ID     uint64
+	Pair   generic.CurrencyPair
+	Side   api.TradeType
+	Price  float64
+	Amount float64
+	Filled float64
+	Placed time.Time
+	State  OrderState
+
+	// History lists every state the order went through.
+	History []Transition
+}
+
+func (o Order) Remaining() float64 { return o.Amount - o.Filled }
+
+// Fill is a (partial) execution of an own order.
+type Fill struct {
+	OrderID uint64
+	Time    time.Time
+	Side    api.TradeType
+	// Amount is in base, Price and Fee in counter currency.
+	Amount float64
+	Price  float64
+	Fee    float64
+}
+
+// OrderManager tracks the orders placed through a Runtime.
+type OrderManager struct {
+	orders map[uint64]*Order
+}
+
+func newOrderManager() *OrderManager {
+	return &OrderManager{orders: make(map[uint64]*Order)}
+}
+
+func (m *OrderManager) add(o Order) *Order {
+	o.History = []Transition{{o.Placed, o.State}}
+	n := &o
+	m.orders[o.ID] = n
+	return n
+}
+
+func (m *OrderManager) transition(o *Order, t time.Time, state OrderState) bool {
+	if o.State == state {
+		return false
+	}
+	o.State = state
+	o.History = append(o.History, Transition{t, state})
+	return true
+}
+
+func (m *OrderManager) Get(id uint64) (Order, bool) {
+	o, ok := m.orders[id]
+	if !ok {
+		return Order{}, false
+	}
+	return *o, true
+}
+
+func (m *OrderManager) list(done bool) []Order {
+	list := make([]Order, 0, len(m.orders))
+	for _, o := range m.orders {
+		if done || !o.State.Done() {
+			list = append(list, *o)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Open returns all orders that are not filled or canceled.
+func (m *OrderManager) Open() []Order { return m.list(false) }
+
+// All returns every tracked order.
+func (m *OrderManager) All() []Order { return m.list(true) }
+
+// exposure returns the base amount of open buy orders.
+func (m *OrderManager) exposure() float64 {
+	var n float64
+	for _, o := range m.orders {
+		if o.Side == api.Buy && !o.State.Done() {
+			n += o.Remaining()
+		}
+	}
+	return n
+}
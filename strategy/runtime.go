@@ -0,0 +1,643 @@
+package strategy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+// Starter is implemented by strategies that need to act before the first
+// market data arrives.
+type Starter interface {
+	Start(ctx Context) error
+}
+
+// Stopper is implemented by strategies that need to act when the runtime
+// stops, e.g. to cancel their orders.
+type Stopper interface {
+	Stop(ctx Context) error
+}
+
+// BookHandler is implemented by strategies interested in order book
+// updates.
+type BookHandler interface {
+	Book(ctx Context, b bitstamp.OrderBook) error
+}
+
+// FillHandler is implemented by strategies interested in executions of
+// their own orders.
+type FillHandler interface {
+	Fill(ctx Context, f Fill) error
+}
+
+// OrderHandler is implemented by strategies interested in the state
+// transitions of their own orders.
+type OrderHandler interface {
+	Order(ctx Context, o Order) error
+}
+
+var (
+	// ErrLimit is returned by the Executor of a Runtime for orders that
+	// would exceed its Limits.
+	ErrLimit = errors.New("strategy: limit exceeded")
+	// ErrKilled is returned for all orders once the kill switch engaged.
+	ErrKilled = errors.New("strategy: kill switch engaged")
+)
+
+// Limits guard the orders of a strategy, zero values disable a limit.
+type Limits struct {
+	// MaxPosition is the maximum base balance buy orders may lead to,
+	// including open buy orders.
+	MaxPosition float64
+	// MaxOrderSize is the maximum amount of a single order in base.
+	MaxOrderSize float64
+	// MaxOrdersPerMinute limits the rate orders are placed at.
+	MaxOrdersPerMinute int
+	// MaxDailyLoss engages the kill switch once the value of the base and
+	// counter balance dropped by this much counter currency since the
+	// start of the utc day.
+	MaxDailyLoss float64
+}
+
+// FillSource returns the transactions with an id greater than sinceID.
+type FillSource func(sinceID uint64) ([]bitstamp.Transaction, error)
+
+// PaperFills reads fills from a paper account.
+func PaperFills(p *bitstamp.Paper) FillSource {
+	return func(sinceID uint64) ([]bitstamp.Transaction, error) {
+		list := p.Transactions()
+		n := make([]bitstamp.Transaction, 0)
+		for _, t := range list {
+			if t.ID.Value() > sinceID {
+				n = append(n, t)
+			}
+		}
+		return n, nil
+	}
+}
+
+// LiveFills reads fills from the user transactions of pair, starting at
+// the time it was created.
+func LiveFills(b *bitstamp.Bitstamp, pair generic.CurrencyPair) FillSource {
+	start := time.Now()
+	return func(sinceID uint64) ([]bitstamp.Transaction, error) {
+		q := api.TransactionsQuery{Pair: pair, SinceID: sinceID, Types: []api.TransactionType{api.MarketTrade}}
+		if sinceID == 0 {
+			q.Since = start
+		}
+		list := make([]bitstamp.Transaction, 0)
+		err := b.WalkTransactions(q, func(t bitstamp.Transaction) bool {
+			if t.ID.Value() > sinceID {
+				list = append(list, t)
+			}
+			return true
+		})
+		return list, err
+	}
+}
+
+// Runtime drives a Strategy with market data, tracks the orders it places
+// and enforces Limits. The same runtime is used live, with a
+// bitstamp.Paper executor and in backtests, market data is either fed
+// through Trade, Book and Tick or, live, by Run.
+//
+// Errors caused by Limits or the kill switch that are returned from
+// strategy callbacks are not fatal, they are counted in Rejected.
+type Runtime struct {
+	Pair     generic.CurrencyPair
+	Strategy Strategy
+	Limits   Limits
+	Orders   *OrderManager
+	// SyncEvery limits how often fills and open orders are polled, zero
+	// polls after every event.
+	SyncEvery time.Duration
+	Rejected  int
+
+	exec    bitstamp.Executor
+	fills   FillSource
+	builder *candle.Builder
+
+	started  bool
+	now      time.Time
+	price    float64
+	placed   []time.Time
+	lastSync time.Time
+	lastFill uint64
+	day      time.Time
+	dayValue float64
+
+	// l guards Orders, placed and the kill switch so no order can be
+	// placed once Kill canceled the open ones.
+	l          sync.Mutex
+	killed     bool
+	killReason string
+	missing    map[uint64]struct{}
+}
+
+// New creates a runtime, candles of interval built from trades are passed
+// to Strategy.Candle.
+func New(pair generic.CurrencyPair, s Strategy, exec bitstamp.Executor, fills FillSource, interval time.Duration, limits Limits) *Runtime {
+	return &Runtime{
+		Pair:     pair,
+		Strategy: s,
+		Limits:   limits,
+		Orders:   newOrderManager(),
+		exec:     exec,
+		fills:    fills,
+		builder:  candle.NewBuilder(interval, true),
+		missing:  make(map[uint64]struct{}),
+	}
+}
+
+// Context returns the context passed to the strategy, its Executor
+// enforces the limits and tracks orders.
+func (r *Runtime) Context() Context {
+	return Context{Pair: r.Pair, Time: r.time(), Exec: guarded{r}}
+}
+
+func (r *Runtime) time() time.Time {
+	if r.now.IsZero() {
+		return time.Now()
+	}
+	return r.now
+}
+
+func (r *Runtime) setTime(t time.Time) {
+	if t.After(r.now) {
+		r.now = t
+	}
+}
+
+// handle drops errors caused by limits.
+func (r *Runtime) handle(err error) error {
+	if errors.Is(err, ErrLimit) || errors.Is(err, ErrKilled) {
+		r.Rejected++
+		return nil
+	}
+	return err
+}
+
+// Start calls Strategy.Start if implemented, it is called implicitly by
+// the first event.
+func (r *Runtime) Start(now time.Time) error {
+	if r.started {
+		return nil
+	}
+	r.started = true
+	r.setTime(now)
+	if s, ok := r.Strategy.(Starter); ok {
+		return r.handle(s.Start(r.Context()))
+	}
+	return nil
+}
+
+// Stop calls Strategy.Stop if implemented and syncs orders a last time.
+func (r *Runtime) Stop() error {
+	if s, ok := r.Strategy.(Stopper); ok {
+		if err := r.handle(s.Stop(r.Context())); err != nil {
+			return err
+		}
+	}
+	return r.Sync()
+}
+
+// Trade passes a market trade and the candles it finalized to the
+// strategy.
+func (r *Runtime) Trade(t bitstamp.Trade) error {
+	if err := r.Start(t.Date); err != nil {
+		return err
+	}
+	r.setTime(t.Date)
+	r.price = t.Price
+	for _, e := range r.builder.Add(t) {
+		if !e.Final {
+			continue
+		}
+		if err := r.handle(r.Strategy.Candle(r.Context(), e.Candle)); err != nil {
+			return err
+		}
+	}
+	if err := r.handle(r.Strategy.Trade(r.Context(), t)); err != nil {
+		return err
+	}
+	return r.maybeSync()
+}
+
+// Candle passes a candle to the strategy directly, for data sources
+// without trades.
+func (r *Runtime) Candle(c candle.Candle) error {
+	if err := r.Start(c.End()); err != nil {
+		return err
+	}
+	r.setTime(c.End())
+	r.price = c.Close
+	if err := r.handle(r.Strategy.Candle(r.Context(), c)); err != nil {
+		return err
+	}
+	return r.maybeSync()
+}
+
+func (r *Runtime) Book(b bitstamp.OrderBook) error {
+	if err := r.Start(b.Date); err != nil {
+		return err
+	}
+	r.setTime(b.Date)
+	if mid, ok := b.Mid(); ok && r.price == 0 {
+		r.price = mid
+	}
+	if s, ok := r.Strategy.(BookHandler); ok {
+		if err := r.handle(s.Book(r.Context(), b)); err != nil {
+			return err
+		}
+	}
+	return r.maybeSync()
+}
+
+// Tick finalizes candles and syncs orders when market data is sparse.
+func (r *Runtime) Tick(now time.Time) error {
+	r.setTime(now)
+	for _, e := range r.builder.Tick(now) {
+		if !e.Final {
+			continue
+		}
+		if err := r.handle(r.Strategy.Candle(r.Context(), e.Candle)); err != nil {
+			return err
+		}
+	}
+	return r.maybeSync()
+}
+
+func (r *Runtime) maybeSync() error {
+	if r.SyncEvery != 0 && r.now.Sub(r.lastSync) < r.SyncEvery {
+		return nil
+	}
+	return r.Sync()
+}
+
+// Sync polls fills and open orders, updating order states and notifying
+// the strategy, then checks the daily loss limit. An order that is no
+// longer open without being filled completely is only considered canceled
+// when it is still missing on the next poll, as its fills might not be
+// visible yet.
+func (r *Runtime) Sync() error {
+	r.lastSync = r.now
+	now := r.time()
+	open, err := r.exec.OpenOrders(r.Pair)
+	if err != nil {
+		return err
+	}
+	fills, err := r.fills(r.lastFill)
+	if err != nil {
+		return err
+	}
+
+	// events are either a Fill or an Order, collected while holding the
+	// lock and passed to the strategy afterwards.
+	var events []interface{}
+	r.l.Lock()
+	for _, t := range fills {
+		if id := t.ID.Value(); id > r.lastFill {
+			r.lastFill = id
+		}
+		o, ok := r.Orders.orders[t.OrderID.Value()]
+		if !ok {
+			continue
+		}
+		f := Fill{
+			OrderID: o.ID,
+			Time:    t.DateTime.Value(),
+			Side:    o.Side,
+			Amount:  math.Abs(t.Values[r.Pair.Base]),
+			Price:   t.Rate,
+			Fee:     t.Fee.Value(),
+		}
+		o.Filled += f.Amount
+		events = append(events, f)
+		if o.State.Done() {
+			continue
+		}
+		state := OrderPartial
+		if o.Filled >= o.Amount*(1-1e-9) {
+			state = OrderFilled
+		}
+		if r.Orders.transition(o, f.Time, state) {
+			events = append(events, *o)
+		}
+	}
+
+	isOpen := make(map[uint64]struct{}, len(open))
+	for _, o := range open {
+		isOpen[o.ID.Value()] = struct{}{}
+	}
+	for _, o := range r.Orders.orders {
+		if _, ok := isOpen[o.ID]; ok || o.State.Done() || o.Pair != r.Pair {
+			delete(r.missing, o.ID)
+			continue
+		}
+		if _, ok := r.missing[o.ID]; !ok {
+			r.missing[o.ID] = struct{}{}
+			continue
+		}
+		delete(r.missing, o.ID)
+		state := OrderCanceled
+		if o.Price == 0 || o.Filled >= o.Amount*(1-1e-9) {
+			state = OrderFilled
+		}
+		if r.Orders.transition(o, now, state) {
+			events = append(events, *o)
+		}
+	}
+	r.l.Unlock()
+
+	ctx := r.Context()
+	for _, e := range events {
+		var err error
+		switch e := e.(type) {
+		case Fill:
+			if s, ok := r.Strategy.(FillHandler); ok {
+				err = s.Fill(ctx, e)
+			}
+		case Order:
+			if s, ok := r.Strategy.(OrderHandler); ok {
+				err = s.Order(ctx, e)
+			}
+		}
+		if err = r.handle(err); err != nil {
+			return err
+		}
+	}
+
+	return r.checkLoss(now)
+}
+
+func (r *Runtime) checkLoss(now time.Time) error {
+	if r.Limits.MaxDailyLoss == 0 || r.price == 0 {
+		return nil
+	}
+	b, err := r.exec.BalancesPair(r.Pair)
+	if err != nil {
+		return err
+	}
+	value := b.Currency(r.Pair.Counter).Total + b.Currency(r.Pair.Base).Total*r.price
+
+	y, m, d := now.UTC().Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if !day.Equal(r.day) {
+		r.day, r.dayValue = day, value
+	}
+	if loss := r.dayValue - value; loss >= r.Limits.MaxDailyLoss {
+		return r.Kill(fmt.Sprintf("daily loss of %g %s", loss, r.Pair.Counter))
+	}
+	return nil
+}
+
+// Kill engages the kill switch: the open orders of the runtime are
+// canceled and no new orders can be placed. Orders placed by others are
+// left alone. It is safe to call from another goroutine.
+func (r *Runtime) Kill(reason string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.killed {
+		return nil
+	}
+	r.killed, r.killReason = true, reason
+	_, err := r.cancelOpen()
+	return err
+}
+
+// cancelOpen cancels all open orders of the runtime, failures are only
+// reported if the order is still open afterwards as it might have been
+// filled or canceled in the meantime. The caller must hold r.l.
+func (r *Runtime) cancelOpen() ([]api.CanceledOrder, error) {
+	list := make([]api.CanceledOrder, 0)
+	var cerr error
+	for _, o := range r.Orders.Open() {
+		c, err := r.exec.Cancel(o.ID)
+		if err != nil {
+			if cerr == nil {
+				cerr = err
+			}
+			continue
+		}
+		list = append(list, c)
+	}
+	if cerr == nil {
+		return list, nil
+	}
+	open, err := r.exec.OpenOrders(r.Pair)
+	if err != nil {
+		return list, err
+	}
+	for _, o := range open {
+		if _, ok := r.Orders.orders[o.ID.Value()]; ok {
+			return list, cerr
+		}
+	}
+	return list, nil
+}
+
+// Killed returns whether the kill switch engaged and why.
+func (r *Runtime) Killed() (bool, string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.killed, r.killReason
+}
+
+// check validates an order against the limits, the caller must hold r.l.
+func (r *Runtime) check(o api.Order) (side api.TradeType, price, amount float64, err error) {
+	if r.killed {
+		return 0, 0, 0, fmt.Errorf("%w: %s", ErrKilled, r.killReason)
+	}
+
+	var action string
+	var inCounter bool
+	switch o := o.(type) {
+	case api.LimitOrder:
+		action, price, amount = o.Action, o.Price, o.Amount
+	case api.SimpleOrder:
+		action, amount = o.Action, o.Amount
+		inCounter = o.AmountCounter || (o.Type == "instant" && o.Action == "buy")
+	default:
+		return 0, 0, 0, fmt.Errorf("unsupported order type %T", o)
+	}
+	side = api.Buy
+	if action == "sell" {
+		side = api.Sell
+	}
+
+	base := amount
+	if inCounter {
+		if r.price == 0 {
+			return side, price, amount, fmt.Errorf("%w: no price to convert counter amount", ErrLimit)
+		}
+		base = amount / r.price
+	}
+
+	l := r.Limits
+	if l.MaxOrderSize != 0 && base > l.MaxOrderSize {
+		return side, price, amount, fmt.Errorf("%w: order of %g exceeds max order size %g", ErrLimit, base, l.MaxOrderSize)
+	}
+	if l.MaxOrdersPerMinute != 0 {
+		now := r.time()
+		n := 0
+		for _, t := range r.placed {
+			if now.Sub(t) < time.Minute {
+				r.placed[n] = t
+				n++
+			}
+		}
+		r.placed = r.placed[:n]
+		if n >= l.MaxOrdersPerMinute {
+			return side, price, amount, fmt.Errorf("%w: more than %d orders per minute", ErrLimit, l.MaxOrdersPerMinute)
+		}
+	}
+	if l.MaxPosition != 0 && side == api.Buy {
+		b, err := r.exec.BalancesPair(r.Pair)
+		if err != nil {
+			return side, price, amount, err
+		}
+		pos := b.Currency(r.Pair.Base).Total + r.Orders.exposure() + base
+		if pos > l.MaxPosition {
+			return side, price, amount, fmt.Errorf("%w: position of %g exceeds max position %g", ErrLimit, pos, l.MaxPosition)
+		}
+	}
+
+	return side, price, base, nil
+}
+
+// guarded is the Executor passed to strategies.
+type guarded struct{ r *Runtime }
+
+func (g guarded) Place(o api.Order) (api.OrderResponse, error) {
+	res, n, err := g.r.place(o)
+	if err != nil {
+		return res, err
+	}
+	if s, ok := g.r.Strategy.(OrderHandler); ok {
+		err = s.Order(g.r.Context(), n)
+	}
+	return res, err
+}
+
+// place checks and places an order while holding r.l so Kill can not
+// engage in between.
+func (r *Runtime) place(o api.Order) (api.OrderResponse, Order, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	side, price, amount, err := r.check(o)
+	if err != nil {
+		return api.OrderResponse{}, Order{}, err
+	}
+	res, err := r.exec.Place(o)
+	if err != nil {
+		return res, Order{}, err
+	}
+
+	now := r.time()
+	r.placed = append(r.placed, now)
+	if price == 0 && res.Amount.Value() != 0 {
+		amount = res.Amount.Value()
+	}
+	n := r.Orders.add(Order{
+		ID:     res.ID.Value(),
+		Pair:   r.Pair,
+		Side:   side,
+		Price:  price,
+		Amount: amount,
+		Placed: now,
+		State:  OrderOpen,
+	})
+	return res, *n, nil
+}
+
+func (g guarded) OpenOrders(pair generic.CurrencyPair) ([]api.OpenOrder, error) {
+	return g.r.exec.OpenOrders(pair)
+}
+
+func (g guarded) OpenOrdersAll() ([]api.OpenOrder, error) { return g.r.exec.OpenOrdersAll() }
+
+func (g guarded) Cancel(id uint64) (api.CanceledOrder, error) { return g.r.exec.Cancel(id) }
+
+// CancelAll only cancels the orders placed by the runtime.
+func (g guarded) CancelAll() ([]api.CanceledOrder, error) {
+	g.r.l.Lock()
+	defer g.r.l.Unlock()
+	return g.r.cancelOpen()
+}
+
+func (g guarded) Balances() (api.Balances, error) { return g.r.exec.Balances() }
+
+func (g guarded) BalancesPair(pair generic.CurrencyPair) (api.Balances, error) {
+	return g.r.exec.BalancesPair(pair)
+}
+
+// Run feeds live trades and order book updates of the pair to the runtime
+// until an error occurs or the kill switch engages. A *bitstamp.Paper
+// executor is fed the same market data. The feeds stop when Run returns.
+func (r *Runtime) Run(b *bitstamp.Bitstamp) error {
+	paper, _ := r.exec.(*bitstamp.Paper)
+	if paper != nil {
+		book, err := b.OrderBook(r.Pair)
+		if err != nil {
+			return err
+		}
+		paper.UpdateBook(r.Pair, book)
+	}
+
+	trades := make(chan bitstamp.Trade, 16)
+	books := make(chan bitstamp.OrderBook, 1)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		errs <- b.MarketLive(r.Pair, trades, books, done)
+	}()
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	if err := r.Start(time.Now()); err != nil {
+		return err
+	}
+	run := func() error {
+		for {
+			if killed, reason := r.Killed(); killed {
+				return fmt.Errorf("%w: %s", ErrKilled, reason)
+			}
+
+			var err error
+			select {
+			case err = <-errs:
+				return err
+			case t := <-trades:
+				if paper != nil {
+					paper.AddTrade(r.Pair, t)
+				}
+				err = r.Trade(t)
+			case book := <-books:
+				if paper != nil {
+					paper.UpdateBook(r.Pair, book)
+				}
+				err = r.Book(book)
+			case now := <-tick.C:
+				err = r.Tick(now)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := run()
+	if serr := r.Stop(); err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,382 @@
+package strategy
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/generic"
+)
+
+// testStrategy calls do on the next trade and records the events of its
+// orders.
+type testStrategy struct {
+	do     func(ctx Context) error
+	orders []Order
+	fills  []Fill
+}
+
+func (s *testStrategy) Candle(ctx Context, c candle.Candle) error { return nil }
+
+func (s *testStrategy) Trade(ctx Context, t bitstamp.Trade) error {
+	do := s.do
+	s.do = nil
+	if do == nil {
+		return nil
+	}
+	return do(ctx)
+}
+
+func (s *testStrategy) Order(ctx Context, o Order) error {
+	s.orders = append(s.orders, o)
+	return nil
+}
+
+func (s *testStrategy) Fill(ctx Context, f Fill) error {
+	s.fills = append(s.fills, f)
+	return nil
+}
+
+func (s *testStrategy) String() string { return "test" }
+
+var start = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+type runtimeTest struct {
+	t     *testing.T
+	pair  generic.CurrencyPair
+	paper *bitstamp.Paper
+	s     *testStrategy
+	r     *Runtime
+	now   time.Time
+}
+
+func newRuntimeTest(t *testing.T, limits Limits, fills func(FillSource) FillSource, balances map[generic.Currency]float64) *runtimeTest {
+	pair := bitstamp.BTCUSD()
+	paper := bitstamp.NewPaper(0, balances)
+	source := PaperFills(paper)
+	if fills != nil {
+		source = fills(source)
+	}
+	s := &testStrategy{}
+	return &runtimeTest{
+		t:     t,
+		pair:  pair,
+		paper: paper,
+		s:     s,
+		r:     New(pair, s, paper, source, time.Minute, limits),
+		now:   start,
+	}
+}
+
+// trade feeds a market trade to the paper account and the runtime, as Run
+// does, after advancing the clock by d. do is called by the strategy.
+func (rt *runtimeTest) trade(d time.Duration, price, amount float64, do func(ctx Context) error) {
+	rt.t.Helper()
+	rt.now = rt.now.Add(d)
+	t := bitstamp.Trade{Date: rt.now, Price: price, Amount: amount, Type: api.Buy}
+	rt.paper.AddTrade(rt.pair, t)
+	rt.s.do = do
+	if err := rt.r.Trade(t); err != nil {
+		rt.t.Fatal(err)
+	}
+}
+
+func buy(pair generic.CurrencyPair, amount, price float64) func(ctx Context) error {
+	return func(ctx Context) error {
+		_, err := ctx.Exec.Place(api.NewLimitBuy(pair, amount, price))
+		return err
+	}
+}
+
+func (rt *runtimeTest) open() map[uint64]struct{} {
+	rt.t.Helper()
+	list, err := rt.paper.OpenOrdersAll()
+	if err != nil {
+		rt.t.Fatal(err)
+	}
+	m := make(map[uint64]struct{}, len(list))
+	for _, o := range list {
+		m[o.ID.Value()] = struct{}{}
+	}
+	return m
+}
+
+func TestLimits(t *testing.T) {
+	usd := map[generic.Currency]float64{bitstamp.USD: 10000}
+	tests := []struct {
+		name     string
+		limits   Limits
+		balances map[generic.Currency]float64
+		// run places orders, exactly one of which must be rejected.
+		run func(rt *runtimeTest)
+	}{
+		{
+			"max order size",
+			Limits{MaxOrderSize: 1},
+			usd,
+			func(rt *runtimeTest) {
+				rt.trade(time.Second, 100, 1, buy(rt.pair, 1, 50))
+				rt.trade(time.Second, 100, 1, buy(rt.pair, 1.5, 50))
+			},
+		},
+		{
+			"max order size in counter",
+			Limits{MaxOrderSize: 1},
+			usd,
+			func(rt *runtimeTest) {
+				rt.trade(time.Second, 100, 1, func(ctx Context) error {
+					o := api.NewBuyOrder(rt.pair, 150)
+					o.AmountCounter = true
+					_, err := ctx.Exec.Place(o)
+					return err
+				})
+			},
+		},
+		{
+			"max position",
+			Limits{MaxPosition: 1},
+			map[generic.Currency]float64{bitstamp.USD: 10000, bitstamp.BTC: 0.5},
+			func(rt *runtimeTest) {
+				rt.trade(time.Second, 100, 1, buy(rt.pair, 0.4, 50))
+				rt.trade(time.Second, 100, 1, buy(rt.pair, 0.2, 50))
+			},
+		},
+		{
+			"max orders per minute",
+			Limits{MaxOrdersPerMinute: 2},
+			usd,
+			func(rt *runtimeTest) {
+				rt.trade(time.Second, 100, 1, buy(rt.pair, 0.1, 50))
+				rt.trade(time.Second, 100, 1, buy(rt.pair, 0.1, 50))
+				rt.trade(time.Second, 100, 1, buy(rt.pair, 0.1, 50))
+				rt.trade(time.Minute, 100, 1, buy(rt.pair, 0.1, 50))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := newRuntimeTest(t, test.limits, nil, test.balances)
+			test.run(rt)
+			if rt.r.Rejected != 1 {
+				t.Fatalf("rejected %d orders, expected 1", rt.r.Rejected)
+			}
+			placed := len(rt.r.Orders.All())
+			if n := len(rt.open()); n != placed {
+				t.Errorf("%d orders open, %d tracked", n, placed)
+			}
+		})
+	}
+}
+
+func TestMaxDailyLoss(t *testing.T) {
+	rt := newRuntimeTest(t, Limits{MaxDailyLoss: 10}, nil, map[generic.Currency]float64{bitstamp.BTC: 1, bitstamp.USD: 100})
+	rt.trade(time.Second, 100, 0.1, buy(rt.pair, 0.1, 50))
+	rt.trade(time.Second, 95, 0.1, nil)
+	if killed, _ := rt.r.Killed(); killed {
+		t.Fatal("killed after a loss of 5")
+	}
+
+	// A new day resets the reference value.
+	rt.trade(24*time.Hour, 91, 0.1, nil)
+	rt.trade(time.Second, 85, 0.1, nil)
+	if killed, _ := rt.r.Killed(); killed {
+		t.Fatal("killed after a loss of 6 on a new day")
+	}
+
+	rt.trade(time.Second, 80, 0.1, nil)
+	killed, reason := rt.r.Killed()
+	if !killed {
+		t.Fatal("not killed after a loss of 11")
+	}
+	if reason != "daily loss of 11 usd" {
+		t.Errorf("reason %q", reason)
+	}
+	if n := len(rt.open()); n != 0 {
+		t.Errorf("%d orders open after the kill switch engaged", n)
+	}
+
+	rt.trade(time.Second, 80, 0.1, buy(rt.pair, 0.1, 50))
+	if rt.r.Rejected != 1 {
+		t.Errorf("rejected %d orders after the kill switch engaged, expected 1", rt.r.Rejected)
+	}
+	if n := len(rt.r.Orders.All()); n != 1 {
+		t.Errorf("%d orders tracked, expected 1", n)
+	}
+}
+
+func TestKill(t *testing.T) {
+	rt := newRuntimeTest(t, Limits{}, nil, map[generic.Currency]float64{bitstamp.USD: 10000})
+	other, err := rt.paper.Place(api.NewLimitBuy(rt.pair, 1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.trade(time.Second, 100, 1, buy(rt.pair, 1, 50))
+	rt.trade(time.Second, 100, 1, buy(rt.pair, 1, 60))
+
+	if err := rt.r.Kill("test"); err != nil {
+		t.Fatal(err)
+	}
+	open := rt.open()
+	if _, ok := open[other.ID.Value()]; !ok || len(open) != 1 {
+		t.Fatalf("open orders after kill %v, expected only %d", open, other.ID.Value())
+	}
+
+	rt.trade(time.Second, 100, 1, buy(rt.pair, 1, 50))
+	if rt.r.Rejected != 1 {
+		t.Errorf("rejected %d orders after kill, expected 1", rt.r.Rejected)
+	}
+	if _, err := rt.r.Context().Exec.Place(api.NewLimitBuy(rt.pair, 1, 50)); !errors.Is(err, ErrKilled) {
+		t.Errorf("place after kill returned %v, expected %v", err, ErrKilled)
+	}
+
+	if _, err := rt.r.Context().Exec.CancelAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rt.open()[other.ID.Value()]; !ok {
+		t.Error("CancelAll canceled an order not placed by the runtime")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := rt.r.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, o := range rt.r.Orders.All() {
+		if o.State != OrderCanceled {
+			t.Errorf("order %d is %s, expected canceled", o.ID, o.State)
+		}
+	}
+}
+
+func TestKillRace(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		rt := newRuntimeTest(t, Limits{}, nil, map[generic.Currency]float64{bitstamp.USD: 1e6})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				rt.s.do = buy(rt.pair, 0.1, 50)
+				if err := rt.r.Trade(bitstamp.Trade{Date: start.Add(time.Duration(n) * time.Second), Price: 100}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Duration(i) * 50 * time.Microsecond)
+		if err := rt.r.Kill("test"); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+
+		if n := len(rt.open()); n != 0 {
+			t.Fatalf("%d orders open after kill", n)
+		}
+	}
+}
+
+func TestOrderStates(t *testing.T) {
+	var hide bool
+	fills := func(src FillSource) FillSource {
+		return func(sinceID uint64) ([]bitstamp.Transaction, error) {
+			if hide {
+				return nil, nil
+			}
+			return src(sinceID)
+		}
+	}
+	rt := newRuntimeTest(t, Limits{}, fills, map[generic.Currency]float64{bitstamp.USD: 10000})
+	states := func(id uint64) []OrderState {
+		o, ok := rt.r.Orders.Get(id)
+		if !ok {
+			t.Fatalf("order %d not tracked", id)
+		}
+		list := make([]OrderState, len(o.History))
+		for i, h := range o.History {
+			list[i] = h.State
+		}
+		return list
+	}
+	expect := func(id uint64, exp ...OrderState) {
+		t.Helper()
+		got := states(id)
+		if len(got) != len(exp) {
+			t.Fatalf("order %d went through %v, expected %v", id, got, exp)
+		}
+		for i := range got {
+			if got[i] != exp[i] {
+				t.Fatalf("order %d went through %v, expected %v", id, got, exp)
+			}
+		}
+	}
+	place := func(price float64) uint64 {
+		t.Helper()
+		res, err := rt.r.Context().Exec.Place(api.NewLimitBuy(rt.pair, 1, price))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.ID.Value()
+	}
+	sync := func() {
+		t.Helper()
+		if err := rt.r.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Partial and complete fills.
+	id := place(90)
+	sync()
+	expect(id, OrderOpen)
+	rt.trade(time.Second, 90, 0.4, nil)
+	expect(id, OrderOpen, OrderPartial)
+	rt.trade(time.Second, 90, 1, nil)
+	expect(id, OrderOpen, OrderPartial, OrderFilled)
+	if o, _ := rt.r.Orders.Get(id); o.Filled != 1 {
+		t.Errorf("filled %g, expected 1", o.Filled)
+	}
+	if len(rt.s.fills) != 2 || rt.s.fills[0].Amount != 0.4 || rt.s.fills[1].Amount != 0.6 {
+		t.Errorf("fills %+v", rt.s.fills)
+	}
+
+	// Canceled elsewhere, confirmed on the second poll it is missing.
+	id = place(80)
+	if _, err := rt.paper.Cancel(id); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+	expect(id, OrderOpen)
+	sync()
+	expect(id, OrderOpen, OrderCanceled)
+
+	// Filled while the fills were not visible yet.
+	id = place(70)
+	hide = true
+	rt.paper.AddTrade(rt.pair, bitstamp.Trade{Date: rt.now, Price: 70, Amount: 1})
+	sync()
+	expect(id, OrderOpen)
+	hide = false
+	sync()
+	expect(id, OrderOpen, OrderFilled)
+
+	// Every transition was passed to the strategy, in order.
+	var n int
+	for _, o := range rt.r.Orders.All() {
+		n += len(o.History)
+	}
+	if len(rt.s.orders) != n {
+		t.Errorf("strategy saw %d order events, expected %d", len(rt.s.orders), n)
+	}
+	last := make(map[uint64]OrderState)
+	for _, o := range rt.s.orders {
+		if s, ok := last[o.ID]; ok && s.Done() {
+			t.Errorf("order %d changed to %s after %s", o.ID, o.State, s)
+		}
+		last[o.ID] = o.State
+	}
+}
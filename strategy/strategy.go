@@ -0,0 +1,183 @@
+// Package strategy defines the interface trading strategies implement and
+// a few simple built-in strategies.
+package strategy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/candle"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/indicator"
+)
+
+// Context is passed to every strategy callback.
+type Context struct {
+	Pair generic.CurrencyPair
+	// Time is the time of the market data being processed, not the wall
+	// clock.
+	Time time.Time
+	Exec bitstamp.Executor
+}
+
+// Strategy reacts to market data by placing orders through the Executor
+// of the Context.
+type Strategy interface {
+	// Candle is called for every final candle.
+	Candle(ctx Context, c candle.Candle) error
+	// Trade is called for every market trade if trades are available.
+	Trade(ctx Context, t bitstamp.Trade) error
+	String() string
+}
+
+// allIn buys with the entire available counter balance.
+func allIn(ctx Context) error {
+	b, err := ctx.Exec.BalancesPair(ctx.Pair)
+	if err != nil {
+		return err
+	}
+	fee, _ := b.Fee(ctx.Pair)
+	amount := b.Currency(ctx.Pair.Counter).Available / (1 + fee/100)
+	if amount <= 0 {
+		return nil
+	}
+	o := api.NewBuyOrder(ctx.Pair, amount)
+	o.AmountCounter = true
+	_, err = ctx.Exec.Place(o)
+	return err
+}
+
+// allOut sells the entire available base balance.
+func allOut(ctx Context) error {
+	b, err := ctx.Exec.BalancesPair(ctx.Pair)
+	if err != nil {
+		return err
+	}
+	amount := b.Currency(ctx.Pair.Base).Available
+	if amount <= 0 {
+		return nil
+	}
+	_, err = ctx.Exec.Place(api.NewSellOrder(ctx.Pair, amount))
+	return err
+}
+
+// Cross buys when the fast moving average crosses above the slow one and
+// sells when it crosses below.
+type Cross struct {
+	n, m       int
+	fast, slow indicator.Indicator
+	above, ok  bool
+}
+
+func NewCross(fast, slow int) *Cross {
+	return &Cross{n: fast, m: slow, fast: indicator.NewEMA(fast), slow: indicator.NewEMA(slow)}
+}
+
+func (s *Cross) String() string { return fmt.Sprintf("cross(%d,%d)", s.n, s.m) }
+
+func (s *Cross) Trade(Context, bitstamp.Trade) error { return nil }
+
+func (s *Cross) Candle(ctx Context, c candle.Candle) error {
+	s.fast.Update(c, true)
+	s.slow.Update(c, true)
+	f, ok1 := s.fast.Value()
+	sl, ok2 := s.slow.Value()
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	above, was, ok := f > sl, s.above, s.ok
+	s.above, s.ok = above, true
+	switch {
+	case !ok || above == was:
+		return nil
+	case above:
+		return allIn(ctx)
+	}
+	return allOut(ctx)
+}
+
+// RSI buys when the rsi drops below low and sells when it rises above
+// high.
+type RSI struct {
+	n         int
+	rsi       *indicator.RSI
+	low, high float64
+}
+
+func NewRSI(n int, low, high float64) *RSI {
+	return &RSI{n: n, rsi: indicator.NewRSI(n), low: low, high: high}
+}
+
+func (s *RSI) String() string { return fmt.Sprintf("rsi(%d,%g,%g)", s.n, s.low, s.high) }
+
+func (s *RSI) Trade(Context, bitstamp.Trade) error { return nil }
+
+func (s *RSI) Candle(ctx Context, c candle.Candle) error {
+	s.rsi.Update(c, true)
+	v, ok := s.rsi.Value()
+	switch {
+	case !ok:
+		return nil
+	case v < s.low:
+		return allIn(ctx)
+	case v > s.high:
+		return allOut(ctx)
+	}
+	return nil
+}
+
+// Names returns the names of the built-in strategies Parse understands.
+func Names() []string { return []string{"cross(fast,slow)", "rsi(n,low,high)"} }
+
+// Parse parses a built-in strategy spec like 'cross(12,26)' or
+// 'rsi(14,30,70)'. Omitted arguments take their default values.
+func Parse(spec string) (Strategy, error) {
+	spec = strings.ToLower(strings.Join(strings.Fields(spec), ""))
+	name, args := spec, ""
+	if ix := strings.IndexByte(spec, '('); ix != -1 {
+		if !strings.HasSuffix(spec, ")") {
+			return nil, fmt.Errorf("invalid strategy '%s': missing ')'", spec)
+		}
+		name, args = spec[:ix], spec[ix+1:len(spec)-1]
+	}
+
+	var values []float64
+	switch name {
+	case "cross":
+		values = []float64{12, 26}
+	case "rsi":
+		values = []float64{14, 30, 70}
+	default:
+		return nil, fmt.Errorf("unknown strategy '%s'", name)
+	}
+	if args != "" {
+		list := strings.Split(args, ",")
+		if len(list) > len(values) {
+			return nil, fmt.Errorf("%s accepts at most %d arguments", name, len(values))
+		}
+		for i, a := range list {
+			v, err := strconv.ParseFloat(a, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid %s argument '%s'", name, a)
+			}
+			values[i] = v
+		}
+	}
+
+	switch name {
+	case "cross":
+		if values[0] < 1 || values[0] >= values[1] {
+			return nil, fmt.Errorf("cross: fast period has to be smaller than the slow one")
+		}
+		return NewCross(int(values[0]), int(values[1])), nil
+	}
+	if values[0] < 1 {
+		return nil, fmt.Errorf("rsi: period has to be at least 1")
+	}
+	return NewRSI(int(values[0]), values[1], values[2]), nil
+}
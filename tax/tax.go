@@ -0,0 +1,160 @@
+// Package tax turns the disposals of a pnl replay into per-lot tax records
+// and exports them in formats accountants commonly ask for.
+package tax
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/pnl"
+)
+
+// Record is the disposal of (part of) a single lot. Records for amounts
+// without a known cost basis have a zero Acquired date and Cost.
+type Record struct {
+	TransactionID uint64
+	Currency      generic.Currency
+	Quote         generic.Currency
+	Amount        float64
+	Acquired      time.Time
+	Disposed      time.Time
+	Proceeds      float64
+	Cost          float64
+}
+
+func (r Record) Gain() float64 { return r.Proceeds - r.Cost }
+
+// HoldingPeriod returns the time between acquisition and disposal, zero
+// if the acquisition date is unknown.
+func (r Record) HoldingPeriod() time.Duration {
+	if r.Acquired.IsZero() {
+		return 0
+	}
+	return r.Disposed.Sub(r.Acquired)
+}
+
+// Days returns the holding period in whole days.
+func (r Record) Days() int { return int(r.HoldingPeriod() / (24 * time.Hour)) }
+
+// LongTerm reports whether the lot was held for more than a year.
+func (r Record) LongTerm() bool {
+	return !r.Acquired.IsZero() && r.Disposed.After(r.Acquired.AddDate(1, 0, 0))
+}
+
+// Records splits every disposal of e in the given year into one record
+// per consumed lot, proceeds are divided proportionally to the amounts.
+// The year is determined in loc.
+func Records(e *pnl.Engine, year int, loc *time.Location) []Record {
+	list := make([]Record, 0)
+	for _, d := range e.Disposals() {
+		if d.Date.In(loc).Year() != year || d.Amount == 0 {
+			continue
+		}
+		rec := func(acquired time.Time, amount, cost float64) {
+			list = append(list, Record{
+				TransactionID: d.TransactionID,
+				Currency:      d.Currency,
+				Quote:         e.Quote,
+				Amount:        amount,
+				Acquired:      acquired,
+				Disposed:      d.Date,
+				Proceeds:      d.Proceeds * amount / d.Amount,
+				Cost:          cost,
+			})
+		}
+		for _, l := range d.Lots {
+			rec(l.Date, l.Amount, l.Cost)
+		}
+		if d.Uncovered != 0 {
+			rec(time.Time{}, d.Uncovered, 0)
+		}
+	}
+	return list
+}
+
+type Format string
+
+const (
+	// Generic contains every field of a record.
+	Generic Format = "generic"
+	// Form8949 follows the columns of the US IRS Form 8949.
+	Form8949 Format = "8949"
+)
+
+func ParseFormat(str string) (Format, error) {
+	switch f := Format(strings.ToLower(str)); f {
+	case Generic, Form8949:
+		return f, nil
+	}
+	return Generic, fmt.Errorf("unknown tax format '%s', use %s or %s", str, Generic, Form8949)
+}
+
+// Write exports the records as csv, dates are formatted in loc.
+func (f Format) Write(w io.Writer, loc *time.Location, records []Record) error {
+	c := csv.NewWriter(w)
+	num := func(v float64, c generic.Currency) string {
+		return strconv.FormatFloat(v, 'f', bitstamp.Precision(c), 64)
+	}
+	date := func(t time.Time, layout, unknown string) string {
+		if t.IsZero() {
+			return unknown
+		}
+		return t.In(loc).Format(layout)
+	}
+	term := func(r Record) string {
+		if r.LongTerm() {
+			return "long"
+		}
+		return "short"
+	}
+
+	switch f {
+	case Generic:
+		c.Write([]string{
+			"transaction_id", "currency", "amount", "acquired", "disposed",
+			"holding_days", "term", "quote", "proceeds", "cost", "gain",
+		})
+		for _, r := range records {
+			c.Write([]string{
+				strconv.FormatUint(r.TransactionID, 10),
+				r.Currency.String(),
+				num(r.Amount, r.Currency),
+				date(r.Acquired, time.RFC3339, ""),
+				date(r.Disposed, time.RFC3339, ""),
+				strconv.Itoa(r.Days()),
+				term(r),
+				r.Quote.String(),
+				num(r.Proceeds, r.Quote),
+				num(r.Cost, r.Quote),
+				num(r.Gain(), r.Quote),
+			})
+		}
+	case Form8949:
+		c.Write([]string{
+			"Description of property", "Date acquired", "Date sold or disposed of",
+			"Proceeds", "Cost or other basis", "Gain or (loss)", "Term",
+		})
+		for _, r := range records {
+			c.Write([]string{
+				fmt.Sprintf("%s %s", num(r.Amount, r.Currency), strings.ToUpper(r.Currency.String())),
+				date(r.Acquired, "01/02/2006", ""),
+				date(r.Disposed, "01/02/2006", ""),
+				num(r.Proceeds, r.Quote),
+				num(r.Cost, r.Quote),
+				num(r.Gain(), r.Quote),
+				term(r),
+			})
+		}
+	default:
+		return fmt.Errorf("unknown tax format '%s'", f)
+	}
+
+	c.Flush()
+	return c.Error()
+}
@@ -0,0 +1,170 @@
+package tax
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/frizinak/bitstamp"
+	"github.com/frizinak/bitstamp/api"
+	"github.com/frizinak/bitstamp/generic"
+	"github.com/frizinak/bitstamp/pnl"
+)
+
+var (
+	acquired = time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC)
+	yearEnd  = time.Date(2021, 12, 31, 23, 30, 0, 0, time.UTC)
+	cet      = time.FixedZone("CET", 3600)
+)
+
+func engine(t *testing.T) *pnl.Engine {
+	pair := bitstamp.BTCUSD()
+	trade := func(id uint64, date time.Time, btc, usd float64) bitstamp.Transaction {
+		return bitstamp.Transaction{
+			Transaction: api.Transaction{
+				DateTime: generic.UTCDateString(date),
+				ID:       generic.Uint64String(id),
+				Type:     api.MarketTrade,
+				Pair:     pair,
+			},
+			Values: map[generic.Currency]float64{bitstamp.BTC: btc, bitstamp.USD: usd},
+		}
+	}
+
+	e := pnl.New(bitstamp.USD, pnl.FIFO, nil)
+	for _, tr := range []bitstamp.Transaction{
+		trade(1, acquired, 2, -200),
+		// Exactly one year after the acquisition.
+		trade(2, acquired.AddDate(1, 0, 0), -1, 400),
+		trade(3, acquired.AddDate(1, 0, 0).Add(time.Second), -0.5, 250),
+		trade(4, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), 1, -300),
+		// Split over the rest of the first lot, the second lot and 0.5
+		// without cost basis. Already 2022 in cet.
+		trade(5, yearEnd, -2, 1000),
+	} {
+		if err := e.Add(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+func TestRecords(t *testing.T) {
+	e := engine(t)
+	type exp struct {
+		id       uint64
+		amount   float64
+		acquired time.Time
+		proceeds float64
+		cost     float64
+		long     bool
+	}
+	first := exp{2, 1, acquired, 400, 100, false}
+	second := exp{3, 0.5, acquired, 250, 50, true}
+	split := []exp{
+		{5, 0.5, acquired, 250, 50, true},
+		{5, 1, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), 500, 300, false},
+		{5, 0.5, time.Time{}, 250, 0, false},
+	}
+
+	tests := []struct {
+		name string
+		year int
+		loc  *time.Location
+		exp  []exp
+	}{
+		{"utc 2021", 2021, time.UTC, append([]exp{first, second}, split...)},
+		{"cet 2021", 2021, cet, []exp{first, second}},
+		{"cet 2022", 2022, cet, split},
+		{"utc 2020", 2020, time.UTC, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list := Records(e, test.year, test.loc)
+			if len(list) != len(test.exp) {
+				t.Fatalf("%d records, expected %d", len(list), len(test.exp))
+			}
+			for i, r := range list {
+				x := test.exp[i]
+				if r.TransactionID != x.id || r.Currency != bitstamp.BTC || r.Quote != bitstamp.USD {
+					t.Errorf("record %d: %d %s %s", i, r.TransactionID, r.Currency, r.Quote)
+				}
+				if math.Abs(r.Amount-x.amount) > 1e-9 || math.Abs(r.Proceeds-x.proceeds) > 1e-9 || math.Abs(r.Cost-x.cost) > 1e-9 {
+					t.Errorf("record %d: amount %g proceeds %g cost %g, expected %g %g %g", i, r.Amount, r.Proceeds, r.Cost, x.amount, x.proceeds, x.cost)
+				}
+				if !r.Acquired.Equal(x.acquired) {
+					t.Errorf("record %d: acquired %s, expected %s", i, r.Acquired, x.acquired)
+				}
+				if r.LongTerm() != x.long {
+					t.Errorf("record %d: long term %t, expected %t", i, r.LongTerm(), x.long)
+				}
+			}
+		})
+	}
+
+	r := Record{Amount: 0.5, Disposed: yearEnd}
+	if r.HoldingPeriod() != 0 || r.Days() != 0 || r.LongTerm() {
+		t.Errorf("uncovered record has a holding period of %s", r.HoldingPeriod())
+	}
+}
+
+func TestWrite(t *testing.T) {
+	list := Records(engine(t), 2021, time.UTC)
+	tests := []struct {
+		format Format
+		loc    *time.Location
+		exp    string
+	}{
+		{
+			Generic,
+			time.UTC,
+			`transaction_id,currency,amount,acquired,disposed,holding_days,term,quote,proceeds,cost,gain
+2,btc,1.00000000,2020-01-10T12:00:00Z,2021-01-10T12:00:00Z,366,short,usd,400.00,100.00,300.00
+3,btc,0.50000000,2020-01-10T12:00:00Z,2021-01-10T12:00:01Z,366,long,usd,250.00,50.00,200.00
+5,btc,0.50000000,2020-01-10T12:00:00Z,2021-12-31T23:30:00Z,721,long,usd,250.00,50.00,200.00
+5,btc,1.00000000,2021-06-01T00:00:00Z,2021-12-31T23:30:00Z,213,short,usd,500.00,300.00,200.00
+5,btc,0.50000000,,2021-12-31T23:30:00Z,0,short,usd,250.00,0.00,250.00
+`,
+		},
+		{
+			Form8949,
+			time.UTC,
+			`Description of property,Date acquired,Date sold or disposed of,Proceeds,Cost or other basis,Gain or (loss),Term
+1.00000000 BTC,01/10/2020,01/10/2021,400.00,100.00,300.00,short
+0.50000000 BTC,01/10/2020,01/10/2021,250.00,50.00,200.00,long
+0.50000000 BTC,01/10/2020,12/31/2021,250.00,50.00,200.00,long
+1.00000000 BTC,06/01/2021,12/31/2021,500.00,300.00,200.00,short
+0.50000000 BTC,,12/31/2021,250.00,0.00,250.00,short
+`,
+		},
+		{
+			Form8949,
+			cet,
+			`Description of property,Date acquired,Date sold or disposed of,Proceeds,Cost or other basis,Gain or (loss),Term
+1.00000000 BTC,01/10/2020,01/10/2021,400.00,100.00,300.00,short
+0.50000000 BTC,01/10/2020,01/10/2021,250.00,50.00,200.00,long
+0.50000000 BTC,01/10/2020,01/01/2022,250.00,50.00,200.00,long
+1.00000000 BTC,06/01/2021,01/01/2022,500.00,300.00,200.00,short
+0.50000000 BTC,,01/01/2022,250.00,0.00,250.00,short
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format)+" "+test.loc.String(), func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			if err := test.format.Write(buf, test.loc, list); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.exp {
+				t.Errorf("got\n%s\nexpected\n%s", buf.String(), test.exp)
+			}
+		})
+	}
+
+	if err := Format("pdf").Write(bytes.NewBuffer(nil), time.UTC, list); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,9 @@
+package ws
+
+// Conn is a websocket message source, implemented by Client, Recorder and
+// Replay.
+type Conn interface {
+	Read() (Message, error)
+	Subscribe(Channel) error
+	Unsubscribe(Channel) error
+}
@@ -33,3 +33,18 @@ type LiveTrade struct {
 	Timestamp      generic.UnixString      `json:"timestamp"`
 	MicroTimestamp generic.UnixMicroString `json:"microtimestamp"`
 }
+
+func (m Message) DataOrderBook() (LiveOrderBook, error) {
+	l := &LiveOrderBook{}
+	return *l, json.Unmarshal(m.Data, l)
+}
+
+// LiveOrderBook is a snapshot of the top 100 bids and asks, each level is
+// a price and amount.
+type LiveOrderBook struct {
+	Bids [][2]generic.Float64String `json:"bids"`
+	Asks [][2]generic.Float64String `json:"asks"`
+
+	Timestamp      generic.UnixString      `json:"timestamp"`
+	MicroTimestamp generic.UnixMicroString `json:"microtimestamp"`
+}
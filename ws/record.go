@@ -0,0 +1,221 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recordings start with this header followed by the unix microtimestamp
+// of the start of the recording. Each message is stored as:
+// uvarint microseconds since previous message
+// uvarint length + channel
+// uvarint length + event
+// uvarint length + data
+const recordingHeader = "bitstamp-ws-rec-1\n"
+
+var ErrInvalidRecording = errors.New("invalid websocket recording")
+
+// Recorder wraps a Conn and persists every message it reads.
+type Recorder struct {
+	Conn
+
+	l    sync.Mutex
+	w    *bufio.Writer
+	last time.Time
+	err  error
+}
+
+func NewRecorder(c Conn, w io.Writer) (*Recorder, error) {
+	r := &Recorder{Conn: c, w: bufio.NewWriter(w), last: time.Now()}
+	r.w.WriteString(recordingHeader)
+	r.uvarint(uint64(r.last.UnixNano() / 1e3))
+	return r, r.w.Flush()
+}
+
+func (r *Recorder) uvarint(n uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	r.w.Write(buf[:binary.PutUvarint(buf, n)])
+}
+
+func (r *Recorder) bytes(b []byte) {
+	r.uvarint(uint64(len(b)))
+	r.w.Write(b)
+}
+
+// Read reads the next message from the underlying Conn and records it.
+// Write errors are returned by Err and stop the recording.
+func (r *Recorder) Read() (Message, error) {
+	msg, err := r.Conn.Read()
+	if err != nil {
+		return msg, err
+	}
+
+	now := time.Now()
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.err != nil {
+		return msg, nil
+	}
+
+	r.uvarint(uint64(now.Sub(r.last) / time.Microsecond))
+	r.last = r.last.Add(now.Sub(r.last).Truncate(time.Microsecond))
+	r.bytes([]byte(msg.Channel))
+	r.bytes([]byte(msg.Event))
+	r.bytes(msg.Data)
+	r.err = r.w.Flush()
+
+	return msg, nil
+}
+
+func (r *Recorder) Err() error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.err
+}
+
+// Replay is a Conn that plays back a recording made by Recorder.
+// Messages are only returned for subscribed channels, Read blocks until
+// the first subscription.
+type Replay struct {
+	r     *bufio.Reader
+	speed float64
+
+	started bool
+	start   time.Time
+	first   time.Time
+	current time.Time
+
+	l      sync.Mutex
+	cond   *sync.Cond
+	subs   map[Channel]struct{}
+	closed bool
+}
+
+// NewReplay reads a recording. A speed of 1 plays messages back in real
+// time, 2 twice as fast and 0 as fast as possible.
+func NewReplay(r io.Reader, speed float64) (*Replay, error) {
+	rp := &Replay{
+		r:     bufio.NewReader(r),
+		speed: speed,
+		subs:  make(map[Channel]struct{}),
+	}
+	rp.cond = sync.NewCond(&rp.l)
+
+	header := make([]byte, len(recordingHeader))
+	if _, err := io.ReadFull(rp.r, header); err != nil || string(header) != recordingHeader {
+		return nil, ErrInvalidRecording
+	}
+	start, err := binary.ReadUvarint(rp.r)
+	if err != nil {
+		return nil, ErrInvalidRecording
+	}
+	rp.first = time.Unix(0, int64(start)*1e3)
+	rp.current = rp.first
+
+	return rp, nil
+}
+
+// Time returns the time the last read message was originally received.
+func (r *Replay) Time() time.Time { return r.current }
+
+func (r *Replay) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<26 {
+		return nil, fmt.Errorf("%w: message too large", ErrInvalidRecording)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r.r, b)
+	return b, err
+}
+
+func (r *Replay) next() (Message, error) {
+	var msg Message
+	d, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return msg, err
+	}
+
+	var fields [3][]byte
+	for i := range fields {
+		if fields[i], err = r.bytes(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return msg, err
+		}
+	}
+
+	r.current = r.current.Add(time.Duration(d) * time.Microsecond)
+	msg.Channel, msg.Event, msg.Data = Channel(fields[0]), Event(fields[1]), fields[2]
+	return msg, nil
+}
+
+func (r *Replay) Read() (Message, error) {
+	r.l.Lock()
+	for len(r.subs) == 0 && !r.closed {
+		r.cond.Wait()
+	}
+	closed := r.closed
+	r.l.Unlock()
+	if closed {
+		return Message{}, io.EOF
+	}
+
+	if !r.started {
+		r.started = true
+		r.start = time.Now()
+	}
+
+	for {
+		msg, err := r.next()
+		if err != nil {
+			return msg, err
+		}
+
+		r.l.Lock()
+		_, ok := r.subs[msg.Channel]
+		r.l.Unlock()
+		if !ok {
+			continue
+		}
+
+		if r.speed > 0 {
+			at := r.start.Add(time.Duration(float64(r.current.Sub(r.first)) / r.speed))
+			time.Sleep(time.Until(at))
+		}
+
+		return msg, nil
+	}
+}
+
+func (r *Replay) Subscribe(c Channel) error {
+	r.l.Lock()
+	r.subs[c] = struct{}{}
+	r.l.Unlock()
+	r.cond.Broadcast()
+	return nil
+}
+
+func (r *Replay) Unsubscribe(c Channel) error {
+	r.l.Lock()
+	delete(r.subs, c)
+	r.l.Unlock()
+	return nil
+}
+
+// Close makes pending and future reads return io.EOF.
+func (r *Replay) Close() error {
+	r.l.Lock()
+	r.closed = true
+	r.l.Unlock()
+	r.cond.Broadcast()
+	return nil
+}
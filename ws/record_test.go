@@ -0,0 +1,167 @@
+package ws
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// stubConn returns its messages in order, each after a short delay, and
+// remembers when it returned them.
+type stubConn struct {
+	list  []Message
+	times []time.Time
+}
+
+func (c *stubConn) Read() (Message, error) {
+	if len(c.times) == len(c.list) {
+		return Message{}, io.EOF
+	}
+	time.Sleep(2 * time.Millisecond)
+	c.times = append(c.times, time.Now())
+	return c.list[len(c.times)-1], nil
+}
+
+func (c *stubConn) Subscribe(Channel) error   { return nil }
+func (c *stubConn) Unsubscribe(Channel) error { return nil }
+
+func record(t *testing.T, list []Message) ([]byte, *stubConn, time.Time) {
+	buf := bytes.NewBuffer(nil)
+	stub := &stubConn{list: list}
+	start := time.Now()
+	rec, err := NewRecorder(stub, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range list {
+		if _, err := rec.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rec.Read(); err != io.EOF {
+		t.Fatalf("read past the stub returned %v", err)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), stub, start
+}
+
+func TestRecordReplay(t *testing.T) {
+	trades, books := Channel("live_trades_btcusd"), Channel("order_book_btcusd")
+	list := []Message{
+		{Channel: trades, Event: "trade", Data: []byte(`{"id":1}`)},
+		{Channel: books, Event: "data", Data: []byte(`{"bids":[]}`)},
+		{Channel: trades, Event: "trade", Data: []byte(`{"id":2}`)},
+		{Channel: trades, Event: "bts:subscription_succeeded", Data: []byte(`{}`)},
+		{Channel: books, Event: "data", Data: nil},
+	}
+	data, stub, start := record(t, list)
+
+	near := func(a, b time.Time) bool {
+		d := a.Sub(b)
+		return d > -time.Millisecond && d < time.Millisecond
+	}
+
+	tests := []struct {
+		name string
+		subs []Channel
+	}{
+		{"all", []Channel{trades, books}},
+		{"trades", []Channel{trades}},
+		{"books", []Channel{books}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rp, err := NewReplay(bytes.NewReader(data), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !near(rp.Time(), start) {
+				t.Errorf("recording started at %s, expected %s", rp.Time(), start)
+			}
+			subs := make(map[Channel]bool)
+			for _, c := range test.subs {
+				subs[c] = true
+				rp.Subscribe(c)
+			}
+
+			began := time.Now()
+			for i, exp := range list {
+				if !subs[exp.Channel] {
+					continue
+				}
+				msg, err := rp.Read()
+				if err != nil {
+					t.Fatalf("message %d: %s", i, err)
+				}
+				if msg.Channel != exp.Channel || msg.Event != exp.Event || !bytes.Equal(msg.Data, exp.Data) {
+					t.Errorf("message %d: %s %s %s, expected %s %s %s", i, msg.Channel, msg.Event, msg.Data, exp.Channel, exp.Event, exp.Data)
+				}
+				if !near(rp.Time(), stub.times[i]) {
+					t.Errorf("message %d: received at %s, expected %s", i, rp.Time(), stub.times[i])
+				}
+			}
+			if _, err := rp.Read(); err != io.EOF {
+				t.Errorf("read past the end returned %v, expected %v", err, io.EOF)
+			}
+			if d, rec := time.Since(began), stub.times[len(list)-1].Sub(start); d >= rec {
+				t.Errorf("replay at speed 0 took %s, as long as the recording", d)
+			}
+		})
+	}
+}
+
+func TestReplayTruncated(t *testing.T) {
+	c := Channel("live_trades_btcusd")
+	list := []Message{
+		{Channel: c, Event: "trade", Data: []byte(`{"id":1}`)},
+		{Channel: c, Event: "trade", Data: []byte(`{"id":2}`)},
+	}
+	data, _, _ := record(t, list)
+
+	for cut := 1; cut < len(`{"id":2}`)+len("trade")+len(c)+3; cut++ {
+		rp, err := NewReplay(bytes.NewReader(data[:len(data)-cut]), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rp.Subscribe(c)
+		if _, err := rp.Read(); err != nil {
+			t.Fatalf("cut %d: first message: %s", cut, err)
+		}
+		if _, err := rp.Read(); err != io.ErrUnexpectedEOF {
+			t.Fatalf("cut %d: truncated message returned %v, expected %v", cut, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	for _, invalid := range [][]byte{nil, []byte("bitstamp-ws-rec-0\n\x01"), data[:len(recordingHeader)]} {
+		if _, err := NewReplay(bytes.NewReader(invalid), 0); !errors.Is(err, ErrInvalidRecording) {
+			t.Errorf("%q: %v, expected %v", invalid, err, ErrInvalidRecording)
+		}
+	}
+}
+
+func TestReplayClose(t *testing.T) {
+	data, _, _ := record(t, nil)
+	rp, err := NewReplay(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := rp.Read()
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		t.Fatalf("read without a subscription returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	rp.Close()
+	if err := <-errs; err != io.EOF {
+		t.Errorf("read after close returned %v, expected %v", err, io.EOF)
+	}
+}